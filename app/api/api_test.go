@@ -1,32 +1,47 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
 	"strings"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 
+	"github.com/42-Short/shortinette/apperr"
+	"github.com/42-Short/shortinette/client"
 	"github.com/42-Short/shortinette/config"
 	"github.com/42-Short/shortinette/dao"
 	"github.com/42-Short/shortinette/db"
+	"github.com/42-Short/shortinette/intra"
 	"github.com/42-Short/shortinette/logger"
+	"github.com/42-Short/shortinette/tester"
 )
 
 var api *API
 
-var apiToken string
+var (
+	apiToken      string
+	webhookSecret string
+)
 
 func shutdown(sigCh chan os.Signal, errCh chan error) {
 	select {
@@ -79,7 +94,7 @@ func TestMain(m *testing.M) {
 	}
 	defer db.Close()
 
-	err = db.Initialize("../db/schema.sql")
+	err = db.Migrate(context.Background())
 	if err != nil {
 		logger.Error.Fatalf("failed to initialize db: %v", err)
 	}
@@ -97,7 +112,20 @@ func TestMain(m *testing.M) {
 		logger.Error.Fatalf("failed to create dummy config: %v", err)
 	}
 
-	apiToken = config.ApiToken
+	apiToken = config.ApiToken.Current()
+	webhookSecret = config.WebhookSecret.Current()
+
+	standIn := httptest.NewServer(newIntraStandIn())
+	defer standIn.Close()
+	config.IntraURL = standIn.URL
+	config.IntraClientID = "client id"
+	config.IntraClientSecret = "client secret"
+	config.IntraRedirectURL = "http://localhost/shortinette/auth/callback"
+	config.IntraStaffRoles = []string{intra.StaffRole, "bocal"}
+	config.IntraCampusID = 1
+	config.SessionDuration = time.Hour
+	config.RegistrationOpens = time.Now().Add(-time.Hour)
+	config.RegistrationCloses = time.Now().Add(time.Hour)
 
 	api = NewAPI(config, db, gin.TestMode)
 	api.SetupRouter()
@@ -127,6 +155,102 @@ func TestMain(m *testing.M) {
 // 	assert.Equal(t, http.StatusProcessing, response.Code, response.Body)
 // }
 
+func TestWebhookPing(t *testing.T) {
+	response := serveWebhook(t, "ping", uuid.NewString(), gin.H{"zen": "Keep it logically awesome."})
+	assert.Equal(t, http.StatusOK, response.Code, response.Body)
+}
+
+func TestWebhookUnsupportedEvent(t *testing.T) {
+	response := serveWebhook(t, "issues", uuid.NewString(), gin.H{"action": "opened"})
+	assert.Equal(t, http.StatusBadRequest, response.Code, response.Body)
+}
+
+func TestWebhookMissingDeliveryID(t *testing.T) {
+	response := serveWebhook(t, "push", "", newPushPayload("refs/heads/feature", time.Now()))
+	assert.Equal(t, http.StatusBadRequest, response.Code, response.Body)
+}
+
+func TestWebhookDuplicateDelivery(t *testing.T) {
+	deliveryID := uuid.NewString()
+	payload := newPushPayload("refs/heads/feature", time.Now())
+
+	response := serveWebhook(t, "push", deliveryID, payload)
+	assert.Equal(t, http.StatusOK, response.Code, response.Body)
+
+	response = serveWebhook(t, "push", deliveryID, payload)
+	assert.Equal(t, http.StatusConflict, response.Code, response.Body)
+}
+
+func TestWebhookStaleDelivery(t *testing.T) {
+	deliveryID := uuid.NewString()
+	response := serveWebhook(t, "push", deliveryID, newPushPayload("refs/heads/main", time.Now().Add(-time.Hour)))
+	assert.Equal(t, http.StatusBadRequest, response.Code, response.Body)
+
+	delivery, err := dao.NewDAO[dao.WebhookDelivery](api.DB).Get(context.Background(), deliveryID)
+	require.NoError(t, err)
+	assert.Equal(t, "rejected: stale", delivery.Outcome)
+
+	// Failed deliveries may be redelivered by GitHub
+	response = serveWebhook(t, "push", deliveryID, newPushPayload("refs/heads/feature", time.Now()))
+	assert.Equal(t, http.StatusOK, response.Code, response.Body)
+	delivery, err = dao.NewDAO[dao.WebhookDelivery](api.DB).Get(context.Background(), deliveryID)
+	require.NoError(t, err)
+	assert.Equal(t, "ignored: not a push to main", delivery.Outcome)
+
+	response = serveWebhook(t, "push", deliveryID, newPushPayload("refs/heads/feature", time.Now()))
+	assert.Equal(t, http.StatusConflict, response.Code, "deliveries which went through should not be redelivered")
+}
+
+func TestGetRecentDeliveries(t *testing.T) {
+	deliveryID := uuid.NewString()
+	response := serveWebhook(t, "push", deliveryID, newPushPayload("refs/heads/feature", time.Now()))
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+
+	response = serveRequest(t, "GET", "/shortinette/v1/webhook/deliveries?limit=1", nil, apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+
+	var deliveries []dao.WebhookDelivery
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &deliveries))
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, deliveryID, deliveries[0].DeliveryId)
+	assert.Equal(t, "ignored: not a push to main", deliveries[0].Outcome)
+}
+
+func TestWebhookSignedWithApiToken(t *testing.T) {
+	body, err := json.Marshal(newPushPayload("refs/heads/feature", time.Now()))
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/shortinette/webhook/grademe", strings.NewReader(string(body)))
+	require.NoError(t, err)
+	mac := hmac.New(sha256.New, []byte(apiToken))
+	mac.Write(body)
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("X-GitHub-Delivery", uuid.NewString())
+
+	response := httptest.NewRecorder()
+	api.Engine.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code, "the API token must not be usable as webhook secret")
+}
+
+func TestWebhookSecretAsApiToken(t *testing.T) {
+	response := serveRequest(t, "GET", "/shortinette/v1/participants", nil, webhookSecret)
+	assert.Equal(t, http.StatusUnauthorized, response.Code, "the webhook secret must not be usable as API token")
+}
+
+func TestRejectedTokenNotLogged(t *testing.T) {
+	var logs bytes.Buffer
+	logger.Warning.SetOutput(&logs)
+	defer logger.Warning.SetOutput(os.Stderr)
+
+	const token = "almost-the-right-token"
+	response := serveRequest(t, "GET", "/shortinette/v1/participants", nil, token)
+	require.Equal(t, http.StatusUnauthorized, response.Code)
+
+	assert.NotContains(t, logs.String(), token)
+	assert.Contains(t, logs.String(), hashToken(token)[:12])
+}
+
 func TestGrademe(t *testing.T) {
 	const (
 		intraLogin = "dummy_participant5"
@@ -142,7 +266,7 @@ func TestPostParticipant(t *testing.T) {
 }
 
 func TestPostModule(t *testing.T) {
-	testPost(t, dao.NewDummyModule(42, "dummy_participant5"), "/shortinette/v1/modules")
+	testPost(t, dao.NewDummyModule(1, "dummy_participant42"), "/shortinette/v1/modules")
 }
 
 func TestGetAllParticipants(t *testing.T) {
@@ -153,6 +277,47 @@ func TestGetAllModules(t *testing.T) {
 	testGetAll[dao.Module](t, "/shortinette/v1/modules")
 }
 
+func TestListPagination(t *testing.T) {
+	shortinette := newTestClient(t, apiToken)
+
+	var modules []client.Module
+	query := url.Values{"intra_login": {"dummy_participant9"}, "sort": {"-id"}, "limit": {"3"}}
+	for {
+		current, err := shortinette.ListModules(context.Background(), query)
+		require.NoError(t, err)
+		assert.Equal(t, 7, current.Total)
+		assert.LessOrEqual(t, len(current.Items), 3)
+		modules = append(modules, current.Items...)
+
+		if current.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", current.NextCursor)
+	}
+
+	require.Len(t, modules, 7)
+	for idx, module := range modules {
+		assert.Equal(t, "dummy_participant9", module.IntraLogin)
+		assert.Equal(t, 6-idx, module.ID, "modules should be sorted by descending id")
+	}
+}
+
+func TestListEmpty(t *testing.T) {
+	response := serveRequest(t, "GET", "/shortinette/v1/participants?github_login=nobody", nil, apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	assert.JSONEq(t, `{"items": [], "total": 0}`, response.Body.String())
+}
+
+func TestListInvalidQuery(t *testing.T) {
+	for _, query := range []string{"limit=0", "limit=many", "cursor=nope", "sort=password", "password=hunter2", "current_module_id=first"} {
+		response := serveRequest(t, "GET", "/shortinette/v1/participants?"+query, nil, apiToken)
+		assert.Equal(t, http.StatusBadRequest, response.Code, query)
+	}
+
+	response := serveRequest(t, "GET", "/shortinette/v1/tokens?hash=0", nil, apiToken)
+	assert.Equal(t, http.StatusBadRequest, response.Code, "hidden columns should not be filterable")
+}
+
 func TestGetModule(t *testing.T) {
 	const (
 		intraLogin = "dummy_participant5"
@@ -181,12 +346,6 @@ func TestPutModule(t *testing.T) {
 	testPut[dao.Module](t, "/shortinette/v1/modules", moduleID, intraLogin)
 }
 
-func TestDeleteParticipant(t *testing.T) {
-	const intraLogin = "dummy_participant5"
-	url := fmt.Sprintf("/shortinette/v1/participants/%s", intraLogin)
-	testDelete[dao.Participant](t, url, intraLogin)
-}
-
 func TestDeleteModule(t *testing.T) {
 	const (
 		intraLogin = "dummy_participant5"
@@ -196,12 +355,951 @@ func TestDeleteModule(t *testing.T) {
 	testDelete[dao.Module](t, url, intraLogin)
 }
 
+func TestDeleteParticipant(t *testing.T) {
+	const intraLogin = "dummy_participant5"
+	url := fmt.Sprintf("/shortinette/v1/participants/%s", intraLogin)
+	testDelete[dao.Participant](t, url, intraLogin)
+}
+
 func TestUnauthorized(t *testing.T) {
 	response := serveRequest(t, "GET", "/shortinette/v1/participants", nil, "foo")
 
 	assert.Equal(t, http.StatusUnauthorized, response.Code, response.Body)
 }
 
+func TestProblemResponse(t *testing.T) {
+	response := serveRequest(t, "GET", "/shortinette/v1/participants/nobody", nil, apiToken)
+	require.Equal(t, http.StatusNotFound, response.Code, response.Body)
+	assert.Equal(t, problemContentType, response.Header().Get("Content-Type"))
+
+	var problem map[string]any
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &problem))
+	assert.Equal(t, "about:blank", problem["type"])
+	assert.Equal(t, "Not Found", problem["title"])
+	assert.Equal(t, float64(http.StatusNotFound), problem["status"])
+	assert.Contains(t, problem["detail"], "failed to get participant")
+	assert.Equal(t, "/shortinette/v1/participants/nobody", problem["instance"])
+	assert.NotEmpty(t, problem["request_id"])
+	assert.Equal(t, response.Header().Get(requestIDHeader), problem["request_id"])
+}
+
+func TestProblemStatuses(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		body   string
+		status int
+	}{
+		{"POST", "/shortinette/v1/participants", `{"intra_login": "dummy_participant1", "github_login": "dummy"}`, http.StatusConflict},
+		{"POST", "/shortinette/v1/participants", `{"intra_login": `, http.StatusBadRequest},
+		{"PUT", "/shortinette/v1/participants", `{"intra_login": "nobody", "github_login": "nobody"}`, http.StatusNotFound},
+		{"DELETE", "/shortinette/v1/participants/nobody", "", http.StatusNotFound},
+		{"GET", "/shortinette/v1/nothing-here", "", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		response := serveRequest(t, test.method, test.url, strings.NewReader(test.body), apiToken)
+		assert.Equal(t, test.status, response.Code, "%s %s: %s", test.method, test.url, response.Body)
+		assert.Equal(t, problemContentType, response.Header().Get("Content-Type"), "%s %s", test.method, test.url)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	send := func(requestID string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/shortinette/v1/participants", nil)
+		require.NoError(t, err)
+		req.Header.Set(requestIDHeader, requestID)
+
+		response := httptest.NewRecorder()
+		api.Engine.ServeHTTP(response, req)
+		return response
+	}
+
+	response := send("lb-1234.abcd")
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+	assert.Equal(t, "lb-1234.abcd", response.Header().Get(requestIDHeader), "request IDs sent by the caller should be kept")
+	assert.Contains(t, response.Body.String(), `"request_id":"lb-1234.abcd"`)
+
+	response = send("forged\nline")
+	assert.NotEqual(t, "forged\nline", response.Header().Get(requestIDHeader), "unsafe request IDs should be replaced")
+	assert.NotEmpty(t, response.Header().Get(requestIDHeader))
+}
+
+func TestIssueTokenInvalidScope(t *testing.T) {
+	body := strings.NewReader(`{"name": "foo", "scope": "superuser"}`)
+	response := serveRequest(t, "POST", "/shortinette/v1/tokens", body, apiToken)
+	assert.Equal(t, http.StatusBadRequest, response.Code, response.Body)
+}
+
+func TestIssueStudentTokenUnknownParticipant(t *testing.T) {
+	body := strings.NewReader(`{"name": "foo", "scope": "student-self-service", "intra_login": "nobody"}`)
+	response := serveRequest(t, "POST", "/shortinette/v1/tokens", body, apiToken)
+	assert.Equal(t, http.StatusBadRequest, response.Code, response.Body)
+}
+
+func TestStaffReadonlyToken(t *testing.T) {
+	token, _ := issueToken(t, scopeStaffReadonly, "")
+
+	assert.Equal(t, http.StatusOK, serveRequest(t, "GET", "/shortinette/v1/participants", nil, token).Code)
+	assert.Equal(t, http.StatusOK, serveRequest(t, "GET", "/shortinette/v1/participants/dummy_participant3", nil, token).Code)
+
+	participant, err := json.Marshal(dao.NewDummyParticipant(43))
+	require.NoError(t, err)
+	response := serveRequest(t, "POST", "/shortinette/v1/participants", strings.NewReader(string(participant)), token)
+	assert.Equal(t, http.StatusForbidden, response.Code, response.Body)
+	assert.Equal(t, http.StatusForbidden, serveRequest(t, "GET", "/shortinette/v1/tokens", nil, token).Code)
+}
+
+func TestStudentToken(t *testing.T) {
+	token, _ := issueToken(t, scopeStudent, "dummy_participant3")
+
+	assert.Equal(t, http.StatusOK, serveRequest(t, "GET", "/shortinette/v1/participants/dummy_participant3", nil, token).Code)
+	assert.Equal(t, http.StatusOK, serveRequest(t, "GET", "/shortinette/v1/modules/0/dummy_participant3", nil, token).Code)
+
+	response := serveRequest(t, "GET", "/shortinette/v1/participants/dummy_participant3/modules", nil, token)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	var modules []dao.Module
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &modules))
+	require.NotEmpty(t, modules)
+	for _, module := range modules {
+		assert.Equal(t, "dummy_participant3", module.IntraLogin)
+	}
+
+	assert.Equal(t, http.StatusForbidden, serveRequest(t, "GET", "/shortinette/v1/participants/dummy_participant4", nil, token).Code)
+	assert.Equal(t, http.StatusForbidden, serveRequest(t, "GET", "/shortinette/v1/participants", nil, token).Code)
+	assert.Equal(t, http.StatusForbidden, serveRequest(t, "DELETE", "/shortinette/v1/participants/dummy_participant3", nil, token).Code)
+}
+
+func TestGraderTriggerToken(t *testing.T) {
+	token, _ := issueToken(t, scopeGraderTrigger, "")
+	assert.Equal(t, http.StatusForbidden, serveRequest(t, "GET", "/shortinette/v1/participants", nil, token).Code)
+}
+
+func TestRevokedToken(t *testing.T) {
+	token, id := issueToken(t, scopeStaffReadonly, "")
+	require.Equal(t, http.StatusOK, serveRequest(t, "GET", "/shortinette/v1/participants", nil, token).Code)
+
+	response := serveRequest(t, "DELETE", "/shortinette/v1/tokens/"+id, nil, apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+
+	assert.Equal(t, http.StatusUnauthorized, serveRequest(t, "GET", "/shortinette/v1/participants", nil, token).Code)
+}
+
+func TestIntraLoginStudent(t *testing.T) {
+	session := intraLogin(t, "dummy_participant2")
+
+	response := serveSessionRequest(t, "GET", "/shortinette/v1/me", session)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	var me meResponse
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &me))
+	assert.Equal(t, scopeStudent, me.Scope)
+	require.NotNil(t, me.Participant)
+	assert.Equal(t, "dummy_participant2", me.Participant.IntraLogin)
+	require.NotEmpty(t, me.Modules)
+	for _, module := range me.Modules {
+		assert.Equal(t, "dummy_participant2", module.IntraLogin)
+	}
+
+	assert.Equal(t, http.StatusOK, serveSessionRequest(t, "GET", "/shortinette/v1/participants/dummy_participant2/modules", session).Code)
+	assert.Equal(t, http.StatusForbidden, serveSessionRequest(t, "GET", "/shortinette/v1/participants/dummy_participant4", session).Code)
+	assert.Equal(t, http.StatusForbidden, serveSessionRequest(t, "GET", "/shortinette/v1/participants", session).Code)
+}
+
+func TestIntraLoginStaff(t *testing.T) {
+	for _, login := range []string{"staff_flag", "staff_group"} {
+		session := intraLogin(t, login)
+
+		response := serveSessionRequest(t, "GET", "/shortinette/v1/me", session)
+		require.Equal(t, http.StatusOK, response.Code, response.Body)
+		var me meResponse
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &me))
+		assert.Equal(t, scopeAdmin, me.Scope)
+		assert.Nil(t, me.Participant)
+
+		assert.Equal(t, http.StatusOK, serveSessionRequest(t, "GET", "/shortinette/v1/participants", session).Code)
+	}
+}
+
+func TestIntraLoginOtherCampus(t *testing.T) {
+	response := serveIntraCallback(t, "other_campus")
+	assert.Equal(t, http.StatusForbidden, response.Code, response.Body)
+}
+
+func TestIntraLoginInvalidState(t *testing.T) {
+	req, err := http.NewRequest("GET", "/shortinette/auth/callback?code=dummy_participant2&state=forged", nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: stateCookie, Value: "expected"})
+
+	response := httptest.NewRecorder()
+	api.Engine.ServeHTTP(response, req)
+	assert.Equal(t, http.StatusBadRequest, response.Code, response.Body)
+}
+
+func TestIntraLogout(t *testing.T) {
+	session := intraLogin(t, "dummy_participant2")
+	require.Equal(t, http.StatusOK, serveSessionRequest(t, "GET", "/shortinette/v1/me", session).Code)
+
+	response := serveSessionRequest(t, "POST", "/shortinette/auth/logout", session)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+
+	assert.Equal(t, http.StatusUnauthorized, serveSessionRequest(t, "GET", "/shortinette/v1/me", session).Code)
+}
+
+func TestRegister(t *testing.T) {
+	stubGithubAccounts(t, map[string]string{"newcomer-gh": ""})
+	session := intraLogin(t, "newcomer")
+
+	response := serveSessionJSON(t, "POST", "/shortinette/v1/registration", `{"github_login": "newcomer-gh"}`, session)
+	require.Equal(t, http.StatusCreated, response.Code, response.Body)
+
+	response = serveSessionRequest(t, "GET", "/shortinette/v1/me", session)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	var me meResponse
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &me))
+	require.NotNil(t, me.Participant)
+	assert.Equal(t, "newcomer-gh", me.Participant.GitHubLogin)
+
+	response = serveSessionJSON(t, "POST", "/shortinette/v1/registration", `{"github_login": "newcomer-gh"}`, session)
+	assert.Equal(t, http.StatusConflict, response.Code, response.Body)
+
+	registration, err := dao.NewDAO[dao.Registration](api.DB).Get(context.Background(), "newcomer")
+	require.NoError(t, err)
+	assert.Equal(t, registrationRegistered, registration.Status)
+	assert.Equal(t, verificationNone, registration.Verification)
+	assert.NotNil(t, registration.RegisteredAt)
+}
+
+func TestRegisterGithubLoginCase(t *testing.T) {
+	stubGithubAccounts(t, map[string]string{"upper-case-gh": "", "case-taken-gh": ""})
+	require.NoError(t, dao.NewDAO[dao.Participant](api.DB).Insert(context.Background(), dao.Participant{IntraLogin: "case_taken", GitHubLogin: "Case-Taken-GH"}))
+
+	response := serveSessionJSON(t, "POST", "/shortinette/v1/registration", `{"github_login": "case-taken-gh"}`, intraLogin(t, "case_thief"))
+	assert.Equal(t, http.StatusConflict, response.Code, response.Body)
+
+	response = serveSessionJSON(t, "POST", "/shortinette/v1/registration", `{"github_login": "Upper-Case-GH"}`, intraLogin(t, "upper_case"))
+	require.Equal(t, http.StatusCreated, response.Code, response.Body)
+	participant, err := dao.NewDAO[dao.Participant](api.DB).Get(context.Background(), "upper_case")
+	require.NoError(t, err)
+	assert.Equal(t, "upper-case-gh", participant.GitHubLogin, "GitHub logins should be stored in lower case")
+}
+
+func TestRegisterUnknownGithubAccount(t *testing.T) {
+	stubGithubAccounts(t, map[string]string{})
+	session := intraLogin(t, "unknown_account")
+
+	response := serveSessionJSON(t, "POST", "/shortinette/v1/registration", `{"github_login": "does-not-exist"}`, session)
+	assert.Equal(t, http.StatusBadRequest, response.Code, response.Body)
+
+	response = serveSessionJSON(t, "POST", "/shortinette/v1/registration", `{"github_login": "../../orgs/42-Short"}`, session)
+	assert.Equal(t, http.StatusBadRequest, response.Code, response.Body)
+}
+
+func TestRegisterAsStaff(t *testing.T) {
+	stubGithubAccounts(t, map[string]string{"staff-gh": ""})
+	session := intraLogin(t, "staff_flag")
+
+	response := serveSessionJSON(t, "POST", "/shortinette/v1/registration", `{"github_login": "staff-gh"}`, session)
+	assert.Equal(t, http.StatusForbidden, response.Code, response.Body)
+}
+
+func TestRegisterWithGist(t *testing.T) {
+	gists := map[string]string{}
+	stubGithubAccounts(t, gists)
+
+	conf := *api.config
+	conf.RegistrationVerifyGist = true
+	participantDao := dao.NewDAO[dao.Participant](api.DB)
+	registrationDao := dao.NewDAO[dao.Registration](api.DB)
+
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set(principalKey, principal{Name: "intra:gist_user", Scope: scopeStudent, IntraLogin: "gist_user"})
+	})
+	engine.POST("/registration", registerHandler(participantDao, registrationDao, conf))
+	engine.POST("/registration/verify", verifyRegistrationHandler(participantDao, registrationDao, conf))
+	serve := func(url string, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", url, strings.NewReader(body))
+		require.NoError(t, err)
+		response := httptest.NewRecorder()
+		engine.ServeHTTP(response, req)
+		return response
+	}
+
+	gists["gist-user"] = ""
+	response := serve("/registration", `{"github_login": "gist-user"}`)
+	require.Equal(t, http.StatusAccepted, response.Code, response.Body)
+	var challenge registrationChallengeResponse
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &challenge))
+	require.NotEmpty(t, challenge.Challenge)
+
+	response = serve("/registration/verify", "")
+	assert.Equal(t, http.StatusBadRequest, response.Code, response.Body)
+	_, err := participantDao.Get(context.Background(), "gist_user")
+	require.Error(t, err, "participant should not exist before verification")
+
+	gists["gist-user"] = challenge.Challenge
+	response = serve("/registration/verify", "")
+	require.Equal(t, http.StatusCreated, response.Code, response.Body)
+
+	registration, err := registrationDao.Get(context.Background(), "gist_user")
+	require.NoError(t, err)
+	assert.Equal(t, registrationRegistered, registration.Status)
+	assert.Equal(t, verificationGist, registration.Verification)
+}
+
+// Replaces GitHub lookups: the keys are the existing accounts, the values the description
+// of their gist.
+type stubAccounts map[string]string
+
+func (gists stubAccounts) DoesAccountExist(ctx context.Context, username string) (bool, error) {
+	_, exists := gists[username]
+	return exists, nil
+}
+
+func (gists stubAccounts) HasGistWithDescription(ctx context.Context, username string, description string) (bool, error) {
+	return gists[username] == description, nil
+}
+
+func stubGithubAccounts(t *testing.T, gists map[string]string) {
+	t.Helper()
+
+	previous := newGithubAccounts
+	t.Cleanup(func() { newGithubAccounts = previous })
+	newGithubAccounts = func(conf config.Config) (githubAccounts, error) {
+		return stubAccounts(gists), nil
+	}
+}
+
+func serveSessionJSON(t *testing.T, method string, url string, body string, session *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err, fmt.Sprintf("failed to make request: %s", url))
+	req.AddCookie(session)
+
+	response := httptest.NewRecorder()
+	api.Engine.ServeHTTP(response, req)
+	return response
+}
+
+func TestImportParticipantsDryRun(t *testing.T) {
+	stubGithubAccounts(t, map[string]string{"dry-run-gh": ""})
+
+	body := "intra_login,github_login\ndry_run_participant,dry-run-gh\n"
+	response := serveImport(t, "/shortinette/v1/participants/import?dry_run=true", "text/csv", body)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+
+	var report importReport
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &report))
+	assert.Equal(t, 1, report.Created)
+	assert.False(t, report.Applied)
+
+	_, err := dao.NewDAO[dao.Participant](api.DB).Get(context.Background(), "dry_run_participant")
+	assert.Error(t, err, "dry runs should not import anything")
+}
+
+func TestImportParticipants(t *testing.T) {
+	stubGithubAccounts(t, map[string]string{"import-one-gh": "", "import-two-gh": ""})
+
+	body := `[{"intra_login": "import_one", "github_login": "import-one-gh"}, {"intra_login": "import_two", "github_login": "Import-Two-GH"}]`
+	response := serveImport(t, "/shortinette/v1/participants/import", "application/json", body)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+
+	var report importReport
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &report))
+	assert.Equal(t, 2, report.Created)
+	assert.True(t, report.Applied)
+
+	participant, err := dao.NewDAO[dao.Participant](api.DB).Get(context.Background(), "import_two")
+	require.NoError(t, err)
+	assert.Equal(t, "import-two-gh", participant.GitHubLogin, "GitHub logins should be stored in lower case")
+
+	response = serveImport(t, "/shortinette/v1/participants/import", "application/json", body)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &report))
+	assert.Equal(t, 2, report.Unchanged)
+}
+
+func TestImportParticipantsInvalidRows(t *testing.T) {
+	stubGithubAccounts(t, map[string]string{"invalid-one-gh": "", "taken-gh": "", "invalid-six-gh": ""})
+	require.NoError(t, dao.NewDAO[dao.Participant](api.DB).Insert(context.Background(), dao.Participant{IntraLogin: "taken", GitHubLogin: "Taken-GH"}))
+
+	body := strings.Join([]string{
+		"intra_login,github_login",
+		"invalid_one,invalid-one-gh",
+		"invalid_one,other-gh",       // duplicate intra login
+		"invalid_two,invalid-one-gh", // duplicate github login
+		"invalid_three,does-not-exist",
+		"invalid_four,taken-gh",
+		"invalid_five,",
+		"invalid six,invalid-six-gh",   // rejected by the participant's own rules
+		"invalid_seven,INVALID-ONE-GH", // GitHub logins are case-insensitive
+	}, "\n")
+	response := serveImport(t, "/shortinette/v1/participants/import", "text/csv", body)
+	require.Equal(t, http.StatusUnprocessableEntity, response.Code, response.Body)
+
+	var report importReport
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &report))
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 7, report.Invalid)
+	assert.False(t, report.Applied)
+	for _, row := range report.Rows[1:] {
+		assert.Equal(t, importInvalid, row.Action, row)
+		assert.NotEmpty(t, row.Error, row)
+	}
+
+	_, err := dao.NewDAO[dao.Participant](api.DB).Get(context.Background(), "invalid_one")
+	assert.Error(t, err, "nothing should be imported if any row is invalid")
+}
+
+// Stands for a participant added while an import was being validated.
+type racingAccounts struct {
+	stubAccounts
+	participantDao *dao.DAO[dao.Participant]
+}
+
+func (accounts racingAccounts) DoesAccountExist(ctx context.Context, username string) (bool, error) {
+	if username == "atomic-two-gh" {
+		if err := accounts.participantDao.Insert(ctx, dao.Participant{IntraLogin: "atomic_two", GitHubLogin: "other-gh"}); err != nil {
+			return false, err
+		}
+	}
+	return accounts.stubAccounts.DoesAccountExist(ctx, username)
+}
+
+func TestImportParticipantsAtomic(t *testing.T) {
+	participantDao := dao.NewDAO[dao.Participant](api.DB)
+	previous := newGithubAccounts
+	t.Cleanup(func() { newGithubAccounts = previous })
+	newGithubAccounts = func(conf config.Config) (githubAccounts, error) {
+		return racingAccounts{stubAccounts{"atomic-one-gh": "", "atomic-two-gh": ""}, participantDao}, nil
+	}
+
+	body := "intra_login,github_login\natomic_one,atomic-one-gh\natomic_two,atomic-two-gh\n"
+	response := serveImport(t, "/shortinette/v1/participants/import", "text/csv", body)
+	require.Equal(t, http.StatusConflict, response.Code, response.Body)
+	assert.Contains(t, response.Body.String(), `"report"`)
+
+	_, err := participantDao.Get(context.Background(), "atomic_one")
+	assert.True(t, apperr.Is(err, apperr.NotFound), "rows before the failing one should not be imported: %v", err)
+}
+
+func TestExportParticipants(t *testing.T) {
+	response := serveRequest(t, "GET", "/shortinette/v1/participants/export?format=csv", nil, apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	assert.Equal(t, "text/csv", response.Header().Get("Content-Type"))
+
+	lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+	assert.Equal(t, strings.Join(rosterHeader, ","), lines[0])
+	assert.Contains(t, lines, "dummy_participant0,dummy_git_dummy_participant0,0,0,0")
+
+	response = serveRequest(t, "GET", "/shortinette/v1/participants/export", nil, apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	var roster []rosterEntry
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &roster))
+	assert.Len(t, roster, len(lines)-1)
+}
+
+func serveImport(t *testing.T, url string, contentType string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	require.NoError(t, err, fmt.Sprintf("failed to make request: %s", url))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiToken))
+	req.Header.Set("Content-Type", contentType)
+
+	response := httptest.NewRecorder()
+	api.Engine.ServeHTTP(response, req)
+	return response
+}
+
+func TestParticipantStatus(t *testing.T) {
+	url := "/shortinette/v1/participants/dummy_participant6/status"
+
+	response := serveRequest(t, "PUT", url, strings.NewReader(`{"status": "on-holiday"}`), apiToken)
+	assert.Equal(t, http.StatusBadRequest, response.Code, response.Body)
+
+	response = serveRequest(t, "PUT", url, strings.NewReader(`{"status": "active", "revoke_access": true}`), apiToken)
+	assert.Equal(t, http.StatusBadRequest, response.Code, response.Body)
+
+	response = serveRequest(t, "PUT", url, strings.NewReader(`{"status": "withdrawn"}`), apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	var participant dao.Participant
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &participant))
+	assert.Equal(t, dao.ParticipantWithdrawn, participant.Status)
+
+	response = serveRequest(t, "POST", "/shortinette/v1/modules/0/dummy_participant6/grademe", nil, apiToken)
+	assert.Equal(t, http.StatusForbidden, response.Code, response.Body)
+
+	response = serveRequest(t, "POST", "/shortinette/v1/participants/dummy_participant6/provision", nil, apiToken)
+	assert.Equal(t, http.StatusConflict, response.Code, response.Body)
+
+	response = serveRequest(t, "PUT", url, strings.NewReader(`{"status": "active"}`), apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+}
+
+func TestReopenModule(t *testing.T) {
+	response := serveRequest(t, "POST", "/shortinette/v1/modules/42/dummy_participant7/reopen", nil, apiToken)
+	assert.Equal(t, http.StatusNotFound, response.Code, response.Body)
+
+	token, _ := issueToken(t, scopeStaffReadonly, "")
+	response = serveRequest(t, "POST", "/shortinette/v1/modules/0/dummy_participant7/reopen", nil, token)
+	assert.Equal(t, http.StatusForbidden, response.Code, response.Body)
+
+	grade := dao.FinalGrade{ModuleId: 6, IntraLogin: "dummy_participant7", Rule: "best", FrozenAt: time.Now()}
+	require.NoError(t, dao.NewDAO[dao.FinalGrade](api.DB).Insert(context.Background(), grade))
+	response = serveRequest(t, "POST", "/shortinette/v1/modules/6/dummy_participant7/reopen", nil, apiToken)
+	assert.Equal(t, http.StatusConflict, response.Code, "modules with a frozen grade should not be reopened: %s", response.Body)
+}
+
+func TestRecordGradingAtomic(t *testing.T) {
+	ctx := context.Background()
+	mg := moduleGrader{
+		moduleDao:      dao.NewDAO[dao.Module](api.DB),
+		participantDao: dao.NewDAO[dao.Participant](api.DB),
+		attemptDao:     dao.NewDAO[dao.Attempt](api.DB),
+		auditDao:       dao.NewDAO[dao.AuditEntry](api.DB),
+		actor:          t.Name(),
+		ctx:            ctx,
+	}
+
+	module, err := mg.moduleDao.Get(ctx, 0, "dummy_participant11")
+	require.NoError(t, err)
+	result := tester.GradingResult{Score: 20, Passed: true}
+	attempt := dao.Attempt{Id: uuid.NewString(), ModuleId: 0, IntraLogin: "dummy_participant11", Score: 20, Passed: true, GradedAt: time.Now()}
+
+	// Stands for a crash after the module was updated
+	missing := &dao.Participant{IntraLogin: "dummy_participant_missing"}
+	require.Error(t, mg.record(module, missing, result, attempt))
+
+	stored, err := mg.moduleDao.Get(ctx, 0, "dummy_participant11")
+	require.NoError(t, err)
+	assert.Equal(t, 0, stored.Score, "the module should not be updated if the participant cannot be")
+	_, err = mg.attemptDao.Get(ctx, attempt.Id)
+	assert.True(t, apperr.Is(err, apperr.NotFound), "the attempt should not be recorded if the participant cannot be updated")
+
+	participant, err := mg.participantDao.Get(ctx, "dummy_participant11")
+	require.NoError(t, err)
+
+	// Changed while the grading ran, must not be overwritten with what was read before
+	changed := *stored
+	changed.Attempts, changed.Reopened = 3, true
+	require.NoError(t, mg.moduleDao.Update(ctx, changed))
+
+	require.NoError(t, mg.record(stored, participant, result, attempt))
+
+	stored, err = mg.moduleDao.Get(ctx, 0, "dummy_participant11")
+	require.NoError(t, err)
+	assert.Equal(t, 20, stored.Score)
+	assert.Equal(t, 4, stored.Attempts, "attempts counted during the grading should be kept")
+	assert.True(t, stored.Reopened, "changes made during the grading should be kept")
+	storedParticipant, err := mg.participantDao.Get(ctx, "dummy_participant11")
+	require.NoError(t, err)
+	assert.Equal(t, 1, storedParticipant.CurrentModuleId)
+
+	entries, err := mg.auditDao.Query().Where("actor", dao.Eq, t.Name()).All(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "only the committed grading should be in the audit log")
+	assert.Equal(t, "grade", entries[0].Action)
+	assert.Equal(t, "module [0 dummy_participant11]", entries[0].Target)
+	assert.Contains(t, string(entries[0].Before), `"score":0`)
+	assert.Contains(t, string(entries[0].After), `"score":20`)
+}
+
+func TestAuditLog(t *testing.T) {
+	const target = "module [0 dummy_participant12]"
+	module := dao.NewDummyModule(0, "dummy_participant12")
+	module.Score = 17
+	body, err := json.Marshal(module)
+	require.NoError(t, err)
+
+	response := serveRequest(t, "PUT", "/shortinette/v1/modules", strings.NewReader(string(body)), apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	response = serveRequest(t, "DELETE", "/shortinette/v1/modules/0/dummy_participant12", nil, apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+
+	// Reads and refused requests are not recorded
+	token, _ := issueToken(t, scopeStaffReadonly, "")
+	serveRequest(t, "GET", "/shortinette/v1/modules/1/dummy_participant12", nil, apiToken)
+	serveRequest(t, "DELETE", "/shortinette/v1/modules/1/dummy_participant12", nil, token)
+
+	response = serveRequest(t, "GET", "/shortinette/v1/audit?target="+url.QueryEscape(target), nil, token)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	var page struct {
+		Items []struct {
+			Actor     string          `json:"actor"`
+			Action    string          `json:"action"`
+			Before    json.RawMessage `json:"before"`
+			After     json.RawMessage `json:"after"`
+			RequestId string          `json:"request_id"`
+		} `json:"items"`
+		Total int `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &page))
+	require.Equal(t, 2, page.Total)
+
+	deletion, update := page.Items[0], page.Items[1]
+	assert.Equal(t, "DELETE /shortinette/v1/modules/:id/:intra_login", deletion.Action)
+	assert.Equal(t, "api-token", deletion.Actor)
+	assert.Contains(t, string(deletion.Before), `"score":17`)
+	assert.Empty(t, deletion.After)
+	assert.Equal(t, "PUT /shortinette/v1/modules", update.Action)
+	assert.Contains(t, string(update.Before), `"score":0`)
+	assert.Contains(t, string(update.After), `"score":17`)
+	assert.NotEmpty(t, update.RequestId)
+
+	response = serveRequest(t, "GET", "/shortinette/v1/audit?target="+url.QueryEscape("module [1 dummy_participant12]"), nil, token)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	assert.Contains(t, response.Body.String(), `"total":0`)
+
+	auditDao := dao.NewDAO[dao.AuditEntry](api.DB)
+	entry, err := auditDao.Query().Where("target", dao.Eq, target).First(context.Background())
+	require.NoError(t, err)
+	assert.Error(t, auditDao.Delete(context.Background(), entry.Id), "audit entries should not be deletable")
+}
+
+func TestSoftDelete(t *testing.T) {
+	response := serveRequest(t, "DELETE", "/shortinette/v1/modules/1/dummy_participant13", nil, apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	response = serveRequest(t, "DELETE", "/shortinette/v1/participants/dummy_participant13", nil, apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+
+	response = serveRequest(t, "GET", "/shortinette/v1/participants/dummy_participant13", nil, apiToken)
+	assert.Equal(t, http.StatusNotFound, response.Code, response.Body)
+	response = serveRequest(t, "GET", "/shortinette/v1/modules/0/dummy_participant13", nil, apiToken)
+	assert.Equal(t, http.StatusNotFound, response.Code, response.Body)
+	response = serveRequest(t, "GET", "/shortinette/v1/participants?deleted=true&intra_login=dummy_participant13", nil, apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	assert.Contains(t, response.Body.String(), `"total":1`)
+
+	// Modules cannot be restored without their participant
+	response = serveRequest(t, "POST", "/shortinette/v1/modules/0/dummy_participant13/restore", nil, apiToken)
+	assert.Equal(t, http.StatusConflict, response.Code, response.Body)
+
+	response = serveRequest(t, "POST", "/shortinette/v1/participants/dummy_participant13/restore", nil, apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	response = serveRequest(t, "POST", "/shortinette/v1/participants/dummy_participant13/restore", nil, apiToken)
+	assert.Equal(t, http.StatusNotFound, response.Code, response.Body)
+
+	response = serveRequest(t, "GET", "/shortinette/v1/participants/dummy_participant13/modules", nil, apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	var modules []dao.Module
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &modules))
+	assert.Len(t, modules, 6, "the module deleted on its own should stay deleted")
+
+	response = serveRequest(t, "POST", "/shortinette/v1/modules/1/dummy_participant13/restore", nil, apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	response = serveRequest(t, "GET", "/shortinette/v1/modules/1/dummy_participant13", nil, apiToken)
+	assert.Equal(t, http.StatusOK, response.Code, response.Body)
+}
+
+func TestFinalGrades(t *testing.T) {
+	ctx := context.Background()
+	frozenAt := time.Date(2024, 11, 5, 10, 0, 0, 0, time.UTC)
+	attempt := dao.Attempt{Id: "attempt-8", ModuleId: 0, IntraLogin: "dummy_participant8", Score: 80, Passed: true, CommitSHA: "abc123", TraceRef: "traces:dummy_participant80.log", GradedAt: frozenAt.Add(-time.Hour)}
+	require.NoError(t, dao.NewDAO[dao.Attempt](api.DB).Insert(ctx, attempt))
+	grade := dao.FinalGrade{ModuleId: 0, IntraLogin: "dummy_participant8", Score: 80, Passed: true, AttemptId: attempt.Id, CommitSHA: attempt.CommitSHA, TraceRef: attempt.TraceRef, Rule: "best", FrozenAt: frozenAt}
+	require.NoError(t, dao.NewDAO[dao.FinalGrade](api.DB).Insert(ctx, grade))
+
+	response := serveRequest(t, "GET", "/shortinette/v1/grades?module_id=0&format=csv", nil, apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+	assert.Equal(t, strings.Join(finalGradeHeader, ","), lines[0])
+	assert.Contains(t, lines, "0,dummy_participant8,80,true,abc123,traces:dummy_participant80.log,best,2024-11-05T10:00:00Z")
+
+	response = serveRequest(t, "GET", "/shortinette/v1/grades?module_id=1", nil, apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	var grades []dao.FinalGrade
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &grades))
+	assert.Empty(t, grades)
+
+	response = serveRequest(t, "GET", "/shortinette/v1/grades?module_id=first", nil, apiToken)
+	assert.Equal(t, http.StatusBadRequest, response.Code, response.Body)
+
+	token, _ := issueToken(t, scopeStudent, "dummy_participant8")
+	response = serveRequest(t, "GET", "/shortinette/v1/grades", nil, token)
+	assert.Equal(t, http.StatusForbidden, response.Code, response.Body)
+
+	response = serveRequest(t, "GET", "/shortinette/v1/participants/dummy_participant8/attempts", nil, token)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	var attempts []dao.Attempt
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &attempts))
+	require.Len(t, attempts, 1)
+	assert.Equal(t, attempt.CommitSHA, attempts[0].CommitSHA)
+}
+
+// Amount of methods gin registers a route for with Any()
+const ginAnyMethods = 9
+
+func TestOpenAPIRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]any `yaml:"paths"`
+	}
+	require.NoError(t, yaml.Unmarshal(openAPISpec, &spec))
+
+	registered := map[string][]string{}
+	for _, route := range api.Engine.Routes() {
+		path := regexp.MustCompile(`:(\w+)`).ReplaceAllString(route.Path, "{$1}")
+		registered[path] = append(registered[path], strings.ToLower(route.Method))
+	}
+
+	for path, methods := range registered {
+		documented, ok := spec.Paths[path]
+		if !assert.True(t, ok, "route %s is not documented", path) {
+			continue
+		}
+		// Routes registered with Any() are documented with their main method
+		if len(methods) == ginAnyMethods {
+			continue
+		}
+		for _, method := range methods {
+			assert.Contains(t, documented, method, "%s %s is not documented", strings.ToUpper(method), path)
+		}
+	}
+
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if method == "parameters" {
+				continue
+			}
+			assert.Contains(t, registered[path], method, "%s %s is documented but not registered", strings.ToUpper(method), path)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	response := serveRequest(t, "GET", "/shortinette/openapi.json", nil, "")
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+
+	var spec map[string]any
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &spec))
+	assert.Equal(t, "3.0.3", spec["openapi"])
+}
+
+func TestClientTokens(t *testing.T) {
+	admin := newTestClient(t, apiToken)
+	ctx := context.Background()
+
+	issued, err := admin.IssueToken(ctx, client.TokenRequest{Name: t.Name(), Scope: scopeStaffReadonly})
+	require.NoError(t, err)
+	require.NotEmpty(t, issued.Secret)
+
+	tokens, err := admin.ListTokens(ctx, url.Values{"name": {t.Name()}})
+	require.NoError(t, err)
+	require.Equal(t, 1, tokens.Total)
+	assert.Equal(t, issued.ID, tokens.Items[0].ID)
+
+	staff := newTestClient(t, issued.Secret)
+	participant, err := staff.GetParticipant(ctx, "dummy_participant1")
+	require.NoError(t, err)
+	assert.Equal(t, "dummy_participant1", participant.IntraLogin)
+
+	_, err = staff.InsertParticipant(ctx, client.Participant{IntraLogin: "staff_made", GithubLogin: "staff-made"})
+	var apiErr *client.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+
+	revoked, err := admin.RevokeToken(ctx, issued.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, revoked.RevokedAt)
+
+	_, err = staff.GetParticipant(ctx, "dummy_participant1")
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+}
+
+func TestPatchParticipant(t *testing.T) {
+	shortinette := newTestClient(t, apiToken)
+	ctx := context.Background()
+
+	githubLogin, currentModule := "patched-gh", 0
+	participant, err := shortinette.PatchParticipant(ctx, "dummy_participant10", client.ParticipantPatch{GithubLogin: &githubLogin, CurrentModuleID: &currentModule})
+	require.NoError(t, err)
+	assert.Equal(t, "patched-gh", participant.GithubLogin)
+	assert.Equal(t, dao.ParticipantActive, participant.Status, "fields left out should not change")
+
+	stored, err := dao.NewDAO[dao.Participant](api.DB).Get(ctx, "dummy_participant10")
+	require.NoError(t, err)
+	assert.Equal(t, "patched-gh", stored.GitHubLogin)
+
+	// Changing them has side effects which only their own endpoints take care of
+	for _, body := range []string{`{"status": "withdrawn"}`, `{"deleted_at": "2024-10-01T12:00:00Z"}`} {
+		response := serveRequest(t, "PATCH", "/shortinette/v1/participants/dummy_participant10", strings.NewReader(body), apiToken)
+		assert.Equal(t, http.StatusBadRequest, response.Code, response.Body)
+		assert.Contains(t, response.Body.String(), "cannot be changed here", body)
+	}
+}
+
+func TestPutParticipantKeepsDedicatedFields(t *testing.T) {
+	body := `{"intra_login": "dummy_participant14", "github_login": "put-gh", "current_module_id": 0, "status": "banned", "deleted_at": "2024-10-01T12:00:00Z"}`
+	response := serveRequest(t, "PUT", "/shortinette/v1/participants", strings.NewReader(body), apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+
+	stored, err := dao.NewDAO[dao.Participant](api.DB).Get(context.Background(), "dummy_participant14")
+	require.NoError(t, err, "the participant should not be deleted")
+	assert.Equal(t, "put-gh", stored.GitHubLogin)
+	assert.Equal(t, dao.ParticipantActive, stored.Status, "the status should only change through its own endpoint")
+
+	body = `{"intra_login": "dummy_participant14", "github_login": "put-gh-again", "current_module_id": 0}`
+	response = serveRequest(t, "PUT", "/shortinette/v1/participants", strings.NewReader(body), apiToken)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+	stored, err = dao.NewDAO[dao.Participant](api.DB).Get(context.Background(), "dummy_participant14")
+	require.NoError(t, err)
+	assert.Equal(t, dao.ParticipantActive, stored.Status, "leaving the status out should not clear it")
+}
+
+func TestPatchModule(t *testing.T) {
+	score := 15
+	module, err := newTestClient(t, apiToken).PatchModule(context.Background(), 1, "dummy_participant10", client.ModulePatch{Score: &score})
+	require.NoError(t, err)
+	assert.Equal(t, 15, module.Score)
+	assert.Equal(t, 0, module.Attempts)
+}
+
+func TestInvalidItems(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		body   string
+		fields []string
+	}{
+		{"POST", "/shortinette/v1/participants", `{"intra_login": "", "github_login": "foo"}`, []string{"intra_login"}},
+		{"POST", "/shortinette/v1/participants", `{"intra_login": "foo bar", "github_login": "foo", "status": "asleep", "current_module_id": -1}`, []string{"intra_login", "current_module_id", "status"}},
+		{"POST", "/shortinette/v1/modules", `{"id": 2, "intra_login": "dummy_participant10"}`, []string{"id"}},
+		{"PUT", "/shortinette/v1/modules", `{"id": 0, "intra_login": "dummy_participant10", "score": -1, "attempts": -3}`, []string{"score", "attempts"}},
+		{"PATCH", "/shortinette/v1/modules/0/dummy_participant10", `{"score": 21}`, []string{"score"}},
+		{"PATCH", "/shortinette/v1/modules/0/dummy_participant10", `{"id": 1, "foo": 2}`, []string{"id", "foo"}},
+		{"PATCH", "/shortinette/v1/participants/dummy_participant10", `{"github_login": ""}`, []string{"github_login"}},
+	}
+
+	for _, test := range tests {
+		response := serveRequest(t, test.method, test.url, strings.NewReader(test.body), apiToken)
+		require.Equal(t, http.StatusBadRequest, response.Code, "%s %s %s: %s", test.method, test.url, test.body, response.Body)
+
+		var problem struct {
+			Errors []dao.FieldError `json:"errors"`
+		}
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &problem))
+		fields := make([]string, 0, len(problem.Errors))
+		for _, fieldErr := range problem.Errors {
+			fields = append(fields, fieldErr.Field)
+		}
+		assert.ElementsMatch(t, test.fields, fields, "%s %s %s", test.method, test.url, test.body)
+	}
+
+	module, err := dao.NewDAO[dao.Module](api.DB).Get(context.Background(), 0, "dummy_participant10")
+	require.NoError(t, err)
+	assert.Zero(t, module.Score, "invalid items should not be written")
+}
+
+func TestClientNotFound(t *testing.T) {
+	_, err := newTestClient(t, apiToken).GetModule(context.Background(), 42, "nobody")
+
+	var apiErr *client.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(t, "Not Found", apiErr.Title)
+	assert.Contains(t, apiErr.Message, "failed to get module")
+	assert.NotEmpty(t, apiErr.RequestID)
+}
+
+// Returns a client of the test API, authenticated with `token`.
+func newTestClient(t *testing.T, token string) *client.Client {
+	t.Helper()
+
+	server := httptest.NewServer(api.Engine)
+	t.Cleanup(server.Close)
+	return client.New(server.URL, token)
+}
+
+func newIntraStandIn() *intra.StandIn {
+	campus := []intra.CampusUser{{CampusID: 1, IsPrimary: true}}
+
+	return intra.NewStandIn(
+		intra.User{Login: "dummy_participant2", CampusUsers: campus},
+		intra.User{Login: "staff_flag", Staff: true, CampusUsers: campus},
+		intra.User{Login: "staff_group", Groups: []intra.Group{{Name: "bocal"}}, CampusUsers: campus},
+		intra.User{Login: "other_campus"},
+		intra.User{Login: "newcomer", CampusUsers: campus},
+		intra.User{Login: "unknown_account", CampusUsers: campus},
+		intra.User{Login: "case_thief", CampusUsers: campus},
+		intra.User{Login: "upper_case", CampusUsers: campus},
+	)
+}
+
+// Goes through the Intra login flow as `login`, and returns the response to the callback.
+func serveIntraCallback(t *testing.T, login string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", "/shortinette/auth/login", nil)
+	require.NoError(t, err)
+	response := httptest.NewRecorder()
+	api.Engine.ServeHTTP(response, req)
+	require.Equal(t, http.StatusFound, response.Code, response.Body)
+
+	// The stand-in skips the consent page and redirects right away
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	consent, err := client.Get(response.Header().Get("Location") + "&login=" + login)
+	require.NoError(t, err)
+	consent.Body.Close()
+	require.Equal(t, http.StatusFound, consent.StatusCode)
+
+	callbackURL, err := consent.Location()
+	require.NoError(t, err)
+	callback, err := http.NewRequest("GET", callbackURL.RequestURI(), nil)
+	require.NoError(t, err)
+	for _, cookie := range response.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+
+	response = httptest.NewRecorder()
+	api.Engine.ServeHTTP(response, callback)
+	return response
+}
+
+// Logs in through the Intra as `login`, and returns the session cookie.
+func intraLogin(t *testing.T, login string) *http.Cookie {
+	t.Helper()
+
+	response := serveIntraCallback(t, login)
+	require.Equal(t, http.StatusOK, response.Code, response.Body)
+
+	for _, cookie := range response.Result().Cookies() {
+		if cookie.Name == sessionCookie && cookie.Value != "" {
+			return cookie
+		}
+	}
+	t.Fatalf("no session cookie set")
+	return nil
+}
+
+func serveSessionRequest(t *testing.T, method string, url string, session *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err, fmt.Sprintf("failed to make request: %s", url))
+	req.AddCookie(session)
+
+	response := httptest.NewRecorder()
+	api.Engine.ServeHTTP(response, req)
+	return response
+}
+
+// Issues a token through the API, returns the plain text token and its ID.
+func issueToken(t *testing.T, scope string, intraLogin string) (string, string) {
+	t.Helper()
+
+	body, err := json.Marshal(tokenRequest{Name: t.Name(), Scope: scope, IntraLogin: intraLogin})
+	require.NoError(t, err)
+
+	response := serveRequest(t, "POST", "/shortinette/v1/tokens", strings.NewReader(string(body)), apiToken)
+	require.Equal(t, http.StatusCreated, response.Code, response.Body)
+
+	var issued issuedTokenResponse
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &issued))
+	return issued.Secret, issued.Id
+}
+
 func testPost(t *testing.T, item any, url string) {
 	t.Helper()
 
@@ -231,10 +1329,10 @@ func testPut[T any](t *testing.T, url string, args ...any) {
 func testGetAll[T any](t *testing.T, url string) {
 	t.Helper()
 
-	response := serveRequest(t, "GET", url, nil, apiToken)
+	response := serveRequest(t, "GET", url+fmt.Sprintf("?limit=%d", maxPageLimit), nil, apiToken)
 
-	var actualItems []T
-	err := json.Unmarshal(response.Body.Bytes(), &actualItems)
+	var actualPage page[T]
+	err := json.Unmarshal(response.Body.Bytes(), &actualPage)
 	require.NoError(t, err, "failed to unmarshal item")
 
 	dao := dao.NewDAO[T](api.DB)
@@ -242,7 +1340,9 @@ func testGetAll[T any](t *testing.T, url string) {
 	require.NoError(t, err)
 
 	assert.Equal(t, http.StatusOK, response.Code, response.Body)
-	assert.Equal(t, expectedItems, actualItems)
+	assert.ElementsMatch(t, expectedItems, actualPage.Items)
+	assert.Equal(t, len(expectedItems), actualPage.Total)
+	assert.Empty(t, actualPage.NextCursor)
 }
 
 func testGet[T any](t *testing.T, url string, args ...any) {
@@ -273,6 +1373,36 @@ func testDelete[T any](t *testing.T, url string, args ...any) {
 	require.Error(t, err)
 }
 
+func newPushPayload(ref string, pushedAt time.Time) gitHubWebhookPayload {
+	payload := gitHubWebhookPayload{Ref: ref}
+	payload.Repository.Name = "dummy_participant1-00"
+	payload.Repository.PushedAt = pushedAt.Unix()
+	payload.Pusher.Name = "dummy_git_dummy_participant1"
+	payload.Commit.Message = "grademe"
+	return payload
+}
+
+// Sends `payload` the way GitHub would, signed with the webhook secret.
+func serveWebhook(t *testing.T, event string, deliveryID string, payload any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(payload)
+	require.NoError(t, err, "failed to marshal payload")
+
+	req, err := http.NewRequest("POST", "/shortinette/webhook/grademe", strings.NewReader(string(body)))
+	require.NoError(t, err, "failed to make webhook request")
+
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write(body)
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-GitHub-Delivery", deliveryID)
+
+	response := httptest.NewRecorder()
+	api.Engine.ServeHTTP(response, req)
+	return response
+}
+
 func serveRequest(t *testing.T, method string, url string, body io.Reader, accessToken string) *httptest.ResponseRecorder {
 	t.Helper()
 
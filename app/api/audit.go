@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/42-Short/shortinette/dao"
+	"github.com/42-Short/shortinette/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const auditKey = "audit"
+
+// What a request changed, see recordChange.
+type change struct {
+	target string
+	before any
+	after  any
+}
+
+// Records every request which changes something in the audit log, along with the identity it
+// was authenticated as. Requests are recorded once they succeeded, or once their handler
+// reported a change with recordChange, e.g. when a later step failed. Requests whose handler
+// did not report their change are recorded with their path as target.
+func auditMiddleware(auditDao *dao.DAO[dao.AuditEntry]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		value, reported := c.Get(auditKey)
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || (!reported && c.Writer.Status() >= http.StatusBadRequest) {
+			return
+		}
+		recorded, _ := value.(change)
+		if !reported {
+			recorded.target = c.Request.URL.Path
+		}
+
+		entry := newAuditEntry(getPrincipal(c).Name, c.Request.Method+" "+c.FullPath(), recorded.target, recorded.before, recorded.after)
+		entry.RequestId = c.GetString(requestIDKey)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		// The change is done, so failing to record it cannot fail the request anymore
+		if err := auditDao.Insert(ctx, entry); err != nil {
+			logger.Error.Printf("could not record %s by %s in audit log: %v", entry.Action, entry.Actor, err)
+		}
+	}
+}
+
+// Reports what the request changed to auditMiddleware. `before` is nil for creations, `after`
+// for deletions.
+func recordChange(c *gin.Context, target string, before any, after any) {
+	c.Set(auditKey, change{target: target, before: before, after: after})
+}
+
+// Identifies a record in the audit log, e.g. `module [0 jdoe]`.
+func auditTarget(table string, keys []any) string {
+	return fmt.Sprintf("%s %v", table, keys)
+}
+
+func newAuditEntry(actor string, action string, target string, before any, after any) dao.AuditEntry {
+	return dao.AuditEntry{
+		Id:        uuid.NewString(),
+		Actor:     actor,
+		Action:    action,
+		Target:    target,
+		Before:    toAuditJSON(before),
+		After:     toAuditJSON(after),
+		CreatedAt: time.Now(),
+	}
+}
+
+func toAuditJSON(value any) dao.JSON {
+	if value == nil {
+		return ""
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		logger.Error.Printf("could not serialize %T for audit log: %v", value, err)
+		return ""
+	}
+	return dao.JSON(data)
+}
+
+// Returns one page of the audit log, most recent first unless `sort` is set. See
+// parsePageQuery for the supported query parameters.
+func getAuditLogHandler(auditDao *dao.DAO[dao.AuditEntry]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, err := parsePageQuery(c, auditDao)
+		if err != nil {
+			writeProblem(c, err)
+			return
+		}
+		if query.SortBy == "" {
+			query.SortBy, query.Descending = "created_at", true
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		entries, total, err := auditDao.GetPage(ctx, query)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s`s: %w", auditDao.Name(), err))
+			return
+		}
+		c.JSON(http.StatusOK, newPage(entries, total, query))
+	}
+}
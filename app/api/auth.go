@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/42-Short/shortinette/apperr"
+	"github.com/42-Short/shortinette/config"
+	"github.com/42-Short/shortinette/dao"
+	"github.com/42-Short/shortinette/intra"
+	"github.com/42-Short/shortinette/logger"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionCookie = "shortinette_session"
+	stateCookie   = "shortinette_oauth_state"
+	cookiePath    = "/shortinette"
+
+	// Time a user has to go through the Intra's consent page
+	stateCookieMaxAge = 10 * time.Minute
+)
+
+type sessionResponse struct {
+	IntraLogin string    `json:"intra_login"`
+	Scope      string    `json:"scope"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type meResponse struct {
+	IntraLogin  string           `json:"intra_login"`
+	Scope       string           `json:"scope"`
+	Participant *dao.Participant `json:"participant"`
+	Modules     []dao.Module     `json:"modules"`
+}
+
+// Redirects to the Intra's consent page. The state sent along is also stored in a cookie,
+// and checked on callback to make sure the login was started from this browser.
+func intraLoginHandler(client *intra.Client, config config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state, err := generateSecret()
+		if err != nil {
+			writeProblem(c, fmt.Errorf("could not generate state: %w", err))
+			return
+		}
+
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(stateCookie, state, int(stateCookieMaxAge.Seconds()), cookiePath, "", secureCookies(config), true)
+		c.Redirect(http.StatusFound, client.AuthorizeURL(state))
+	}
+}
+
+// Completes the OAuth2 flow and opens a session. Users with one of the configured staff
+// roles get admin access, everyone else is treated as a student.
+func intraCallbackHandler(client *intra.Client, sessionDao *dao.DAO[dao.Session], config config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expectedState, err := c.Cookie(stateCookie)
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(stateCookie, "", -1, cookiePath, "", secureCookies(config), true)
+		if err != nil || subtle.ConstantTimeCompare([]byte(expectedState), []byte(c.Query("state"))) != 1 {
+			writeProblem(c, apperr.Validationf("invalid OAuth state, please log in again"))
+			return
+		}
+
+		code := c.Query("code")
+		if code == "" {
+			writeProblem(c, apperr.Forbiddenf("login was not authorized: %s", c.Query("error_description")))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		accessToken, err := client.Exchange(ctx, code)
+		if err != nil {
+			writeProblem(c, apperr.Wrap(apperr.Upstream, err, "could not log in through the Intra"))
+			return
+		}
+		user, err := client.Me(ctx, accessToken)
+		if err != nil {
+			writeProblem(c, apperr.Wrap(apperr.Upstream, err, "could not log in through the Intra"))
+			return
+		}
+
+		if config.IntraCampusID != 0 && !user.IsInCampus(config.IntraCampusID) {
+			logger.Warning.Printf("refused Intra login of %s: not a member of campus %d", user.Login, config.IntraCampusID)
+			writeProblem(c, apperr.Forbiddenf("only members of this campus may log in"))
+			return
+		}
+
+		scope := scopeStudent
+		if user.HasRole(config.IntraStaffRoles) {
+			scope = scopeAdmin
+		}
+
+		sessionID, err := generateSecret()
+		if err != nil {
+			writeProblem(c, fmt.Errorf("could not generate session: %w", err))
+			return
+		}
+
+		now := time.Now()
+		session := dao.Session{
+			Id:         hashToken(sessionID),
+			IntraLogin: user.Login,
+			Scope:      scope,
+			CreatedAt:  now,
+			ExpiresAt:  now.Add(config.SessionDuration),
+		}
+		if err := sessionDao.Insert(ctx, session); err != nil {
+			writeProblem(c, fmt.Errorf("failed to insert %s: %w", sessionDao.Name(), err))
+			return
+		}
+		logger.Info.Printf("%s logged in through the Intra (%s)", user.Login, scope)
+
+		c.SetCookie(sessionCookie, sessionID, int(config.SessionDuration.Seconds()), cookiePath, "", secureCookies(config), true)
+		c.JSON(http.StatusOK, sessionResponse{IntraLogin: session.IntraLogin, Scope: session.Scope, ExpiresAt: session.ExpiresAt})
+	}
+}
+
+// Ends the session of the caller, if any.
+func intraLogoutHandler(sessionDao *dao.DAO[dao.Session], config config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if sessionID, err := c.Cookie(sessionCookie); err == nil && sessionID != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			if err := sessionDao.Delete(ctx, hashToken(sessionID)); err != nil {
+				logger.Warning.Printf("could not delete session: %v", err)
+			}
+		}
+
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(sessionCookie, "", -1, cookiePath, "", secureCookies(config), true)
+		c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+	}
+}
+
+// Returns the caller's identity, along with their participant record and modules if they are
+// a participant.
+func meHandler(participantDao *dao.DAO[dao.Participant], moduleDao *dao.DAO[dao.Module]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller := getPrincipal(c)
+		if caller.IntraLogin == "" {
+			writeProblem(c, apperr.NotFoundf("token '%s' is not bound to an Intra login", caller.Name))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		response := meResponse{IntraLogin: caller.IntraLogin, Scope: caller.Scope, Modules: []dao.Module{}}
+		// Staff and students who have not registered yet are not participants
+		if participant, err := participantDao.Get(ctx, caller.IntraLogin); err == nil {
+			response.Participant = participant
+
+			modules, err := moduleDao.GetFiltered(ctx, map[string]any{"intra_login": caller.IntraLogin})
+			if err != nil {
+				writeProblem(c, fmt.Errorf("failed to get %s`s: %w", moduleDao.Name(), err))
+				return
+			}
+			response.Modules = modules
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// Returns the non-expired session matching `sessionID`.
+func lookupSession(ctx context.Context, sessionDao *dao.DAO[dao.Session], sessionID string) (*dao.Session, error) {
+	if sessionID == "" {
+		return nil, fmt.Errorf("empty session")
+	}
+
+	session, err := sessionDao.Get(ctx, hashToken(sessionID))
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, fmt.Errorf("session expired")
+	}
+	return session, nil
+}
+
+// Cookies are only sent over HTTPS if shortinette is served over HTTPS.
+func secureCookies(config config.Config) bool {
+	return strings.HasPrefix(config.IntraRedirectURL, "https://")
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42-Short/shortinette/apperr"
+	"github.com/42-Short/shortinette/dao"
+	"github.com/gin-gonic/gin"
+)
+
+var finalGradeHeader = []string{"module_id", "intra_login", "score", "passed", "commit_sha", "trace_ref", "rule", "frozen_at"}
+
+// Returns the grading attempts of `:intra_login`, oldest first.
+func getParticipantAttemptsHandler(attemptDao *dao.DAO[dao.Attempt]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		attempts, err := attemptDao.GetFiltered(ctx, map[string]any{"intra_login": c.Param("intra_login")})
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s`s: %w", attemptDao.Name(), err))
+			return
+		}
+
+		slices.SortFunc(attempts, func(a, b dao.Attempt) int { return a.GradedAt.Compare(b.GradedAt) })
+		c.JSON(http.StatusOK, attempts)
+	}
+}
+
+// Exports the final grades frozen when modules closed, as JSON or, with `?format=csv`, as CSV.
+// `?module_id=` restricts the export to a single module. These are the official results.
+func exportFinalGradesHandler(finalGradeDao *dao.DAO[dao.FinalGrade]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "csv" {
+			writeProblem(c, apperr.Validationf("invalid format '%s': expected 'json' or 'csv'", format))
+			return
+		}
+
+		filters := map[string]any{}
+		if moduleId := c.Query("module_id"); moduleId != "" {
+			id, err := strconv.Atoi(moduleId)
+			if err != nil {
+				writeProblem(c, apperr.Validationf("invalid module_id '%s'", moduleId))
+				return
+			}
+			filters["module_id"] = id
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		var grades []dao.FinalGrade
+		var err error
+		if len(filters) > 0 {
+			grades, err = finalGradeDao.GetFiltered(ctx, filters)
+		} else {
+			grades, err = finalGradeDao.GetAll(ctx)
+		}
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s`s: %w", finalGradeDao.Name(), err))
+			return
+		}
+
+		slices.SortFunc(grades, func(a, b dao.FinalGrade) int {
+			return cmp.Or(cmp.Compare(a.ModuleId, b.ModuleId), strings.Compare(a.IntraLogin, b.IntraLogin))
+		})
+		if format == "json" {
+			c.JSON(http.StatusOK, grades)
+			return
+		}
+
+		c.Header("Content-Disposition", `attachment; filename="grades.csv"`)
+		c.Header("Content-Type", "text/csv")
+		c.Status(http.StatusOK)
+
+		writer := csv.NewWriter(c.Writer)
+		_ = writer.Write(finalGradeHeader)
+		for _, grade := range grades {
+			_ = writer.Write([]string{
+				strconv.Itoa(grade.ModuleId),
+				grade.IntraLogin,
+				strconv.Itoa(grade.Score),
+				strconv.FormatBool(grade.Passed),
+				grade.CommitSHA,
+				grade.TraceRef,
+				grade.Rule,
+				grade.FrozenAt.UTC().Format(time.RFC3339),
+			})
+		}
+		writer.Flush()
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/42-Short/shortinette/git"
 	"github.com/42-Short/shortinette/logger"
 	"github.com/42-Short/shortinette/tester"
+	"github.com/google/uuid"
 )
 
 //todo: scheduler in api for repo creation
@@ -19,19 +20,30 @@ import (
 type moduleGrader struct {
 	moduleDao      *dao.DAO[dao.Module]
 	participantDao *dao.DAO[dao.Participant]
+	attemptDao     *dao.DAO[dao.Attempt]
+	auditDao       *dao.DAO[dao.AuditEntry]
+	actor          string // Who triggered the grading, recorded in the audit log
 	ctx            context.Context
 	config         config.Config
 	gitService     *git.GithubService
 }
 
-func newModuleGrader(moduleDao *dao.DAO[dao.Module], participantDao *dao.DAO[dao.Participant], ctx context.Context, config config.Config) *moduleGrader {
+func newModuleGrader(moduleDao *dao.DAO[dao.Module], participantDao *dao.DAO[dao.Participant], attemptDao *dao.DAO[dao.Attempt], auditDao *dao.DAO[dao.AuditEntry], actor string, ctx context.Context, config config.Config) (*moduleGrader, error) {
+	gitService, err := git.NewGithubServiceFromConfig(config, "../")
+	if err != nil {
+		return nil, fmt.Errorf("could not set up GitHub client: %v", err)
+	}
+
 	return &moduleGrader{
 		moduleDao:      moduleDao,
 		participantDao: participantDao,
+		attemptDao:     attemptDao,
+		auditDao:       auditDao,
+		actor:          actor,
 		ctx:            ctx,
 		config:         config,
-		gitService:     git.NewGithubService(config.TokenGithub, config.OrgaGithub, "../"),
-	}
+		gitService:     gitService,
+	}, nil
 }
 
 func (mg *moduleGrader) process(intraLogin string, moduleId int) error {
@@ -43,22 +55,52 @@ func (mg *moduleGrader) process(intraLogin string, moduleId int) error {
 	if err != nil {
 		return err
 	}
-
-	result, err := mg.grade(*module, *participant)
-	if err != nil {
-		return err
+	if !participant.IsActive() {
+		return fmt.Errorf("%s is %s and cannot be graded", participant.IntraLogin, participant.Status)
 	}
 
-	err = mg.updateModuleState(module, *result)
-	if err != nil {
-		return err
-	}
-	err = mg.updateParticipantState(participant, *result)
+	result, attempt, err := mg.grade(*module, *participant)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return mg.record(module, participant, *result, *attempt)
+}
+
+// Stores the outcome of a grading. Everything is written at once, so that the score of the
+// module, the progress of the participant and the audit log cannot get out of sync.
+// `module` and `participant` were read before the grading, which can take minutes, so they
+// are read again and locked first, and updated with what is stored.
+func (mg *moduleGrader) record(module *dao.Module, participant *dao.Participant, result tester.GradingResult, attempt dao.Attempt) error {
+	return dao.WithTx(mg.ctx, mg.moduleDao.DB, func(tx *dao.Tx) error {
+		current, err := mg.moduleDao.In(tx).Query().
+			Where("id", dao.Eq, module.Id).
+			Where("intra_login", dao.Eq, module.IntraLogin).
+			ForUpdate().
+			First(mg.ctx)
+		if err != nil {
+			return fmt.Errorf("could not get module %d of %s: %w", module.Id, module.IntraLogin, err)
+		}
+		*module = *current
+
+		// Kept for the final grade, which is not necessarily the last score
+		if err := mg.attemptDao.In(tx).Insert(mg.ctx, attempt); err != nil {
+			return fmt.Errorf("could not record attempt: %w", err)
+		}
+		before := *module
+		if err := mg.updateModuleState(tx, module, result); err != nil {
+			return err
+		}
+		if err := mg.updateParticipantState(tx, participant, result); err != nil {
+			return err
+		}
+
+		entry := newAuditEntry(mg.actor, "grade", auditTarget(mg.moduleDao.Name(), mg.moduleDao.KeysOf(*module)), before, module)
+		if err := mg.auditDao.In(tx).Insert(mg.ctx, entry); err != nil {
+			return fmt.Errorf("could not record grading in audit log: %w", err)
+		}
+		return nil
+	})
 }
 
 func (mg moduleGrader) isValidGradingAttempt(module dao.Module, participant dao.Participant) bool {
@@ -76,38 +118,47 @@ func (mg moduleGrader) isValidGradingAttempt(module dao.Module, participant dao.
 	return true
 }
 
-func (mg *moduleGrader) updateModuleState(module *dao.Module, result tester.GradingResult) error {
+func (mg *moduleGrader) updateModuleState(tx *dao.Tx, module *dao.Module, result tester.GradingResult) error {
 	module.LastGraded = time.Now()
 	module.WaitTime = 0
 	// module.WaitTime = time.Duration(1<<module.Attempts) * time.Minute
 	module.Attempts++
 	module.Score = result.Score
-	return mg.moduleDao.Update(mg.ctx, *module)
+	return mg.moduleDao.In(tx).Update(mg.ctx, *module)
 }
 
-func (mg *moduleGrader) updateParticipantState(participant *dao.Participant, result tester.GradingResult) error {
+func (mg *moduleGrader) updateParticipantState(tx *dao.Tx, participant *dao.Participant, result tester.GradingResult) error {
+	current, err := mg.participantDao.In(tx).Query().
+		Where("intra_login", dao.Eq, participant.IntraLogin).
+		ForUpdate().
+		First(mg.ctx)
+	if err != nil {
+		return fmt.Errorf("could not get participant %s: %w", participant.IntraLogin, err)
+	}
+	*participant = *current
+
 	if !result.Passed {
 		return nil
 	}
 	participant.CurrentModuleId++
-	return mg.participantDao.Update(mg.ctx, *participant)
+	return mg.participantDao.In(tx).Update(mg.ctx, *participant)
 }
 
-func (mg moduleGrader) grade(module dao.Module, participant dao.Participant) (*tester.GradingResult, error) {
+func (mg moduleGrader) grade(module dao.Module, participant dao.Participant) (*tester.GradingResult, *dao.Attempt, error) {
 	traceFile := filepath.Join("traces", fmt.Sprintf("%s%d_%s.log", module.IntraLogin, module.Id, time.Now().Format("20060102_150405")))
 	if err := logger.InitializeTraceLogger(traceFile); err != nil {
-		return nil, fmt.Errorf("trace logger could not be initialized: %v", err)
+		return nil, nil, fmt.Errorf("trace logger could not be initialized: %v", err)
 	}
 
 	defer os.Remove(traceFile)
 
 	if !mg.isValidGradingAttempt(module, participant) {
-		return nil, fmt.Errorf("invalid grading attempt")
+		return nil, nil, fmt.Errorf("invalid grading attempt")
 	}
 
 	repoName := fmt.Sprintf("%s-%02d", module.IntraLogin, module.Id)
 	if err := mg.gitService.Clone(repoName); err != nil {
-		return nil, fmt.Errorf("could not clone repo '%s': %v", repoName, err)
+		return nil, nil, fmt.Errorf("could not clone repo '%s': %v", repoName, err)
 	}
 
 	defer func() {
@@ -116,20 +167,38 @@ func (mg moduleGrader) grade(module dao.Module, participant dao.Participant) (*t
 		}
 	}()
 
+	commitSHA, err := git.HeadCommit(repoName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not identify graded commit of '%s': %v", repoName, err)
+	}
+
 	result, err := tester.GradeModule(mg.config.Modules[module.Id], repoName, "../testenv/Dockerfile")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	logger.File.Print(result.Trace)
-	mg.uploadTraces(traceFile, module)
-	return result, nil
+
+	attempt := &dao.Attempt{
+		Id:         uuid.NewString(),
+		ModuleId:   module.Id,
+		IntraLogin: module.IntraLogin,
+		Score:      result.Score,
+		Passed:     result.Passed,
+		CommitSHA:  commitSHA,
+		GradedAt:   time.Now(),
+	}
+	if err := mg.uploadTraces(traceFile, module); err != nil {
+		logger.Error.Printf("could not upload traces for user %s, module %d: %v", module.IntraLogin, module.Id, err)
+	} else {
+		attempt.TraceRef = "traces:" + filepath.Base(traceFile)
+	}
+
+	return result, attempt, nil
 }
 
-func (mg moduleGrader) uploadTraces(traceFile string, module dao.Module) {
+func (mg moduleGrader) uploadTraces(traceFile string, module dao.Module) error {
 	commitMessage := fmt.Sprintf("chore: automated upload of trace logs for module %d (user: %s)", module.Id, module.IntraLogin)
 	repoName := fmt.Sprintf("%s-%02d", module.IntraLogin, module.Id)
 
-	if err := mg.gitService.UploadFiles(repoName, commitMessage, "traces", false, traceFile); err != nil {
-		logger.Error.Printf("could not upload traces for user %s, module %d: %v", module.IntraLogin, module.Id, err)
-	}
+	return mg.gitService.UploadFiles(repoName, commitMessage, "traces", false, traceFile)
 }
@@ -2,24 +2,35 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/42-Short/shortinette/apperr"
 	"github.com/42-Short/shortinette/config"
 	"github.com/42-Short/shortinette/dao"
+	"github.com/42-Short/shortinette/db"
 	"github.com/42-Short/shortinette/logger"
 	"github.com/42-Short/shortinette/short"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 )
 
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
 type gitHubWebhookPayload struct {
 	Ref        string `json:"ref"`
 	Repository struct {
-		Name string `json:"name"`
+		Name     string `json:"name"`
+		PushedAt int64  `json:"pushed_at"`
 	} `json:"repository"`
 	Pusher struct {
 		Name string `json:"name"`
@@ -29,43 +40,146 @@ type gitHubWebhookPayload struct {
 	} `json:"head_commit"`
 }
 
-func launchShort(participantDao *dao.DAO[dao.Participant], config config.Config) gin.HandlerFunc {
+func launchShort(database *db.DB, config config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		participants, err := participantDao.GetAll(context.Background())
+		sh, err := short.NewShort(config, database)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("could not fetch participants: %v", err)})
+			writeProblem(c, fmt.Errorf("could not launch Short: %w", err))
 			return
 		}
 
-		sh := short.NewShort(participants, config)
-
+		// Recorded even if launching fails half-way, as some repos may have been created already
+		recordChange(c, "short", nil, gin.H{"modules": len(config.Modules), "start_time": config.StartTime})
 		if err := sh.Launch(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("could not launch Short: %v", err)})
+			writeProblem(c, fmt.Errorf("could not launch Short: %w", err))
 			return
 		}
 	}
 }
 
-func githubWebhookHandler(moduleDao *dao.DAO[dao.Module], participantDao *dao.DAO[dao.Participant], config config.Config) gin.HandlerFunc {
+func githubWebhookHandler(moduleDao *dao.DAO[dao.Module], participantDao *dao.DAO[dao.Participant], attemptDao *dao.DAO[dao.Attempt], auditDao *dao.DAO[dao.AuditEntry], deliveryDao *dao.DAO[dao.WebhookDelivery], config config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		deliveryID := c.GetHeader("X-GitHub-Delivery")
+		event := c.GetHeader("X-GitHub-Event")
+		if deliveryID == "" || event == "" {
+			writeProblem(c, apperr.Validationf("missing X-GitHub-Delivery or X-GitHub-Event header"))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		delivery := dao.WebhookDelivery{
+			DeliveryId: deliveryID,
+			Event:      event,
+			ReceivedAt: time.Now(),
+			Outcome:    "received",
+		}
+
+		// GitHub keeps the delivery ID on redeliveries, so this also catches replayed requests.
+		// Deliveries which failed are let through again, the failure may have been transient.
+		previous, err := deliveryDao.Get(ctx, deliveryID)
+		switch {
+		case err == nil && !isRedeliverable(previous.Outcome):
+			logger.Warning.Printf("webhook delivery %s was already processed, ignoring it", deliveryID)
+			writeProblem(c, apperr.Conflictf("delivery %s was already processed", deliveryID))
+			return
+		case err == nil:
+			logger.Info.Printf("webhook delivery %s previously failed (%s), processing it again", deliveryID, previous.Outcome)
+			err = deliveryDao.Update(ctx, delivery)
+		default:
+			err = deliveryDao.Insert(ctx, delivery)
+		}
+		if err != nil {
+			writeProblem(c, fmt.Errorf("could not record delivery %s: %w", deliveryID, err))
+			return
+		}
+
+		switch event {
+		case "ping":
+			recordDeliveryOutcome(deliveryDao, delivery, "pong")
+			c.JSON(http.StatusOK, gin.H{"message": "pong"})
+			return
+		case "push":
+		default:
+			recordDeliveryOutcome(deliveryDao, delivery, fmt.Sprintf("rejected: unsupported event '%s'", event))
+			writeProblem(c, apperr.Validationf("unsupported event '%s', only 'push' is handled", event))
+			return
+		}
 
 		var payload gitHubWebhookPayload
 		if err := c.ShouldBindBodyWith(&payload, binding.JSON); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to bind JSON: %v", err)})
+			recordDeliveryOutcome(deliveryDao, delivery, "rejected: malformed payload")
+			writeProblem(c, apperr.Validationf("failed to bind JSON: %w", err))
 			return
 		}
+		delivery.Repository = payload.Repository.Name
 		logger.Info.Printf("got webhook payload from %s on repo %s", payload.Pusher.Name, payload.Repository.Name)
 
-		err := processGithubPayload(payload, moduleDao, participantDao, config)
+		if pushedAt := time.Unix(payload.Repository.PushedAt, 0); time.Since(pushedAt) > config.WebhookMaxAge {
+			recordDeliveryOutcome(deliveryDao, delivery, "rejected: stale")
+			writeProblem(c, apperr.Validationf("push from %s is older than %s", pushedAt.Format(time.RFC3339), config.WebhookMaxAge))
+			return
+		}
+
+		outcome, grade, err := processGithubPayload(payload, moduleDao, participantDao, attemptDao, auditDao, config)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			recordDeliveryOutcome(deliveryDao, delivery, fmt.Sprintf("rejected: %v", err))
+			writeProblem(c, err)
 			return
 		}
+		recordDeliveryOutcome(deliveryDao, delivery, outcome)
+		if grade != nil {
+			// Started once "grading started" is recorded, so that it cannot overwrite the result
+			go func() {
+				if err := grade(); err != nil {
+					logger.Error.Printf("grading failed for %s: %v", payload.Repository.Name, err)
+					recordDeliveryOutcome(deliveryDao, delivery, fmt.Sprintf("grading failed: %v", err))
+					return
+				}
+				recordDeliveryOutcome(deliveryDao, delivery, "graded")
+			}()
+		}
 
 		c.JSON(http.StatusOK, payload)
 	}
 }
-func gradingHandler(moduleDao *dao.DAO[dao.Module], participantDao *dao.DAO[dao.Participant], config config.Config) gin.HandlerFunc {
+
+// Lists the most recent webhook deliveries along with what shortinette did with them.
+// The amount can be set with the `limit` query parameter.
+func getRecentDeliveriesHandler(deliveryDao *dao.DAO[dao.WebhookDelivery]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultDeliveryLimit)))
+		if err != nil || limit < 1 || limit > maxDeliveryLimit {
+			writeProblem(c, apperr.Validationf("limit must be a number between 1 and %d", maxDeliveryLimit))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		deliveries, err := deliveryDao.Query().OrderBy("received_at", true).Limit(limit).All(ctx)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get all %s`s: %w", deliveryDao.Name(), err))
+			return
+		}
+		c.JSON(http.StatusOK, deliveries)
+	}
+}
+
+// Whether GitHub may redeliver a delivery with this outcome: rejections and failed gradings.
+func isRedeliverable(outcome string) bool {
+	return strings.HasPrefix(outcome, "rejected") || strings.HasPrefix(outcome, "grading failed")
+}
+
+func recordDeliveryOutcome(deliveryDao *dao.DAO[dao.WebhookDelivery], delivery dao.WebhookDelivery, outcome string) {
+	delivery.Outcome = outcome
+	if err := deliveryDao.Update(context.Background(), delivery); err != nil {
+		logger.Error.Printf("could not record outcome of webhook delivery %s: %v", delivery.DeliveryId, err)
+	}
+}
+
+func gradingHandler(moduleDao *dao.DAO[dao.Module], participantDao *dao.DAO[dao.Participant], attemptDao *dao.DAO[dao.Attempt], auditDao *dao.DAO[dao.AuditEntry], config config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 		defer cancel()
@@ -73,11 +187,25 @@ func gradingHandler(moduleDao *dao.DAO[dao.Module], participantDao *dao.DAO[dao.
 		args := collectArgs(c.Params)
 		module, err := moduleDao.Get(ctx, args...)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("failed to get %s: %v: %v", moduleDao.Name(), args, err)})
+			writeProblem(c, fmt.Errorf("failed to get %s: %v: %w", moduleDao.Name(), args, err))
+			return
+		}
+
+		participant, err := participantDao.Get(ctx, module.IntraLogin)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s: %w", participantDao.Name(), err))
+			return
+		}
+		if !participant.IsActive() {
+			writeProblem(c, apperr.Forbiddenf("%s is %s and cannot be graded", participant.IntraLogin, participant.Status))
 			return
 		}
 
-		mg := newModuleGrader(moduleDao, participantDao, context.TODO(), config)
+		mg, err := newModuleGrader(moduleDao, participantDao, attemptDao, auditDao, getPrincipal(c).Name, context.TODO(), config)
+		if err != nil {
+			writeProblem(c, err)
+			return
+		}
 		go func() {
 			err := mg.process(module.IntraLogin, module.Id)
 			if err != nil {
@@ -88,64 +216,191 @@ func gradingHandler(moduleDao *dao.DAO[dao.Module], participantDao *dao.DAO[dao.
 	}
 }
 
-func insertItemHandler[T any](dao *dao.DAO[T]) gin.HandlerFunc {
+func insertItemHandler[T any](itemDao *dao.DAO[T], config config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var item T
 		err := c.ShouldBindJSON(&item)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			writeProblem(c, apperr.Validationf("%w", err))
+			return
+		}
+		dao.SetDefaults(&item)
+		if err := dao.Validate(item, config); err != nil {
+			writeProblem(c, err)
 			return
 		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 		defer cancel()
 
-		err = dao.Insert(ctx, item)
+		err = itemDao.Insert(ctx, item)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to insert %s: %v", dao.Name(), err)})
+			writeProblem(c, fmt.Errorf("failed to insert %s: %w", itemDao.Name(), err))
 			return
 		}
+		recordChange(c, auditTarget(itemDao.Name(), itemDao.KeysOf(item)), nil, item)
 
 		c.JSON(http.StatusCreated, item)
 	}
 }
 
-func updateItemHandler[T any](dao *dao.DAO[T]) gin.HandlerFunc {
+// Replaces a whole item. Prefer patchItemHandler, which only changes the fields sent.
+// dedicatedFields keep their stored values, whatever was sent.
+func updateItemHandler[T any](itemDao *dao.DAO[T], config config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var item T
 
 		err := c.ShouldBindJSON(&item)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			writeProblem(c, apperr.Validationf("%w", err))
 			return
 		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 		defer cancel()
-		err = dao.Update(ctx, item)
+
+		keys := itemDao.KeysOf(item)
+		before, err := itemDao.Get(ctx, keys...)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s: %v: %w", itemDao.Name(), keys, err))
+			return
+		}
+		if err := keepDedicatedFields(itemDao.Name(), &item, *before); err != nil {
+			writeProblem(c, fmt.Errorf("failed to keep stored fields of %s: %v: %w", itemDao.Name(), keys, err))
+			return
+		}
+		if err := dao.Validate(item, config); err != nil {
+			writeProblem(c, err)
+			return
+		}
+
+		err = itemDao.Update(ctx, item)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to delete %s: %v: %v", dao.Name(), item, err)})
+			writeProblem(c, fmt.Errorf("failed to update %s: %v: %w", itemDao.Name(), item, err))
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("deleted item %v from %s", item, dao.Name())})
+		recordChange(c, auditTarget(itemDao.Name(), keys), before, item)
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("updated item %v in %s", item, itemDao.Name())})
 	}
 }
 
-func getAllItemsHandler[T any](dao *dao.DAO[T]) gin.HandlerFunc {
+// Fields of each table which are changed through endpoints of their own, because changing
+// them has side effects, with where to change them instead.
+var dedicatedFields = map[string]map[string]string{
+	"participant": {
+		"status":     "use PUT /shortinette/v1/participants/:intra_login/status",
+		"deleted_at": "use DELETE /shortinette/v1/participants/:intra_login or POST /shortinette/v1/participants/:intra_login/restore",
+	},
+	"module": {
+		"deleted_at": "use DELETE /shortinette/v1/modules/:id/:intra_login or POST /shortinette/v1/modules/:id/:intra_login/restore",
+	},
+}
+
+// Overwrites the dedicatedFields of `item`, the table of which is `name`, with their values
+// in `stored`.
+func keepDedicatedFields[T any](name string, item *T, stored T) error {
+	storedJSON, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	var storedFields map[string]json.RawMessage
+	if err := json.Unmarshal(storedJSON, &storedFields); err != nil {
+		return err
+	}
+
+	kept := map[string]json.RawMessage{}
+	for field := range dedicatedFields[name] {
+		// Fields left out because of omitempty are empty
+		kept[field] = json.RawMessage("null")
+		if value, ok := storedFields[field]; ok {
+			kept[field] = value
+		}
+	}
+	keptJSON, err := json.Marshal(kept)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(keptJSON, item)
+}
+
+// Changes the fields sent in the JSON body of the item identified by the route parameters,
+// and returns the updated item. Primary keys and dedicatedFields cannot be changed. Fields
+// are named after their columns.
+func patchItemHandler[T any](itemDao *dao.DAO[T], config config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		body, err := c.GetRawData()
+		if err != nil {
+			writeProblem(c, apperr.Validationf("failed to read request body: %w", err))
+			return
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			writeProblem(c, apperr.Validationf("body must be a JSON object: %w", err))
+			return
+		}
+		var errs dao.FieldErrors
+		for field := range fields {
+			if slices.Contains(itemDao.PrimaryKeys(), field) {
+				errs = append(errs, dao.FieldError{Field: field, Message: "cannot be changed"})
+			} else if instead, dedicated := dedicatedFields[itemDao.Name()][field]; dedicated {
+				errs = append(errs, dao.FieldError{Field: field, Message: "cannot be changed here, " + instead})
+			} else if !slices.Contains(itemDao.PublicColumns(), field) {
+				errs = append(errs, dao.FieldError{Field: field, Message: "is not a field of " + itemDao.Name()})
+			}
+		}
+		if len(errs) > 0 {
+			writeProblem(c, apperr.Validationf("invalid patch: %w", errs))
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 		defer cancel()
 
-		items, err := dao.GetAll(ctx)
+		args := collectArgs(c.Params)
+		item, err := itemDao.Get(ctx, args...)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to get all %s`s: %v", dao.Name(), err)})
+			writeProblem(c, fmt.Errorf("failed to get %s: %v: %w", itemDao.Name(), args, err))
+			return
+		}
+
+		before := *item
+		if err := json.Unmarshal(body, item); err != nil {
+			writeProblem(c, apperr.Validationf("invalid patch: %w", err))
+			return
+		}
+		if err := dao.Validate(*item, config); err != nil {
+			writeProblem(c, err)
 			return
 		}
-		if len(items) == 0 {
-			c.JSON(http.StatusNoContent, items)
-		} else {
-			c.JSON(http.StatusOK, items)
+
+		if err := itemDao.Update(ctx, *item); err != nil {
+			writeProblem(c, fmt.Errorf("failed to update %s: %v: %w", itemDao.Name(), args, err))
+			return
 		}
+		recordChange(c, auditTarget(itemDao.Name(), args), before, item)
+		c.JSON(http.StatusOK, item)
+	}
+}
+
+// Returns one page of items, see parsePageQuery for the supported query parameters.
+func getAllItemsHandler[T any](dao *dao.DAO[T]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, err := parsePageQuery(c, dao)
+		if err != nil {
+			writeProblem(c, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		items, total, err := dao.GetPage(ctx, query)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get all %s`s: %w", dao.Name(), err))
+			return
+		}
+		c.JSON(http.StatusOK, newPage(items, total, query))
 	}
 }
 
@@ -157,24 +412,46 @@ func getItemHandler[T any](dao *dao.DAO[T]) gin.HandlerFunc {
 		args := collectArgs(c.Params)
 		item, err := dao.Get(ctx, args...)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("failed to get %s: %v: %v", dao.Name(), args, err)})
+			writeProblem(c, fmt.Errorf("failed to get %s: %v: %w", dao.Name(), args, err))
 			return
 		}
 		c.JSON(http.StatusOK, item)
 	}
 }
 
+// Lists the modules of the participant `:intra_login`.
+func getParticipantModulesHandler(moduleDao *dao.DAO[dao.Module]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		modules, err := moduleDao.GetFiltered(ctx, map[string]any{"intra_login": c.Param("intra_login")})
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s`s: %w", moduleDao.Name(), err))
+			return
+		}
+		c.JSON(http.StatusOK, modules)
+	}
+}
+
 func deleteItemHandler[T any](dao *dao.DAO[T]) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 		defer cancel()
 
 		args := collectArgs(c.Params)
-		err := dao.Delete(ctx, args...)
+		before, err := dao.Get(ctx, args...)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s: %v: %w", dao.Name(), args, err))
+			return
+		}
+
+		err = dao.Delete(ctx, args...)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("failed to delete %s: %v", dao.Name(), err)})
+			writeProblem(c, fmt.Errorf("failed to delete %s: %w", dao.Name(), err))
 			return
 		}
+		recordChange(c, auditTarget(dao.Name(), args), before, nil)
 		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("deleted item from %s %v", dao.Name(), args)})
 	}
 }
@@ -188,35 +465,38 @@ func collectArgs(params gin.Params) []any {
 	return args
 }
 
-func processGithubPayload(payload gitHubWebhookPayload, moduleDao *dao.DAO[dao.Module], participantDao *dao.DAO[dao.Participant], config config.Config) error {
+// Starts a grading if `payload` is a submission. Returns what was done with the payload,
+// to be recorded along with its delivery.
+func processGithubPayload(payload gitHubWebhookPayload, moduleDao *dao.DAO[dao.Module], participantDao *dao.DAO[dao.Participant], attemptDao *dao.DAO[dao.Attempt], auditDao *dao.DAO[dao.AuditEntry], config config.Config) (outcome string, grade func() error, err error) {
 	if payload.Ref != "refs/heads/main" || payload.Pusher.Name == os.Getenv("GITHUB_ADMIN") {
 		logger.Info.Printf("invalid payload (not on main), payload.Ref: %s\n", payload.Ref)
-		return nil
+		return "ignored: not a push to main", nil, nil
 	}
 
 	if payload.Commit.Message != "grademe" {
 		logger.Info.Printf("invalid payload (commit msg not grademe)\n")
-		return nil
+		return "ignored: commit message is not 'grademe'", nil, nil
 	}
 
 	if len(payload.Repository.Name) < len(payload.Pusher.Name) {
 		logger.Info.Printf("invalid payload (weird repo name)\n")
-		return fmt.Errorf("invalid Repository name: %s", payload.Repository.Name)
+		return "", nil, apperr.Validationf("invalid Repository name: %s", payload.Repository.Name)
 	}
 
 	moduleId, err := strconv.Atoi(payload.Repository.Name[len(payload.Repository.Name)-2:])
 	if err != nil {
 		logger.Info.Printf("invalid payload (broken repo name, no int in the end)\n")
-		return fmt.Errorf("invalid Repository name: %s", payload.Repository.Name)
+		return "", nil, apperr.Validationf("invalid Repository name: %s", payload.Repository.Name)
 	}
 
 	logger.Info.Printf("push event on %s identified as submission.", payload.Repository.Name)
-	mg := newModuleGrader(moduleDao, participantDao, context.TODO(), config)
-	go func() {
-		err := mg.process(payload.Repository.Name[:len(payload.Repository.Name)-3], moduleId)
-		if err != nil {
-			logger.Error.Printf("grading failed for %s-%02d: %v", payload.Pusher.Name, moduleId, err)
-		}
-	}()
-	return nil
+	mg, err := newModuleGrader(moduleDao, participantDao, attemptDao, auditDao, "github:"+payload.Pusher.Name, context.TODO(), config)
+	if err != nil {
+		return "", nil, err
+	}
+	// Grading takes too long to keep GitHub waiting, the caller runs it in the background
+	grade = func() error {
+		return mg.process(payload.Repository.Name[:len(payload.Repository.Name)-3], moduleId)
+	}
+	return "grading started", grade, nil
 }
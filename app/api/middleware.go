@@ -2,22 +2,26 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"io"
-	"net/http"
+	"slices"
 
+	"github.com/42-Short/shortinette/apperr"
+	"github.com/42-Short/shortinette/config"
+	"github.com/42-Short/shortinette/dao"
 	"github.com/42-Short/shortinette/logger"
 	"github.com/gin-gonic/gin"
 )
 
-func githubAuthMiddleware(accessToken string) gin.HandlerFunc {
+func githubAuthMiddleware(secret *config.Secret) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		body, err := io.ReadAll(c.Request.Body)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
-			c.Abort()
+			abortWithProblem(c, apperr.Validationf("failed to read request body"))
 			return
 		}
 		c.Set(gin.BodyBytesKey, body)
@@ -25,18 +29,24 @@ func githubAuthMiddleware(accessToken string) gin.HandlerFunc {
 
 		signature := c.GetHeader("X-Hub-Signature-256")
 		if signature == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing authentication header for GitHub webhook"})
-			c.Abort()
+			abortWithProblem(c, apperr.Unauthorizedf("missing authentication header for GitHub webhook"))
 			return
 		}
 
-		mac := hmac.New(sha256.New, []byte(accessToken))
-		mac.Write(body)
-		expectedSignature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		// While a rotation is in progress, deliveries signed with the previous secret are still valid
+		valid := false
+		for _, candidate := range secret.Accepted() {
+			mac := hmac.New(sha256.New, []byte(candidate))
+			mac.Write(body)
+			expectedSignature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
 
-		if !hmac.Equal([]byte(signature), []byte(expectedSignature)) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication tokens do not match"})
-			c.Abort()
+			if hmac.Equal([]byte(signature), []byte(expectedSignature)) {
+				valid = true
+			}
+		}
+
+		if !valid {
+			abortWithProblem(c, apperr.Unauthorizedf("authentication tokens do not match"))
 			return
 		}
 
@@ -44,12 +54,45 @@ func githubAuthMiddleware(accessToken string) gin.HandlerFunc {
 	}
 }
 
-func tokenAuthMiddleware(accessToken string) gin.HandlerFunc {
+// Scopes a token can be issued with.
+const (
+	scopeAdmin         = "admin"                // Full access
+	scopeStaffReadonly = "staff-readonly"       // Read access to everything
+	scopeGraderTrigger = "grader-trigger"       // May trigger gradings
+	scopeStudent       = "student-self-service" // Read access to their own data, may trigger their own gradings
+)
+
+var validScopes = []string{scopeAdmin, scopeStaffReadonly, scopeGraderTrigger, scopeStudent}
+
+const principalKey = "principal"
+
+// Identity a request was authenticated as.
+type principal struct {
+	Name       string
+	Scope      string
+	IntraLogin string // Set for student tokens and Intra sessions
+}
+
+// Authenticates requests with either the bootstrap API token from the configuration, which has
+// admin rights, one of the tokens issued through the token management API, or the cookie of an
+// Intra login session.
+func tokenAuthMiddleware(accessToken *config.Secret, tokenDao *dao.DAO[dao.Token], sessionDao *dao.DAO[dao.Session]) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		if sessionID, err := c.Cookie(sessionCookie); authHeader == "" && err == nil {
+			session, err := lookupSession(c.Request.Context(), sessionDao, sessionID)
+			if err != nil {
+				abortWithProblem(c, apperr.Unauthorizedf("session invalid or expired"))
+				return
+			}
+
+			c.Set(principalKey, principal{Name: "intra:" + session.IntraLogin, Scope: session.Scope, IntraLogin: session.IntraLogin})
+			c.Next()
+			return
+		}
+
 		if authHeader == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "missing Authorization header format"})
-			c.Abort()
+			abortWithProblem(c, apperr.Unauthorizedf("missing Authorization header"))
 			return
 		}
 
@@ -58,13 +101,70 @@ func tokenAuthMiddleware(accessToken string) gin.HandlerFunc {
 			token = authHeader[7:]
 		}
 
-		if token != accessToken {
-			logger.Warning.Printf("unauthorized access attempt with token: %s \n", token)
-			c.JSON(http.StatusUnauthorized, gin.H{"message": "token invalid"})
-			c.Abort()
+		if token != "" && accessToken.Accepts(token) {
+			c.Set(principalKey, principal{Name: "api-token", Scope: scopeAdmin})
+			c.Next()
 			return
 		}
 
+		issued, err := lookupToken(c.Request.Context(), tokenDao, token)
+		if err != nil {
+			// Rejected tokens may be mistyped real ones, only log enough to tell attempts apart
+			logger.Warning.Printf("unauthorized access attempt with token sha256:%s\n", hashToken(token)[:12])
+			abortWithProblem(c, apperr.Unauthorizedf("token invalid"))
+			return
+		}
+
+		c.Set(principalKey, principal{Name: issued.Name, Scope: issued.Scope, IntraLogin: issued.IntraLogin})
 		c.Next()
 	}
 }
+
+// Returns the non-revoked token matching `token`.
+func lookupToken(ctx context.Context, tokenDao *dao.DAO[dao.Token], token string) (*dao.Token, error) {
+	if token == "" {
+		return nil, fmt.Errorf("empty token")
+	}
+
+	tokens, err := tokenDao.GetFiltered(ctx, map[string]any{"hash": hashToken(token)})
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) != 1 || tokens[0].RevokedAt != nil {
+		return nil, fmt.Errorf("token not found or revoked")
+	}
+	return &tokens[0], nil
+}
+
+// Issued tokens are random enough for an unsalted hash not to be brute-forceable.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Only lets requests through if they were authenticated with one of `scopes`. Admins are
+// always let through. Students are only let through on routes concerning themselves, i.e.
+// routes whose `:intra_login` parameter is their own login.
+func requireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller := getPrincipal(c)
+
+		allowed := caller.Scope == scopeAdmin || (slices.Contains(scopes, caller.Scope) && caller.Scope != scopeStudent)
+		if caller.Scope == scopeStudent && slices.Contains(scopes, scopeStudent) {
+			allowed = caller.IntraLogin != "" && c.Param("intra_login") == caller.IntraLogin
+		}
+
+		if !allowed {
+			abortWithProblem(c, apperr.Forbiddenf("token '%s' (%s) is not allowed to access this resource", caller.Name, caller.Scope))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func getPrincipal(c *gin.Context) principal {
+	caller, _ := c.Get(principalKey)
+	p, _ := caller.(principal)
+	return p
+}
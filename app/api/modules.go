@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/42-Short/shortinette/apperr"
+	"github.com/42-Short/shortinette/config"
+	"github.com/42-Short/shortinette/dao"
+	"github.com/42-Short/shortinette/logger"
+	"github.com/42-Short/shortinette/short"
+	"github.com/gin-gonic/gin"
+)
+
+// Gives `:intra_login` write access to their repo for module `:id` back after the module
+// closed, e.g. after an outage. Reopened repos are left alone when the module closes, and
+// their final grade is only frozen once they are closed again. Modules whose final grade is
+// already frozen cannot be reopened, as nothing graded afterwards would count.
+func reopenModuleHandler(moduleDao *dao.DAO[dao.Module], participantDao *dao.DAO[dao.Participant], finalGradeDao *dao.DAO[dao.FinalGrade], config config.Config) gin.HandlerFunc {
+	return setModuleClosedHandler(moduleDao, participantDao, nil, finalGradeDao, config, false)
+}
+
+// Closes the repo of `:intra_login` for module `:id` right away, e.g. to undo a reopen, and
+// freezes its final grade if the module is over.
+func closeModuleHandler(moduleDao *dao.DAO[dao.Module], participantDao *dao.DAO[dao.Participant], attemptDao *dao.DAO[dao.Attempt], finalGradeDao *dao.DAO[dao.FinalGrade], config config.Config) gin.HandlerFunc {
+	return setModuleClosedHandler(moduleDao, participantDao, attemptDao, finalGradeDao, config, true)
+}
+
+func setModuleClosedHandler(moduleDao *dao.DAO[dao.Module], participantDao *dao.DAO[dao.Participant], attemptDao *dao.DAO[dao.Attempt], finalGradeDao *dao.DAO[dao.FinalGrade], config config.Config, closed bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		module, err := moduleDao.Get(ctx, c.Param("id"), c.Param("intra_login"))
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s: %w", moduleDao.Name(), err))
+			return
+		}
+		participant, err := participantDao.Get(ctx, module.IntraLogin)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s: %w", participantDao.Name(), err))
+			return
+		}
+		if !closed && !participant.IsActive() {
+			writeProblem(c, apperr.Conflictf("%s is %s", participant.IntraLogin, participant.Status))
+			return
+		}
+		if !closed {
+			frozen, err := finalGradeDao.Query().Where("module_id", dao.Eq, module.Id).Where("intra_login", dao.Eq, module.IntraLogin).Exists(ctx)
+			if err != nil {
+				writeProblem(c, fmt.Errorf("failed to get %s: %w", finalGradeDao.Name(), err))
+				return
+			}
+			if frozen {
+				writeProblem(c, apperr.Conflictf("final grade of %s-%02d is frozen, it would not count anything graded after a reopen", module.IntraLogin, module.Id))
+				return
+			}
+		}
+
+		sh, err := short.NewShort(config, moduleDao.DB)
+		if err != nil {
+			writeProblem(c, err)
+			return
+		}
+
+		before := *module
+		if closed {
+			err = sh.CloseRepo(*participant, module.Id)
+			now := time.Now()
+			module.ClosedAt, module.Reopened = &now, false
+		} else {
+			err = sh.ReopenRepo(*participant, module.Id)
+			module.ClosedAt, module.Reopened = nil, true
+		}
+		if err != nil {
+			writeProblem(c, apperr.Wrap(apperr.Upstream, err, "could not update repo permissions"))
+			return
+		}
+
+		if err := moduleDao.Update(ctx, *module); err != nil {
+			writeProblem(c, fmt.Errorf("failed to update %s: %w", moduleDao.Name(), err))
+			return
+		}
+		recordChange(c, auditTarget(moduleDao.Name(), moduleDao.KeysOf(*module)), before, module)
+		logger.Info.Printf("repo %s-%02d closed=%t by %s", module.IntraLogin, module.Id, closed, getPrincipal(c).Name)
+
+		// The repo was reopened past the module's close, attempts count until now
+		if closed && module.ClosedAt.After(sh.ModuleClose(module.Id)) {
+			if err := sh.FreezeGrade(ctx, *module, *module.ClosedAt, attemptDao, finalGradeDao); err != nil {
+				writeProblem(c, err)
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, module)
+	}
+}
+
+// Undoes the deletion of module `:id` of `:intra_login`. Modules of deleted participants are
+// restored along with the participant.
+func restoreModuleHandler(moduleDao *dao.DAO[dao.Module], participantDao *dao.DAO[dao.Participant]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		module, err := moduleDao.Query().OnlyDeleted().
+			Where("id", dao.Eq, c.Param("id")).
+			Where("intra_login", dao.Eq, c.Param("intra_login")).
+			First(ctx)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get deleted %s: %w", moduleDao.Name(), err))
+			return
+		}
+		if _, err := participantDao.Get(ctx, module.IntraLogin); err != nil {
+			if apperr.Is(err, apperr.NotFound) {
+				err = apperr.Conflictf("%s is deleted, restore them first", module.IntraLogin)
+			}
+			writeProblem(c, err)
+			return
+		}
+
+		if err := moduleDao.Restore(ctx, module.Id, module.IntraLogin); err != nil {
+			writeProblem(c, fmt.Errorf("failed to restore %s: %w", moduleDao.Name(), err))
+			return
+		}
+
+		before := *module
+		module.DeletedAt = nil
+		recordChange(c, auditTarget(moduleDao.Name(), moduleDao.KeysOf(*module)), before, module)
+		c.JSON(http.StatusOK, module)
+	}
+}
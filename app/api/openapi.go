@@ -0,0 +1,39 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// OpenAPI document of the REST API, kept in sync with SetupRouter by tests. The client
+// package is generated from it.
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+func openAPIYAMLHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/yaml", openAPISpec)
+	}
+}
+
+func openAPIJSONHandler() gin.HandlerFunc {
+	var spec any
+	err := yaml.Unmarshal(openAPISpec, &spec)
+	if err == nil {
+		_, err = json.Marshal(spec)
+	}
+
+	return func(c *gin.Context) {
+		if err != nil {
+			writeProblem(c, fmt.Errorf("invalid OpenAPI document: %w", err))
+			return
+		}
+		c.JSON(http.StatusOK, spec)
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/42-Short/shortinette/apperr"
+	"github.com/42-Short/shortinette/dao"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// Query parameters of list endpoints which are not column filters.
+var pageParams = []string{"limit", "cursor", "sort", "deleted"}
+
+// Response of list endpoints. `NextCursor` is only set if there are more items.
+type page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Builds the query of a list endpoint from its query parameters:
+//   - `limit`: page size, between 1 and maxPageLimit
+//   - `cursor`: `next_cursor` of the previous page
+//   - `sort`: column to sort by, descending if prefixed with '-'
+//   - `deleted`: if true, lists deleted records instead, on tables which keep them
+//   - any other parameter is an equality filter on the column of the same name
+func parsePageQuery[T any](c *gin.Context, itemDao *dao.DAO[T]) (dao.PageQuery, error) {
+	query := dao.PageQuery{Filters: map[string]any{}, Limit: defaultPageLimit}
+	columns := itemDao.PublicColumns()
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return query, apperr.Validationf("limit must be a number between 1 and %d", maxPageLimit)
+		}
+		query.Limit = limit
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		offset, err := decodeCursor(raw)
+		if err != nil {
+			return query, apperr.Validationf("invalid cursor '%s'", raw)
+		}
+		query.Offset = offset
+	}
+
+	if sort := c.Query("sort"); sort != "" {
+		query.SortBy, query.Descending = strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-")
+		if !slices.Contains(columns, query.SortBy) {
+			return query, apperr.Validationf("cannot sort by '%s', expected one of %v", query.SortBy, columns)
+		}
+	}
+
+	if raw := c.Query("deleted"); raw != "" {
+		deleted, err := strconv.ParseBool(raw)
+		if err != nil {
+			return query, apperr.Validationf("deleted must be 'true' or 'false'")
+		}
+		query.Deleted = deleted
+	}
+
+	for param, values := range c.Request.URL.Query() {
+		if slices.Contains(pageParams, param) {
+			continue
+		}
+		if !slices.Contains(columns, param) {
+			return query, apperr.Validationf("cannot filter on '%s', expected one of %v", param, columns)
+		}
+		value, err := itemDao.ParseValue(param, values[0])
+		if err != nil {
+			return query, apperr.Validationf("invalid value for '%s': %w", param, err)
+		}
+		query.Filters[param] = value
+	}
+
+	return query, nil
+}
+
+func newPage[T any](items []T, total int, query dao.PageQuery) page[T] {
+	result := page[T]{Items: items, Total: total}
+	if next := query.Offset + len(items); next < total {
+		result.NextCursor = encodeCursor(next)
+	}
+	return result
+}
+
+// Cursors are opaque to clients, which should not rely on them being offsets.
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid offset")
+	}
+	return offset, nil
+}
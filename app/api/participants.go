@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/42-Short/shortinette/apperr"
+	"github.com/42-Short/shortinette/config"
+	"github.com/42-Short/shortinette/dao"
+	"github.com/42-Short/shortinette/logger"
+	"github.com/42-Short/shortinette/short"
+	"github.com/gin-gonic/gin"
+)
+
+type participantStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+	// Removes the participant from the repos of all started modules
+	RevokeAccess bool `json:"revoke_access"`
+}
+
+type provisionResponse struct {
+	Participant dao.Participant `json:"participant"`
+	Modules     []int           `json:"modules"`
+}
+
+// Provisions the currently open modules for `:intra_login`, who joined after the Short was launched.
+func provisionParticipantHandler(participantDao *dao.DAO[dao.Participant], moduleDao *dao.DAO[dao.Module], config config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		participant, err := participantDao.Get(ctx, c.Param("intra_login"))
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s: %w", participantDao.Name(), err))
+			return
+		}
+		if !participant.IsActive() {
+			writeProblem(c, apperr.Conflictf("%s is %s, set their status back to '%s' first", participant.IntraLogin, participant.Status, dao.ParticipantActive))
+			return
+		}
+
+		sh, err := short.NewShort(config, participantDao.DB)
+		if err != nil {
+			writeProblem(c, err)
+			return
+		}
+
+		modules, err := sh.ProvisionLateJoiner(*participant, moduleDao)
+		if err != nil {
+			writeProblem(c, apperr.Upstreamf("could not provision %s: %w", participant.IntraLogin, err), gin.H{"modules": modules})
+			return
+		}
+
+		c.JSON(http.StatusOK, provisionResponse{Participant: *participant, Modules: modules})
+	}
+}
+
+// Sets the status of `:intra_login`. Withdrawn and banned participants do not get repos
+// for upcoming modules and are not graded anymore.
+func updateParticipantStatusHandler(participantDao *dao.DAO[dao.Participant], config config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request participantStatusRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			writeProblem(c, apperr.Validationf("%w", err))
+			return
+		}
+		if !slices.Contains(dao.ParticipantStatuses, request.Status) {
+			writeProblem(c, apperr.Validationf("invalid status '%s', expected one of %v", request.Status, dao.ParticipantStatuses))
+			return
+		}
+		if request.RevokeAccess && request.Status == dao.ParticipantActive {
+			writeProblem(c, apperr.Validationf("cannot revoke the access of an active participant"))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		participant, err := participantDao.Get(ctx, c.Param("intra_login"))
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s: %w", participantDao.Name(), err))
+			return
+		}
+
+		before := *participant
+		participant.Status = request.Status
+		if err := participantDao.Update(ctx, *participant); err != nil {
+			writeProblem(c, fmt.Errorf("failed to update %s: %w", participantDao.Name(), err))
+			return
+		}
+		recordChange(c, auditTarget(participantDao.Name(), participantDao.KeysOf(*participant)), before, participant)
+		logger.Info.Printf("%s is now %s", participant.IntraLogin, participant.Status)
+
+		if request.RevokeAccess {
+			sh, err := short.NewShort(config, participantDao.DB)
+			if err == nil {
+				err = sh.RevokeAccess(*participant)
+			}
+			if err != nil {
+				writeProblem(c, apperr.Upstreamf("status updated, but access could not be revoked: %w", err), gin.H{"participant": participant})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, participant)
+	}
+}
+
+// Deletes `:intra_login` along with their modules. They are only marked as deleted, and can
+// be restored until they are purged after the Short.
+func deleteParticipantHandler(participantDao *dao.DAO[dao.Participant], moduleDao *dao.DAO[dao.Module]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		intraLogin := c.Param("intra_login")
+		participant, err := participantDao.Get(ctx, intraLogin)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s: %w", participantDao.Name(), err))
+			return
+		}
+
+		// Modules are deleted at the same time as the participant, so that restoring the
+		// participant does not restore modules which were deleted on their own
+		now := time.Now()
+		err = dao.WithTx(ctx, participantDao.DB, func(tx *dao.Tx) error {
+			modules, err := moduleDao.In(tx).Query().Where("intra_login", dao.Eq, intraLogin).All(ctx)
+			if err != nil {
+				return err
+			}
+			for _, module := range modules {
+				if err := moduleDao.In(tx).DeleteAt(ctx, now, module.Id, module.IntraLogin); err != nil {
+					return err
+				}
+			}
+			return participantDao.In(tx).DeleteAt(ctx, now, intraLogin)
+		})
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to delete %s: %w", participantDao.Name(), err))
+			return
+		}
+		recordChange(c, auditTarget(participantDao.Name(), []any{intraLogin}), participant, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("deleted item from %s [%s]", participantDao.Name(), intraLogin)})
+	}
+}
+
+// Undoes the deletion of `:intra_login`, along with the modules deleted with them.
+func restoreParticipantHandler(participantDao *dao.DAO[dao.Participant], moduleDao *dao.DAO[dao.Module]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		intraLogin := c.Param("intra_login")
+		participant, err := participantDao.Query().OnlyDeleted().Where("intra_login", dao.Eq, intraLogin).First(ctx)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get deleted %s: %w", participantDao.Name(), err))
+			return
+		}
+
+		err = dao.WithTx(ctx, participantDao.DB, func(tx *dao.Tx) error {
+			modules, err := moduleDao.In(tx).Query().OnlyDeleted().
+				Where("intra_login", dao.Eq, intraLogin).
+				Where("deleted_at", dao.Eq, *participant.DeletedAt).
+				All(ctx)
+			if err != nil {
+				return err
+			}
+			for _, module := range modules {
+				if err := moduleDao.In(tx).Restore(ctx, module.Id, module.IntraLogin); err != nil {
+					return err
+				}
+			}
+			return participantDao.In(tx).Restore(ctx, intraLogin)
+		})
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to restore %s: %w", participantDao.Name(), err))
+			return
+		}
+
+		before := *participant
+		participant.DeletedAt = nil
+		recordChange(c, auditTarget(participantDao.Name(), []any{intraLogin}), before, participant)
+		c.JSON(http.StatusOK, participant)
+	}
+}
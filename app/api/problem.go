@@ -0,0 +1,79 @@
+package api
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/42-Short/shortinette/apperr"
+	"github.com/42-Short/shortinette/dao"
+	"github.com/42-Short/shortinette/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	requestIDKey       = "request_id"
+	problemContentType = "application/problem+json"
+)
+
+// Request IDs sent by clients or proxies are only kept if they cannot mess up the logs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Tags every request with an ID, taken from the X-Request-ID header if the caller sent one,
+// and echoes it in the response so that errors can be matched with the logs.
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		c.Set(requestIDKey, requestID)
+		c.Header(requestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// Responds with `err` as problem+json document, its status depending on the kind of `err`.
+// The details of internal errors are only logged. Field errors of invalid records are listed
+// under `errors`, `extensions` are added to the document.
+func writeProblem(c *gin.Context, err error, extensions ...gin.H) {
+	kind := apperr.KindOf(err)
+	requestID := c.GetString(requestIDKey)
+
+	status, detail := kind.Status(), err.Error()
+	if kind == apperr.Internal {
+		logger.Error.Printf("request %s: %s %s: %v", requestID, c.Request.Method, c.Request.URL.Path, err)
+		detail = "an unexpected error occurred, see the server logs for request " + requestID
+	}
+
+	// See RFC 7807
+	document := gin.H{
+		"type":       "about:blank",
+		"title":      kind.String(),
+		"status":     status,
+		"detail":     detail,
+		"instance":   c.Request.URL.Path,
+		"request_id": requestID,
+	}
+	var fieldErrors dao.FieldErrors
+	if errors.As(err, &fieldErrors) {
+		document["errors"] = fieldErrors
+	}
+	for _, extension := range extensions {
+		for key, value := range extension {
+			document[key] = value
+		}
+	}
+
+	// gin keeps the Content-Type if it is already set
+	c.Header("Content-Type", problemContentType)
+	c.JSON(status, document)
+}
+
+// Same as writeProblem, and stops the handler chain.
+func abortWithProblem(c *gin.Context, err error, extensions ...gin.H) {
+	writeProblem(c, err, extensions...)
+	c.Abort()
+}
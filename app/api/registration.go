@@ -0,0 +1,252 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/42-Short/shortinette/apperr"
+	"github.com/42-Short/shortinette/config"
+	"github.com/42-Short/shortinette/dao"
+	"github.com/42-Short/shortinette/git"
+	"github.com/42-Short/shortinette/logger"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	registrationPending    = "pending-verification"
+	registrationRegistered = "registered"
+
+	verificationNone = "none"
+	verificationGist = "gist"
+)
+
+// GitHub account lookups, implemented by git.GithubService.
+type githubAccounts interface {
+	DoesAccountExist(ctx context.Context, username string) (bool, error)
+	HasGistWithDescription(ctx context.Context, username string, description string) (bool, error)
+}
+
+// Looks accounts up with the credentials of the app, unauthenticated requests are limited to
+// 60 per hour and IP. Replaced in tests.
+var newGithubAccounts = func(conf config.Config) (githubAccounts, error) {
+	return git.NewGithubServiceFromConfig(conf, conf.BasePath)
+}
+
+var githubLoginPattern = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9]|-[a-zA-Z0-9]){0,38}$`)
+
+type registrationRequest struct {
+	GitHubLogin string `json:"github_login" binding:"required"`
+}
+
+type registrationChallengeResponse struct {
+	Challenge    string `json:"challenge"`
+	Instructions string `json:"instructions"`
+}
+
+// Registers the caller as participant with the GitHub account they submitted. If gist
+// verification is enabled, the participant is only created once they proved they own the
+// account, see verifyRegistrationHandler.
+func registerHandler(participantDao *dao.DAO[dao.Participant], registrationDao *dao.DAO[dao.Registration], config config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request registrationRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			writeProblem(c, apperr.Validationf("%w", err))
+			return
+		}
+		if !githubLoginPattern.MatchString(request.GitHubLogin) {
+			writeProblem(c, apperr.Validationf("'%s' is not a valid GitHub login", request.GitHubLogin))
+			return
+		}
+		// GitHub logins are case-insensitive, they are stored in lower case
+		request.GitHubLogin = strings.ToLower(request.GitHubLogin)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		caller, ok := checkRegistrationAllowed(ctx, c, participantDao, config)
+		if !ok {
+			return
+		}
+
+		taken, err := participantDao.Query().WithDeleted().Where("github_login", dao.EqFold, request.GitHubLogin).Exists(ctx)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s`s: %w", participantDao.Name(), err))
+			return
+		}
+		if taken {
+			writeProblem(c, apperr.Conflictf("GitHub account '%s' is already registered", request.GitHubLogin))
+			return
+		}
+
+		accounts, err := newGithubAccounts(config)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("could not set up GitHub client: %w", err))
+			return
+		}
+		exists, err := accounts.DoesAccountExist(ctx, request.GitHubLogin)
+		if err != nil {
+			writeProblem(c, apperr.Upstreamf("could not verify GitHub account: %w", err))
+			return
+		}
+		if !exists {
+			writeProblem(c, apperr.Validationf("GitHub account '%s' does not exist or is not a user account", request.GitHubLogin))
+			return
+		}
+
+		registration := dao.Registration{
+			IntraLogin:   caller.IntraLogin,
+			GitHubLogin:  request.GitHubLogin,
+			Status:       registrationPending,
+			Verification: verificationNone,
+			RemoteAddr:   c.ClientIP(),
+			CreatedAt:    time.Now(),
+		}
+
+		if !config.RegistrationVerifyGist {
+			participant, err := completeRegistration(ctx, participantDao, registrationDao, registration)
+			if err != nil {
+				writeProblem(c, err)
+				return
+			}
+			c.JSON(http.StatusCreated, participant)
+			return
+		}
+
+		secret, err := generateSecret()
+		if err != nil {
+			writeProblem(c, fmt.Errorf("could not generate challenge: %w", err))
+			return
+		}
+		registration.Verification = verificationGist
+		registration.Challenge = "shortinette-" + secret[:32]
+
+		// Submitting another GitHub login replaces the pending registration
+		if err := saveRegistration(ctx, registrationDao, registration); err != nil {
+			writeProblem(c, err)
+			return
+		}
+
+		c.JSON(http.StatusAccepted, registrationChallengeResponse{
+			Challenge:    registration.Challenge,
+			Instructions: fmt.Sprintf("create a public gist on GitHub account '%s' with '%s' as description, then call POST /shortinette/v1/registration/verify", registration.GitHubLogin, registration.Challenge),
+		})
+	}
+}
+
+// Completes a pending registration once the caller created the gist they were challenged with.
+func verifyRegistrationHandler(participantDao *dao.DAO[dao.Participant], registrationDao *dao.DAO[dao.Registration], config config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		caller, ok := checkRegistrationAllowed(ctx, c, participantDao, config)
+		if !ok {
+			return
+		}
+
+		registration, err := registrationDao.Get(ctx, caller.IntraLogin)
+		if err != nil || registration.Status != registrationPending || registration.Challenge == "" {
+			writeProblem(c, apperr.NotFoundf("no pending registration, please register first"))
+			return
+		}
+
+		taken, err := participantDao.Query().WithDeleted().Where("github_login", dao.EqFold, registration.GitHubLogin).Exists(ctx)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s`s: %w", participantDao.Name(), err))
+			return
+		}
+		if taken {
+			writeProblem(c, apperr.Conflictf("GitHub account '%s' is already registered", registration.GitHubLogin))
+			return
+		}
+
+		accounts, err := newGithubAccounts(config)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("could not set up GitHub client: %w", err))
+			return
+		}
+		found, err := accounts.HasGistWithDescription(ctx, registration.GitHubLogin, registration.Challenge)
+		if err != nil {
+			writeProblem(c, apperr.Upstreamf("could not verify GitHub account: %w", err))
+			return
+		}
+		if !found {
+			writeProblem(c, apperr.Validationf("no public gist with description '%s' found on GitHub account '%s'", registration.Challenge, registration.GitHubLogin))
+			return
+		}
+
+		registration.RemoteAddr = c.ClientIP()
+		participant, err := completeRegistration(ctx, participantDao, registrationDao, *registration)
+		if err != nil {
+			writeProblem(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, participant)
+	}
+}
+
+// Only students logged in through the Intra may register, during the registration window,
+// and only once.
+func checkRegistrationAllowed(ctx context.Context, c *gin.Context, participantDao *dao.DAO[dao.Participant], config config.Config) (caller principal, ok bool) {
+	caller = getPrincipal(c)
+	if caller.Scope != scopeStudent || caller.IntraLogin == "" {
+		writeProblem(c, apperr.Forbiddenf("only students logged in through the Intra can register"))
+		return caller, false
+	}
+
+	if !config.RegistrationOpen(time.Now()) {
+		writeProblem(c, apperr.Forbiddenf("registration is closed"))
+		return caller, false
+	}
+
+	// Deleted participants have to be restored by the staff
+	registered, err := participantDao.Query().WithDeleted().Where("intra_login", dao.Eq, caller.IntraLogin).Exists(ctx)
+	if err != nil {
+		writeProblem(c, fmt.Errorf("failed to get %s: %w", participantDao.Name(), err))
+		return caller, false
+	}
+	if registered {
+		writeProblem(c, apperr.Conflictf("%s is already registered", caller.IntraLogin))
+		return caller, false
+	}
+
+	return caller, true
+}
+
+// Creates the participant and marks `registration` as done, both or neither.
+func completeRegistration(ctx context.Context, participantDao *dao.DAO[dao.Participant], registrationDao *dao.DAO[dao.Registration], registration dao.Registration) (*dao.Participant, error) {
+	participant := dao.Participant{
+		IntraLogin:  registration.IntraLogin,
+		GitHubLogin: registration.GitHubLogin,
+		Status:      dao.ParticipantActive,
+	}
+
+	now := time.Now()
+	registration.Status = registrationRegistered
+	registration.Challenge = ""
+	registration.RegisteredAt = &now
+
+	err := dao.WithTx(ctx, participantDao.DB, func(tx *dao.Tx) error {
+		if err := participantDao.In(tx).Insert(ctx, participant); err != nil {
+			return fmt.Errorf("failed to insert %s: %w", participantDao.Name(), err)
+		}
+		return saveRegistration(ctx, registrationDao.In(tx), registration)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info.Printf("%s registered with GitHub account %s (verification: %s)", registration.IntraLogin, registration.GitHubLogin, registration.Verification)
+	return &participant, nil
+}
+
+func saveRegistration(ctx context.Context, registrationDao *dao.DAO[dao.Registration], registration dao.Registration) error {
+	if err := registrationDao.Upsert(ctx, registration); err != nil {
+		return fmt.Errorf("failed to save %s: %w", registrationDao.Name(), err)
+	}
+	return nil
+}
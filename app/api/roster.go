@@ -0,0 +1,375 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42-Short/shortinette/apperr"
+	"github.com/42-Short/shortinette/config"
+	"github.com/42-Short/shortinette/dao"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	importCreate    = "create"
+	importUpdate    = "update"
+	importUnchanged = "unchanged"
+	importInvalid   = "invalid"
+)
+
+var rosterHeader = []string{"intra_login", "github_login", "current_module_id", "current_module_score", "total_score"}
+
+type importRow struct {
+	IntraLogin  string `json:"intra_login"`
+	GitHubLogin string `json:"github_login"`
+}
+
+type importRowResult struct {
+	Row         int    `json:"row"`
+	IntraLogin  string `json:"intra_login"`
+	GitHubLogin string `json:"github_login"`
+	Action      string `json:"action"`
+	Error       string `json:"error,omitempty"`
+}
+
+type importReport struct {
+	DryRun    bool              `json:"dry_run"`
+	Applied   bool              `json:"applied"`
+	Created   int               `json:"created"`
+	Updated   int               `json:"updated"`
+	Unchanged int               `json:"unchanged"`
+	Invalid   int               `json:"invalid"`
+	Rows      []importRowResult `json:"rows"`
+}
+
+type rosterEntry struct {
+	IntraLogin         string `json:"intra_login"`
+	GitHubLogin        string `json:"github_login"`
+	CurrentModuleId    int    `json:"current_module_id"`
+	CurrentModuleScore int    `json:"current_module_score"`
+	TotalScore         int    `json:"total_score"`
+}
+
+// Imports participants from a CSV (`intra_login,github_login` header required) or JSON
+// (array of participants) body. Every row is validated first, then all are stored in one
+// transaction: if any is invalid or cannot be stored, nothing is imported. With
+// `?dry_run=true`, only the report of what would change is returned.
+func importParticipantsHandler(participantDao *dao.DAO[dao.Participant], config config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+		if err != nil {
+			writeProblem(c, apperr.Validationf("invalid dry_run '%s': expected 'true' or 'false'", c.Query("dry_run")))
+			return
+		}
+
+		rows, err := parseImportRows(c.ContentType(), c.Request.Body)
+		if err != nil {
+			writeProblem(c, apperr.Validationf("%w", err))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		// Deleted participants keep their logins until they are purged
+		existing, err := participantDao.Query().WithDeleted().All(ctx)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get all %s`s: %w", participantDao.Name(), err))
+			return
+		}
+
+		accounts, err := newGithubAccounts(config)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("could not set up GitHub client: %w", err))
+			return
+		}
+
+		report := validateImportRows(ctx, accounts, rows, existing, config)
+		report.DryRun = dryRun
+		if dryRun || report.Invalid > 0 {
+			status := http.StatusOK
+			if report.Invalid > 0 {
+				status = http.StatusUnprocessableEntity
+			}
+			c.JSON(status, report)
+			return
+		}
+
+		current := make(map[string]dao.Participant, len(existing))
+		for _, participant := range existing {
+			current[participant.IntraLogin] = participant
+		}
+		var created, updated []dao.Participant
+		for _, result := range report.Rows {
+			switch result.Action {
+			case importCreate:
+				created = append(created, importedParticipant(result, current))
+			case importUpdate:
+				updated = append(updated, importedParticipant(result, current))
+			}
+		}
+
+		err = dao.WithTx(ctx, participantDao.DB, func(tx *dao.Tx) error {
+			if err := participantDao.In(tx).InsertMany(ctx, created); err != nil {
+				return err
+			}
+			return participantDao.In(tx).UpdateMany(ctx, updated)
+		})
+		if err != nil {
+			writeProblem(c, fmt.Errorf("nothing was imported: %w", err), gin.H{"report": report})
+			return
+		}
+
+		report.Applied = true
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// Exports all participants with their current module and scores, as JSON or, with
+// `?format=csv`, as CSV.
+func exportParticipantsHandler(participantDao *dao.DAO[dao.Participant], moduleDao *dao.DAO[dao.Module]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "csv" {
+			writeProblem(c, apperr.Validationf("invalid format '%s': expected 'json' or 'csv'", format))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		participants, err := participantDao.GetAll(ctx)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get all %s`s: %w", participantDao.Name(), err))
+			return
+		}
+		modules, err := moduleDao.GetAll(ctx)
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get all %s`s: %w", moduleDao.Name(), err))
+			return
+		}
+
+		roster := buildRoster(participants, modules)
+		if format == "json" {
+			c.JSON(http.StatusOK, roster)
+			return
+		}
+
+		c.Header("Content-Disposition", `attachment; filename="participants.csv"`)
+		c.Header("Content-Type", "text/csv")
+		c.Status(http.StatusOK)
+
+		writer := csv.NewWriter(c.Writer)
+		_ = writer.Write(rosterHeader)
+		for _, entry := range roster {
+			_ = writer.Write([]string{
+				entry.IntraLogin,
+				entry.GitHubLogin,
+				strconv.Itoa(entry.CurrentModuleId),
+				strconv.Itoa(entry.CurrentModuleScore),
+				strconv.Itoa(entry.TotalScore),
+			})
+		}
+		writer.Flush()
+	}
+}
+
+func parseImportRows(contentType string, body io.Reader) ([]importRow, error) {
+	var rows []importRow
+
+	switch contentType {
+	case "application/json":
+		if err := json.NewDecoder(body).Decode(&rows); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+	case "text/csv":
+		reader := csv.NewReader(body)
+		reader.TrimLeadingSpace = true
+
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: could not read header: %v", err)
+		}
+		intraIdx, githubIdx := slices.Index(header, "intra_login"), slices.Index(header, "github_login")
+		if intraIdx < 0 || githubIdx < 0 {
+			return nil, fmt.Errorf("invalid CSV: header must contain 'intra_login' and 'github_login'")
+		}
+
+		for {
+			record, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid CSV: %v", err)
+			}
+			rows = append(rows, importRow{IntraLogin: record[intraIdx], GitHubLogin: record[githubIdx]})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Content-Type '%s': expected 'text/csv' or 'application/json'", contentType)
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no participants to import")
+	}
+	return rows, nil
+}
+
+// Decides what to do with each row, checking them against each other, against the
+// `existing` participants and against GitHub. Rows are numbered from 1, not counting the CSV
+// header.
+func validateImportRows(ctx context.Context, accounts githubAccounts, rows []importRow, existing []dao.Participant, conf config.Config) importReport {
+	githubOwners := make(map[string]string, len(existing))
+	current := make(map[string]dao.Participant, len(existing))
+	for _, participant := range existing {
+		githubOwners[strings.ToLower(participant.GitHubLogin)] = participant.IntraLogin
+		current[participant.IntraLogin] = participant
+	}
+
+	report := importReport{Rows: make([]importRowResult, 0, len(rows))}
+	seenIntra := make(map[string]int, len(rows))
+	seenGithub := make(map[string]int, len(rows))
+
+	for idx, row := range rows {
+		// GitHub logins are case-insensitive, they are compared and stored in lower case
+		result := importRowResult{Row: idx + 1, IntraLogin: strings.TrimSpace(row.IntraLogin), GitHubLogin: strings.ToLower(strings.TrimSpace(row.GitHubLogin))}
+		result.Action, result.Error = validateImportRow(result, current, githubOwners, seenIntra, seenGithub, conf)
+
+		if result.IntraLogin != "" {
+			seenIntra[result.IntraLogin] = result.Row
+		}
+		if result.GitHubLogin != "" {
+			seenGithub[result.GitHubLogin] = result.Row
+		}
+		report.Rows = append(report.Rows, result)
+	}
+
+	checkImportAccounts(ctx, accounts, report.Rows)
+
+	for _, result := range report.Rows {
+		switch result.Action {
+		case importCreate:
+			report.Created++
+		case importUpdate:
+			report.Updated++
+		case importUnchanged:
+			report.Unchanged++
+		case importInvalid:
+			report.Invalid++
+		}
+	}
+	return report
+}
+
+// Checks the rows which need it against each other and the existing participants, the
+// GitHub accounts are checked afterwards by checkImportAccounts.
+func validateImportRow(row importRowResult, current map[string]dao.Participant, githubOwners map[string]string, seenIntra map[string]int, seenGithub map[string]int, conf config.Config) (action string, reason string) {
+	if row.IntraLogin == "" || row.GitHubLogin == "" {
+		return importInvalid, "intra_login and github_login are required"
+	}
+	if !githubLoginPattern.MatchString(row.GitHubLogin) {
+		return importInvalid, fmt.Sprintf("'%s' is not a valid GitHub login", row.GitHubLogin)
+	}
+	if other, seen := seenIntra[row.IntraLogin]; seen {
+		return importInvalid, fmt.Sprintf("duplicate intra_login, already in row %d", other)
+	}
+	if other, seen := seenGithub[row.GitHubLogin]; seen {
+		return importInvalid, fmt.Sprintf("duplicate github_login, already in row %d", other)
+	}
+	if owner, taken := githubOwners[row.GitHubLogin]; taken && owner != row.IntraLogin {
+		return importInvalid, fmt.Sprintf("github_login already belongs to participant %s", owner)
+	}
+
+	participant, exists := current[row.IntraLogin]
+	if exists && participant.DeletedAt != nil {
+		return importInvalid, "participant was deleted, restore them first"
+	}
+	if exists && participant.GitHubLogin == row.GitHubLogin {
+		// Already verified when the participant was added
+		return importUnchanged, ""
+	}
+	if err := dao.Validate(importedParticipant(row, current), conf); err != nil {
+		return importInvalid, err.Error()
+	}
+
+	if exists {
+		return importUpdate, ""
+	}
+	return importCreate, ""
+}
+
+// GitHub lookups of an import running at the same time, so that large cohorts are checked
+// quickly without hitting GitHub's secondary rate limits.
+const importLookupConcurrency = 8
+
+// Marks the rows to be created or updated whose GitHub account does not exist as invalid.
+func checkImportAccounts(ctx context.Context, accounts githubAccounts, rows []importRowResult) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, importLookupConcurrency)
+	for i := range rows {
+		if rows[i].Action != importCreate && rows[i].Action != importUpdate {
+			continue
+		}
+		wg.Add(1)
+		go func(row *importRowResult) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			found, err := accounts.DoesAccountExist(ctx, row.GitHubLogin)
+			switch {
+			case err != nil:
+				row.Action, row.Error = importInvalid, fmt.Sprintf("could not verify GitHub account: %v", err)
+			case !found:
+				row.Action, row.Error = importInvalid, fmt.Sprintf("GitHub account '%s' does not exist or is not a user account", row.GitHubLogin)
+			}
+		}(&rows[i])
+	}
+	wg.Wait()
+}
+
+// The participant `row` creates, or the `current` one with the changes of `row`.
+func importedParticipant(row importRowResult, current map[string]dao.Participant) dao.Participant {
+	participant, exists := current[row.IntraLogin]
+	if !exists {
+		participant.Status = dao.ParticipantActive
+	}
+	participant.IntraLogin, participant.GitHubLogin = row.IntraLogin, row.GitHubLogin
+	return participant
+}
+
+func buildRoster(participants []dao.Participant, modules []dao.Module) []rosterEntry {
+	byLogin := make(map[string][]dao.Module, len(participants))
+	for _, module := range modules {
+		byLogin[module.IntraLogin] = append(byLogin[module.IntraLogin], module)
+	}
+
+	roster := make([]rosterEntry, 0, len(participants))
+	for _, participant := range participants {
+		entry := rosterEntry{
+			IntraLogin:      participant.IntraLogin,
+			GitHubLogin:     participant.GitHubLogin,
+			CurrentModuleId: participant.CurrentModuleId,
+		}
+		for _, module := range byLogin[participant.IntraLogin] {
+			entry.TotalScore += module.Score
+			if module.Id == participant.CurrentModuleId {
+				entry.CurrentModuleScore = module.Score
+			}
+		}
+		roster = append(roster, entry)
+	}
+
+	slices.SortFunc(roster, func(a, b rosterEntry) int { return strings.Compare(a.IntraLogin, b.IntraLogin) })
+	return roster
+}
@@ -1,33 +1,91 @@
 package api
 
 import (
+	"github.com/42-Short/shortinette/apperr"
 	"github.com/42-Short/shortinette/dao"
+	"github.com/42-Short/shortinette/intra"
+	"github.com/gin-gonic/gin"
 )
 
 func (api *API) SetupRouter() {
-	group := api.Engine.Group("/shortinette/v1")
-	group.Use(tokenAuthMiddleware(api.config.ApiToken))
-
 	moduleDAO := dao.NewDAO[dao.Module](api.DB)
 	participantDAO := dao.NewDAO[dao.Participant](api.DB)
+	deliveryDAO := dao.NewDAO[dao.WebhookDelivery](api.DB)
+	tokenDAO := dao.NewDAO[dao.Token](api.DB)
+	sessionDAO := dao.NewDAO[dao.Session](api.DB)
+	registrationDAO := dao.NewDAO[dao.Registration](api.DB)
+	attemptDAO := dao.NewDAO[dao.Attempt](api.DB)
+	finalGradeDAO := dao.NewDAO[dao.FinalGrade](api.DB)
+	auditDAO := dao.NewDAO[dao.AuditEntry](api.DB)
+
+	api.Engine.Use(requestIDMiddleware())
+	api.Engine.NoRoute(func(c *gin.Context) {
+		writeProblem(c, apperr.NotFoundf("no route %s %s", c.Request.Method, c.Request.URL.Path))
+	})
+
+	if api.config.IntraEnabled() {
+		client := intra.NewClient(api.config.IntraURL, api.config.IntraClientID, api.config.IntraClientSecret, api.config.IntraRedirectURL)
+
+		auth := api.Engine.Group("/shortinette/auth")
+		auth.GET("/login", intraLoginHandler(client, *api.config))
+		auth.GET("/callback", intraCallbackHandler(client, sessionDAO, *api.config))
+		auth.POST("/logout", intraLogoutHandler(sessionDAO, *api.config))
+	}
+
+	api.Engine.GET("/shortinette/openapi.yaml", openAPIYAMLHandler())
+	api.Engine.GET("/shortinette/openapi.json", openAPIJSONHandler())
+
+	group := api.Engine.Group("/shortinette/v1")
+	group.Use(tokenAuthMiddleware(api.config.ApiToken, tokenDAO, sessionDAO), auditMiddleware(auditDAO))
+
+	admin := requireScope(scopeAdmin)
+	staff := requireScope(scopeStaffReadonly)
+	self := requireScope(scopeStaffReadonly, scopeStudent)
+
+	api.Engine.POST("/shortinette/webhook/grademe", githubAuthMiddleware(api.config.WebhookSecret), githubWebhookHandler(moduleDAO, participantDAO, attemptDAO, auditDAO, deliveryDAO, *api.config))
+	group.Any("/modules/:id/:intra_login/grademe", requireScope(scopeGraderTrigger, scopeStudent), gradingHandler(moduleDAO, participantDAO, attemptDAO, auditDAO, *api.config))
+	group.POST("/modules/:id/:intra_login/reopen", admin, reopenModuleHandler(moduleDAO, participantDAO, finalGradeDAO, *api.config))
+	group.POST("/modules/:id/:intra_login/close", admin, closeModuleHandler(moduleDAO, participantDAO, attemptDAO, finalGradeDAO, *api.config))
+
+	group.POST("/modules", admin, insertItemHandler(moduleDAO, *api.config))
+	group.POST("/participants", admin, insertItemHandler(participantDAO, *api.config))
+	group.POST("/participants/import", admin, importParticipantsHandler(participantDAO, *api.config))
+	group.POST("/participants/:intra_login/provision", admin, provisionParticipantHandler(participantDAO, moduleDAO, *api.config))
+
+	group.PUT("/modules", admin, updateItemHandler(moduleDAO, *api.config))
+	group.PUT("/participants", admin, updateItemHandler(participantDAO, *api.config))
+	group.PATCH("/modules/:id/:intra_login", admin, patchItemHandler(moduleDAO, *api.config))
+	group.PATCH("/participants/:intra_login", admin, patchItemHandler(participantDAO, *api.config))
+	group.PUT("/participants/:intra_login/status", admin, updateParticipantStatusHandler(participantDAO, *api.config))
+
+	group.GET("/modules", staff, getAllItemsHandler(moduleDAO))
+	group.GET("/participants", staff, getAllItemsHandler(participantDAO))
+	group.GET("/participants/export", staff, exportParticipantsHandler(participantDAO, moduleDAO))
+
+	group.GET("/modules/:id/:intra_login", self, getItemHandler(moduleDAO))
+	group.GET("/participants/:intra_login", self, getItemHandler(participantDAO))
+	group.GET("/participants/:intra_login/modules", self, getParticipantModulesHandler(moduleDAO))
+	group.GET("/participants/:intra_login/attempts", self, getParticipantAttemptsHandler(attemptDAO))
 
-	api.Engine.POST("/shortinette/webhook/grademe", githubAuthMiddleware(api.config.ApiToken), githubWebhookHandler(moduleDAO, participantDAO, *api.config))
-	group.Any("/modules/:id/:intra_login/grademe", gradingHandler(moduleDAO, participantDAO, *api.config))
+	group.GET("/grades", staff, exportFinalGradesHandler(finalGradeDAO))
 
-	group.POST("/modules", insertItemHandler(moduleDAO))
-	group.POST("/participants", insertItemHandler(participantDAO))
+	group.DELETE("/modules/:id/:intra_login", admin, deleteItemHandler(moduleDAO))
+	group.DELETE("/participants/:intra_login", admin, deleteParticipantHandler(participantDAO, moduleDAO))
+	group.POST("/modules/:id/:intra_login/restore", admin, restoreModuleHandler(moduleDAO, participantDAO))
+	group.POST("/participants/:intra_login/restore", admin, restoreParticipantHandler(participantDAO, moduleDAO))
 
-	group.PUT("/modules", updateItemHandler(moduleDAO))
-	group.PUT("/participants", updateItemHandler(participantDAO))
+	group.GET("/me", meHandler(participantDAO, moduleDAO))
 
-	group.GET("/modules", getAllItemsHandler(moduleDAO))
-	group.GET("/participants", getAllItemsHandler(participantDAO))
+	group.POST("/registration", registerHandler(participantDAO, registrationDAO, *api.config))
+	group.POST("/registration/verify", verifyRegistrationHandler(participantDAO, registrationDAO, *api.config))
+	group.GET("/registration", admin, getAllItemsHandler(registrationDAO))
 
-	group.GET("/modules/:id/:intra_login", getItemHandler(moduleDAO))
-	group.GET("/participants/:intra_login", getItemHandler(participantDAO))
+	group.GET("/webhook/deliveries", staff, getRecentDeliveriesHandler(deliveryDAO))
+	group.GET("/audit", staff, getAuditLogHandler(auditDAO))
 
-	group.DELETE("/modules/:id/:intra_login", deleteItemHandler(moduleDAO))
-	group.DELETE("/participants/:intra_login", deleteItemHandler(participantDAO))
+	group.POST("/tokens", admin, issueTokenHandler(tokenDAO, participantDAO))
+	group.GET("/tokens", admin, getAllItemsHandler(tokenDAO))
+	group.DELETE("/tokens/:id", admin, revokeTokenHandler(tokenDAO))
 
-	group.POST("/launch", launchShort(participantDAO, *api.config))
+	group.POST("/launch", admin, launchShort(api.DB, *api.config))
 }
@@ -0,0 +1,14 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+func generateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/42-Short/shortinette/apperr"
+	"github.com/42-Short/shortinette/dao"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const tokenPrefix = "sht_"
+
+type tokenRequest struct {
+	Name       string `json:"name" binding:"required"`
+	Scope      string `json:"scope" binding:"required"`
+	IntraLogin string `json:"intra_login"`
+}
+
+type issuedTokenResponse struct {
+	dao.Token
+	// Plain text token, only ever shown in this response
+	Secret string `json:"token"`
+}
+
+// Issues a new token. Student tokens must be bound to an existing participant.
+func issueTokenHandler(tokenDao *dao.DAO[dao.Token], participantDao *dao.DAO[dao.Participant]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request tokenRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			writeProblem(c, apperr.Validationf("%w", err))
+			return
+		}
+
+		if !slices.Contains(validScopes, request.Scope) {
+			writeProblem(c, apperr.Validationf("invalid scope '%s', expected one of %v", request.Scope, validScopes))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		if request.Scope == scopeStudent {
+			if _, err := participantDao.Get(ctx, request.IntraLogin); err != nil {
+				writeProblem(c, apperr.Validationf("student tokens must be bound to an existing participant: %w", err))
+				return
+			}
+		} else if request.IntraLogin != "" {
+			writeProblem(c, apperr.Validationf("only student tokens can be bound to a participant"))
+			return
+		}
+
+		secret, err := generateSecret()
+		if err != nil {
+			writeProblem(c, fmt.Errorf("could not generate token: %w", err))
+			return
+		}
+		plain := tokenPrefix + secret
+
+		token := dao.Token{
+			Id:         uuid.NewString(),
+			Name:       request.Name,
+			Hash:       hashToken(plain),
+			Scope:      request.Scope,
+			IntraLogin: request.IntraLogin,
+			CreatedAt:  time.Now(),
+		}
+		if err := tokenDao.Insert(ctx, token); err != nil {
+			writeProblem(c, fmt.Errorf("failed to insert %s: %w", tokenDao.Name(), err))
+			return
+		}
+
+		c.JSON(http.StatusCreated, issuedTokenResponse{Token: token, Secret: plain})
+	}
+}
+
+// Revokes a token. Revoked tokens are kept to know who had access when.
+func revokeTokenHandler(tokenDao *dao.DAO[dao.Token]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		token, err := tokenDao.Get(ctx, c.Param("id"))
+		if err != nil {
+			writeProblem(c, fmt.Errorf("failed to get %s: %w", tokenDao.Name(), err))
+			return
+		}
+
+		if token.RevokedAt == nil {
+			now := time.Now()
+			token.RevokedAt = &now
+			if err := tokenDao.Update(ctx, *token); err != nil {
+				writeProblem(c, fmt.Errorf("failed to revoke %s: %w", tokenDao.Name(), err))
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, token)
+	}
+}
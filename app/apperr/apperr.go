@@ -0,0 +1,108 @@
+// `apperr` defines the kinds of errors shortinette distinguishes, so that they can be
+// reported consistently, e.g. as HTTP statuses by the API, no matter where they come from.
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type Kind int
+
+const (
+	Internal     Kind = iota // Unexpected failure, the default for errors of unknown kind
+	NotFound                 // The resource does not exist
+	Conflict                 // The request conflicts with the current state of the resource
+	Validation               // The request is malformed or invalid
+	Unauthorized             // The caller is not authenticated
+	Forbidden                // The caller is authenticated but not allowed to do this
+	Upstream                 // A service shortinette relies on, e.g. GitHub, failed
+)
+
+var statuses = map[Kind]int{
+	Internal:     http.StatusInternalServerError,
+	NotFound:     http.StatusNotFound,
+	Conflict:     http.StatusConflict,
+	Validation:   http.StatusBadRequest,
+	Unauthorized: http.StatusUnauthorized,
+	Forbidden:    http.StatusForbidden,
+	Upstream:     http.StatusBadGateway,
+}
+
+// HTTP status errors of this kind are reported with.
+func (kind Kind) Status() int {
+	return statuses[kind]
+}
+
+func (kind Kind) String() string {
+	return http.StatusText(kind.Status())
+}
+
+type Error struct {
+	Kind    Kind
+	Message string
+	Err     error // Cause, if any
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Returns an error of `kind` with a formatted message. As with fmt.Errorf, a %w verb wraps
+// its operand as cause.
+func New(kind Kind, format string, args ...any) *Error {
+	wrapped := fmt.Errorf(format, args...)
+	return &Error{Kind: kind, Message: wrapped.Error(), Err: errors.Unwrap(wrapped)}
+}
+
+// Marks `err` as being of `kind`, prefixing it with `message`.
+func Wrap(kind Kind, err error, message string) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf("%s: %v", message, err), Err: err}
+}
+
+func NotFoundf(format string, args ...any) *Error {
+	return New(NotFound, format, args...)
+}
+
+func Conflictf(format string, args ...any) *Error {
+	return New(Conflict, format, args...)
+}
+
+func Validationf(format string, args ...any) *Error {
+	return New(Validation, format, args...)
+}
+
+func Unauthorizedf(format string, args ...any) *Error {
+	return New(Unauthorized, format, args...)
+}
+
+func Forbiddenf(format string, args ...any) *Error {
+	return New(Forbidden, format, args...)
+}
+
+func Upstreamf(format string, args ...any) *Error {
+	return New(Upstream, format, args...)
+}
+
+func Internalf(format string, args ...any) *Error {
+	return New(Internal, format, args...)
+}
+
+// Returns the kind of the first *Error in the chain of `err`, Internal if there is none.
+func KindOf(err error) Kind {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr.Kind
+	}
+	return Internal
+}
+
+// Returns true if `err` is of `kind`.
+func Is(err error, kind Kind) bool {
+	return err != nil && KindOf(err) == kind
+}
@@ -0,0 +1,61 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestKindOfWrapped(t *testing.T) {
+	cause := errors.New("no rows")
+	err := fmt.Errorf("failed to get module: %w", NotFoundf("no such record: %w", cause))
+
+	if kind := KindOf(err); kind != NotFound {
+		t.Fatalf("expected %v, got %v", NotFound, kind)
+	}
+	if !errors.Is(err, cause) {
+		t.Fatalf("the cause should be kept in the chain")
+	}
+	if err.Error() != "failed to get module: no such record: no rows" {
+		t.Fatalf("unexpected message: %s", err.Error())
+	}
+}
+
+func TestKindOfUnknown(t *testing.T) {
+	if kind := KindOf(errors.New("boom")); kind != Internal {
+		t.Fatalf("errors of unknown kind should be internal, got %v", kind)
+	}
+	if Is(nil, Internal) {
+		t.Fatalf("nil is not an error of any kind")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	cause := Validationf("bad input")
+	err := Wrap(Upstream, cause, "GitHub refused the request")
+
+	if KindOf(err) != Upstream {
+		t.Fatalf("the outermost kind should win, got %v", KindOf(err))
+	}
+	if err.Error() != "GitHub refused the request: bad input" {
+		t.Fatalf("unexpected message: %s", err.Error())
+	}
+}
+
+func TestStatuses(t *testing.T) {
+	expected := map[Kind]int{
+		Internal:     http.StatusInternalServerError,
+		NotFound:     http.StatusNotFound,
+		Conflict:     http.StatusConflict,
+		Validation:   http.StatusBadRequest,
+		Unauthorized: http.StatusUnauthorized,
+		Forbidden:    http.StatusForbidden,
+		Upstream:     http.StatusBadGateway,
+	}
+	for kind, status := range expected {
+		if kind.Status() != status {
+			t.Fatalf("expected %v to be reported with %d, got %d", kind, status, kind.Status())
+		}
+	}
+}
@@ -0,0 +1,535 @@
+// Code generated by client/gen from api/openapi.yaml. DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Error document, see RFC 7807. Some errors carry additional members, e.g. the `report`
+// of an import which stopped half-way.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Instance  string `json:"instance"`
+	RequestID string `json:"request_id"`
+	// Problems with single fields of an invalid record
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type Message struct {
+	Message string `json:"message,omitempty"`
+}
+
+type Module struct {
+	ID         int       `json:"id"`
+	IntraLogin string    `json:"intra_login"`
+	Attempts   int       `json:"attempts,omitempty"`
+	Score      int       `json:"score,omitempty"`
+	LastGraded time.Time `json:"last_graded,omitempty"`
+	// Nanoseconds between two gradings
+	WaitTime  int64      `json:"wait_time,omitempty"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty"`
+	Reopened  bool       `json:"reopened,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+type ModulePatch struct {
+	Attempts   *int       `json:"attempts,omitempty"`
+	Score      *int       `json:"score,omitempty"`
+	LastGraded *time.Time `json:"last_graded,omitempty"`
+	// Nanoseconds between two gradings
+	WaitTime *int64     `json:"wait_time,omitempty"`
+	ClosedAt *time.Time `json:"closed_at,omitempty"`
+	Reopened *bool      `json:"reopened,omitempty"`
+}
+
+type Participant struct {
+	IntraLogin      string     `json:"intra_login"`
+	GithubLogin     string     `json:"github_login"`
+	CurrentModuleID int        `json:"current_module_id,omitempty"`
+	Status          string     `json:"status,omitempty"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
+}
+
+type ParticipantPatch struct {
+	GithubLogin     *string `json:"github_login,omitempty"`
+	CurrentModuleID *int    `json:"current_module_id,omitempty"`
+}
+
+type Attempt struct {
+	ID         string    `json:"id,omitempty"`
+	ModuleID   int       `json:"module_id,omitempty"`
+	IntraLogin string    `json:"intra_login,omitempty"`
+	Score      int       `json:"score,omitempty"`
+	Passed     bool      `json:"passed,omitempty"`
+	CommitSHA  string    `json:"commit_sha,omitempty"`
+	TraceRef   string    `json:"trace_ref,omitempty"`
+	GradedAt   time.Time `json:"graded_at,omitempty"`
+}
+
+type FinalGrade struct {
+	ModuleID   int       `json:"module_id,omitempty"`
+	IntraLogin string    `json:"intra_login,omitempty"`
+	Score      int       `json:"score,omitempty"`
+	Passed     bool      `json:"passed,omitempty"`
+	AttemptID  string    `json:"attempt_id,omitempty"`
+	CommitSHA  string    `json:"commit_sha,omitempty"`
+	TraceRef   string    `json:"trace_ref,omitempty"`
+	Rule       string    `json:"rule,omitempty"`
+	FrozenAt   time.Time `json:"frozen_at,omitempty"`
+}
+
+type WebhookDelivery struct {
+	DeliveryID string    `json:"delivery_id,omitempty"`
+	Event      string    `json:"event,omitempty"`
+	Repository string    `json:"repository,omitempty"`
+	ReceivedAt time.Time `json:"received_at,omitempty"`
+	Outcome    string    `json:"outcome,omitempty"`
+}
+
+type AuditEntry struct {
+	ID string `json:"id,omitempty"`
+	// Name of the token or session, or `github:<pusher>` for webhooks
+	Actor string `json:"actor,omitempty"`
+	// Method and route of the request, or `grade`
+	Action string `json:"action,omitempty"`
+	Target string `json:"target,omitempty"`
+	// Target before the change, missing for creations
+	Before json.RawMessage `json:"before,omitempty"`
+	// Target after the change, missing for deletions
+	After     json.RawMessage `json:"after,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	CreatedAt time.Time       `json:"created_at,omitempty"`
+}
+
+type Token struct {
+	ID         string     `json:"id,omitempty"`
+	Name       string     `json:"name,omitempty"`
+	Scope      string     `json:"scope,omitempty"`
+	IntraLogin string     `json:"intra_login,omitempty"`
+	CreatedAt  time.Time  `json:"created_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type IssuedToken struct {
+	Token
+	// Plain text token
+	Secret string `json:"token,omitempty"`
+}
+
+type TokenRequest struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+	// Required for student tokens
+	IntraLogin string `json:"intra_login,omitempty"`
+}
+
+type Registration struct {
+	IntraLogin   string     `json:"intra_login,omitempty"`
+	GithubLogin  string     `json:"github_login,omitempty"`
+	Status       string     `json:"status,omitempty"`
+	Verification string     `json:"verification,omitempty"`
+	RemoteAddr   string     `json:"remote_addr,omitempty"`
+	CreatedAt    time.Time  `json:"created_at,omitempty"`
+	RegisteredAt *time.Time `json:"registered_at,omitempty"`
+}
+
+type RegistrationRequest struct {
+	GithubLogin string `json:"github_login"`
+}
+
+type RegistrationChallenge struct {
+	Challenge    string `json:"challenge,omitempty"`
+	Instructions string `json:"instructions,omitempty"`
+}
+
+type Session struct {
+	IntraLogin string    `json:"intra_login,omitempty"`
+	Scope      string    `json:"scope,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+}
+
+type Me struct {
+	IntraLogin  string       `json:"intra_login,omitempty"`
+	Scope       string       `json:"scope,omitempty"`
+	Participant *Participant `json:"participant,omitempty"`
+	Modules     []Module     `json:"modules,omitempty"`
+}
+
+type ParticipantStatusRequest struct {
+	Status string `json:"status"`
+	// Removes the participant from the repos of all started modules
+	RevokeAccess bool `json:"revoke_access,omitempty"`
+}
+
+type ProvisionResponse struct {
+	Participant Participant `json:"participant,omitempty"`
+	Modules     []int       `json:"modules,omitempty"`
+}
+
+type ImportRow struct {
+	IntraLogin  string `json:"intra_login"`
+	GithubLogin string `json:"github_login"`
+}
+
+type ImportRowResult struct {
+	Row         int    `json:"row,omitempty"`
+	IntraLogin  string `json:"intra_login,omitempty"`
+	GithubLogin string `json:"github_login,omitempty"`
+	Action      string `json:"action,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun    bool              `json:"dry_run,omitempty"`
+	Applied   bool              `json:"applied,omitempty"`
+	Created   int               `json:"created,omitempty"`
+	Updated   int               `json:"updated,omitempty"`
+	Unchanged int               `json:"unchanged,omitempty"`
+	Invalid   int               `json:"invalid,omitempty"`
+	Rows      []ImportRowResult `json:"rows,omitempty"`
+}
+
+type RosterEntry struct {
+	IntraLogin         string `json:"intra_login,omitempty"`
+	GithubLogin        string `json:"github_login,omitempty"`
+	CurrentModuleID    int    `json:"current_module_id,omitempty"`
+	CurrentModuleScore int    `json:"current_module_score,omitempty"`
+	TotalScore         int    `json:"total_score,omitempty"`
+}
+
+type ModulePage struct {
+	Items []Module `json:"items"`
+	Total int      `json:"total"`
+	// Missing on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type ParticipantPage struct {
+	Items []Participant `json:"items"`
+	Total int           `json:"total"`
+	// Missing on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type TokenPage struct {
+	Items []Token `json:"items"`
+	Total int     `json:"total"`
+	// Missing on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type RegistrationPage struct {
+	Items []Registration `json:"items"`
+	Total int            `json:"total"`
+	// Missing on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type AuditPage struct {
+	Items []AuditEntry `json:"items"`
+	Total int          `json:"total"`
+	// Missing on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Lists who changed what, through the API or by grading
+func (c *Client) GetAuditLog(ctx context.Context, query url.Values) (*AuditPage, error) {
+	var result AuditPage
+	if _, err := c.do(ctx, http.MethodGet, "/shortinette/v1/audit", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Exports the final grades frozen when modules closed
+func (c *Client) ExportFinalGrades(ctx context.Context, query url.Values) ([]FinalGrade, error) {
+	var result []FinalGrade
+	_, err := c.do(ctx, http.MethodGet, "/shortinette/v1/grades", query, nil, &result)
+	return result, err
+}
+
+// Launches the Short
+func (c *Client) LaunchShort(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodPost, "/shortinette/v1/launch", nil, nil, nil)
+	return err
+}
+
+// Returns the caller's identity
+func (c *Client) GetMe(ctx context.Context) (*Me, error) {
+	var result Me
+	if _, err := c.do(ctx, http.MethodGet, "/shortinette/v1/me", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Lists modules
+func (c *Client) ListModules(ctx context.Context, query url.Values) (*ModulePage, error) {
+	var result ModulePage
+	if _, err := c.do(ctx, http.MethodGet, "/shortinette/v1/modules", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Creates a module
+func (c *Client) InsertModule(ctx context.Context, body Module) (*Module, error) {
+	var result Module
+	if _, err := c.do(ctx, http.MethodPost, "/shortinette/v1/modules", nil, body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Replaces a module
+func (c *Client) UpdateModule(ctx context.Context, body Module) (*Message, error) {
+	var result Message
+	if _, err := c.do(ctx, http.MethodPut, "/shortinette/v1/modules", nil, body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Returns a module
+func (c *Client) GetModule(ctx context.Context, id int, intraLogin string) (*Module, error) {
+	var result Module
+	if _, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/shortinette/v1/modules/%s/%s", pathParam(id), pathParam(intraLogin)), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Changes some fields of a module
+func (c *Client) PatchModule(ctx context.Context, id int, intraLogin string, body ModulePatch) (*Module, error) {
+	var result Module
+	if _, err := c.do(ctx, http.MethodPatch, fmt.Sprintf("/shortinette/v1/modules/%s/%s", pathParam(id), pathParam(intraLogin)), nil, body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Deletes a module
+func (c *Client) DeleteModule(ctx context.Context, id int, intraLogin string) (*Message, error) {
+	var result Message
+	if _, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/shortinette/v1/modules/%s/%s", pathParam(id), pathParam(intraLogin)), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Closes the participant's repo and freezes its final grade if the module is over
+func (c *Client) CloseModule(ctx context.Context, id int, intraLogin string) (*Module, error) {
+	var result Module
+	if _, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/shortinette/v1/modules/%s/%s/close", pathParam(id), pathParam(intraLogin)), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Grades a module
+func (c *Client) GradeModule(ctx context.Context, id int, intraLogin string) (string, error) {
+	var result string
+	_, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/shortinette/v1/modules/%s/%s/grademe", pathParam(id), pathParam(intraLogin)), nil, nil, &result)
+	return result, err
+}
+
+// Gives the participant write access to their closed repo back
+func (c *Client) ReopenModule(ctx context.Context, id int, intraLogin string) (*Module, error) {
+	var result Module
+	if _, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/shortinette/v1/modules/%s/%s/reopen", pathParam(id), pathParam(intraLogin)), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Undoes the deletion of a module
+func (c *Client) RestoreModule(ctx context.Context, id int, intraLogin string) (*Module, error) {
+	var result Module
+	if _, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/shortinette/v1/modules/%s/%s/restore", pathParam(id), pathParam(intraLogin)), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Lists participants
+func (c *Client) ListParticipants(ctx context.Context, query url.Values) (*ParticipantPage, error) {
+	var result ParticipantPage
+	if _, err := c.do(ctx, http.MethodGet, "/shortinette/v1/participants", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Creates a participant
+func (c *Client) InsertParticipant(ctx context.Context, body Participant) (*Participant, error) {
+	var result Participant
+	if _, err := c.do(ctx, http.MethodPost, "/shortinette/v1/participants", nil, body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Replaces a participant
+func (c *Client) UpdateParticipant(ctx context.Context, body Participant) (*Message, error) {
+	var result Message
+	if _, err := c.do(ctx, http.MethodPut, "/shortinette/v1/participants", nil, body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Exports all participants with their scores
+func (c *Client) ExportParticipants(ctx context.Context, query url.Values) ([]RosterEntry, error) {
+	var result []RosterEntry
+	_, err := c.do(ctx, http.MethodGet, "/shortinette/v1/participants/export", query, nil, &result)
+	return result, err
+}
+
+// Imports participants in bulk
+func (c *Client) ImportParticipants(ctx context.Context, query url.Values, body []ImportRow) (*ImportReport, error) {
+	var result ImportReport
+	if _, err := c.do(ctx, http.MethodPost, "/shortinette/v1/participants/import", query, body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Returns a participant
+func (c *Client) GetParticipant(ctx context.Context, intraLogin string) (*Participant, error) {
+	var result Participant
+	if _, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/shortinette/v1/participants/%s", pathParam(intraLogin)), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Changes some fields of a participant
+func (c *Client) PatchParticipant(ctx context.Context, intraLogin string, body ParticipantPatch) (*Participant, error) {
+	var result Participant
+	if _, err := c.do(ctx, http.MethodPatch, fmt.Sprintf("/shortinette/v1/participants/%s", pathParam(intraLogin)), nil, body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Deletes a participant along with their modules
+func (c *Client) DeleteParticipant(ctx context.Context, intraLogin string) (*Message, error) {
+	var result Message
+	if _, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/shortinette/v1/participants/%s", pathParam(intraLogin)), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Returns the grading attempts of a participant, oldest first
+func (c *Client) GetParticipantAttempts(ctx context.Context, intraLogin string) ([]Attempt, error) {
+	var result []Attempt
+	_, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/shortinette/v1/participants/%s/attempts", pathParam(intraLogin)), nil, nil, &result)
+	return result, err
+}
+
+// Returns the modules of a participant
+func (c *Client) GetParticipantModules(ctx context.Context, intraLogin string) ([]Module, error) {
+	var result []Module
+	_, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/shortinette/v1/participants/%s/modules", pathParam(intraLogin)), nil, nil, &result)
+	return result, err
+}
+
+// Provisions the open modules for a participant who joined late
+func (c *Client) ProvisionParticipant(ctx context.Context, intraLogin string) (*ProvisionResponse, error) {
+	var result ProvisionResponse
+	if _, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/shortinette/v1/participants/%s/provision", pathParam(intraLogin)), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Undoes the deletion of a participant along with the modules deleted with them
+func (c *Client) RestoreParticipant(ctx context.Context, intraLogin string) (*Participant, error) {
+	var result Participant
+	if _, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/shortinette/v1/participants/%s/restore", pathParam(intraLogin)), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Sets the status of a participant
+func (c *Client) UpdateParticipantStatus(ctx context.Context, intraLogin string, body ParticipantStatusRequest) (*Participant, error) {
+	var result Participant
+	if _, err := c.do(ctx, http.MethodPut, fmt.Sprintf("/shortinette/v1/participants/%s/status", pathParam(intraLogin)), nil, body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Lists self-service registrations
+func (c *Client) ListRegistrations(ctx context.Context, query url.Values) (*RegistrationPage, error) {
+	var result RegistrationPage
+	if _, err := c.do(ctx, http.MethodGet, "/shortinette/v1/registration", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Registers the caller as participant
+func (c *Client) Register(ctx context.Context, body RegistrationRequest) (status int, result json.RawMessage, err error) {
+	status, err = c.do(ctx, http.MethodPost, "/shortinette/v1/registration", nil, body, &result)
+	return status, result, err
+}
+
+// Completes a registration once the challenge gist exists
+func (c *Client) VerifyRegistration(ctx context.Context) (*Participant, error) {
+	var result Participant
+	if _, err := c.do(ctx, http.MethodPost, "/shortinette/v1/registration/verify", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Lists issued tokens
+func (c *Client) ListTokens(ctx context.Context, query url.Values) (*TokenPage, error) {
+	var result TokenPage
+	if _, err := c.do(ctx, http.MethodGet, "/shortinette/v1/tokens", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Issues a token
+func (c *Client) IssueToken(ctx context.Context, body TokenRequest) (*IssuedToken, error) {
+	var result IssuedToken
+	if _, err := c.do(ctx, http.MethodPost, "/shortinette/v1/tokens", nil, body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Revokes a token
+func (c *Client) RevokeToken(ctx context.Context, id string) (*Token, error) {
+	var result Token
+	if _, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/shortinette/v1/tokens/%s", pathParam(id)), nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Lists the most recent webhook deliveries
+func (c *Client) GetRecentDeliveries(ctx context.Context, query url.Values) ([]WebhookDelivery, error) {
+	var result []WebhookDelivery
+	_, err := c.do(ctx, http.MethodGet, "/shortinette/v1/webhook/deliveries", query, nil, &result)
+	return result, err
+}
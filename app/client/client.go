@@ -0,0 +1,115 @@
+// `client` is a Go client for the REST API of shortinette. The operations and types in
+// client.gen.go are generated from api/openapi.yaml, run `go generate ./client` after
+// changing it.
+package client
+
+//go:generate go run ./gen ../api/openapi.yaml client.gen.go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	BaseURL    string // e.g. http://localhost:8080
+	Token      string // Bearer token, either the API token or an issued one
+	HTTPClient *http.Client
+}
+
+// Error returned by the API.
+type Error struct {
+	StatusCode int
+	Title      string
+	Message    string
+	RequestID  string       // To look the request up in the server logs
+	Fields     []FieldError // Set if the request was refused because of invalid fields
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Title, e.Message)
+}
+
+func New(baseURL string, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Sends a request with `body` encoded as JSON, and decodes the response into `result`
+// unless it is nil. Responses with a status of 300 or more are returned as *Error.
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body any, result any) (status int, err error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, fmt.Errorf("could not encode request body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json, application/problem+json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return resp.StatusCode, decodeError(resp)
+	}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return resp.StatusCode, fmt.Errorf("could not decode response of %s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func decodeError(resp *http.Response) error {
+	raw, _ := io.ReadAll(resp.Body)
+
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		Title:      http.StatusText(resp.StatusCode),
+		Message:    strings.TrimSpace(string(raw)),
+		RequestID:  resp.Header.Get("X-Request-ID"),
+	}
+
+	// Errors which do not come from shortinette itself, e.g. from a proxy, are kept as they are
+	var problem Problem
+	if json.Unmarshal(raw, &problem) == nil && problem.Detail != "" {
+		apiErr.Title, apiErr.Message, apiErr.Fields = problem.Title, problem.Detail, problem.Errors
+		if problem.RequestID != "" {
+			apiErr.RequestID = problem.RequestID
+		}
+	}
+	return apiErr
+}
+
+func pathParam(value any) string {
+	return url.PathEscape(fmt.Sprint(value))
+}
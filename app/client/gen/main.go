@@ -0,0 +1,424 @@
+// Generates the types and operations of the client package from the OpenAPI document.
+//
+//	go run ./gen <openapi.yaml> <output.go>
+//
+// Only the subset of OpenAPI used by api/openapi.yaml is supported. Operations outside of
+// /shortinette/v1 (the Intra login, GitHub's webhook, the document itself) are not meant
+// for API clients and are skipped.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const clientPrefix = "/shortinette/v1/"
+
+type document struct {
+	Paths      map[string]*pathItem `yaml:"paths"`
+	Components struct {
+		Parameters map[string]*parameter `yaml:"parameters"`
+		Schemas    orderedSchemas        `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+type pathItem struct {
+	Parameters []*parameter `yaml:"parameters"`
+	Get        *operation   `yaml:"get"`
+	Post       *operation   `yaml:"post"`
+	Put        *operation   `yaml:"put"`
+	Patch      *operation   `yaml:"patch"`
+	Delete     *operation   `yaml:"delete"`
+}
+
+type operation struct {
+	OperationID string               `yaml:"operationId"`
+	Summary     string               `yaml:"summary"`
+	Parameters  []*parameter         `yaml:"parameters"`
+	RequestBody *body                `yaml:"requestBody"`
+	Responses   map[string]*response `yaml:"responses"`
+}
+
+type parameter struct {
+	Ref    string  `yaml:"$ref"`
+	Name   string  `yaml:"name"`
+	In     string  `yaml:"in"`
+	Schema *schema `yaml:"schema"`
+}
+
+type body struct {
+	Content map[string]*mediaType `yaml:"content"`
+}
+
+type response struct {
+	Ref     string                `yaml:"$ref"`
+	Content map[string]*mediaType `yaml:"content"`
+}
+
+type mediaType struct {
+	Schema *schema `yaml:"schema"`
+}
+
+type schema struct {
+	Ref         string         `yaml:"$ref"`
+	Type        string         `yaml:"type"`
+	Format      string         `yaml:"format"`
+	Description string         `yaml:"description"`
+	GoName      string         `yaml:"x-go-name"`  // Overrides the name of the field
+	GoPatch     bool           `yaml:"x-go-patch"` // Optional fields are pointers, so that zero values are sent
+	Nullable    bool           `yaml:"nullable"`
+	Required    []string       `yaml:"required"`
+	Items       *schema        `yaml:"items"`
+	AllOf       []*schema      `yaml:"allOf"`
+	Properties  orderedSchemas `yaml:"properties"`
+}
+
+type namedSchema struct {
+	name   string
+	schema *schema
+}
+
+// Properties are generated in the order of the document.
+type orderedSchemas []namedSchema
+
+func (schemas *orderedSchemas) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		var s schema
+		if err := node.Content[i+1].Decode(&s); err != nil {
+			return err
+		}
+		*schemas = append(*schemas, namedSchema{name: node.Content[i].Value, schema: &s})
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		log.Fatalf("usage: %s <openapi.yaml> <output.go>", os.Args[0])
+	}
+
+	spec, err := os.ReadFile(os.Args[1])
+	if err != nil {
+		log.Fatalf("could not read OpenAPI document: %v", err)
+	}
+	source, err := generate(spec)
+	if err != nil {
+		log.Fatalf("could not generate client: %v", err)
+	}
+	if err := os.WriteFile(os.Args[2], source, 0644); err != nil {
+		log.Fatalf("could not write client: %v", err)
+	}
+}
+
+func generate(spec []byte) ([]byte, error) {
+	var doc document
+	if err := yaml.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %v", err)
+	}
+
+	var out bytes.Buffer
+	for _, named := range doc.Components.Schemas {
+		if err := writeType(&out, named.name, named.schema); err != nil {
+			return nil, err
+		}
+	}
+
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		if strings.HasPrefix(path, clientPrefix) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		item := doc.Paths[path]
+		for _, method := range []struct {
+			name string
+			op   *operation
+		}{{"MethodGet", item.Get}, {"MethodPost", item.Post}, {"MethodPut", item.Put}, {"MethodPatch", item.Patch}, {"MethodDelete", item.Delete}} {
+			if method.op == nil {
+				continue
+			}
+			if err := writeOperation(&out, &doc, path, method.name, item.Parameters, method.op); err != nil {
+				return nil, fmt.Errorf("%s %s: %v", method.name, path, err)
+			}
+		}
+	}
+
+	var header bytes.Buffer
+	header.WriteString("// Code generated by client/gen from api/openapi.yaml. DO NOT EDIT.\n\npackage client\n\nimport (\n")
+	for _, pkg := range []string{"context", "encoding/json", "fmt", "net/http", "net/url", "time"} {
+		if bytes.Contains(out.Bytes(), []byte(pkg[strings.LastIndex(pkg, "/")+1:]+".")) {
+			fmt.Fprintf(&header, "%q\n", pkg)
+		}
+	}
+	header.WriteString(")\n\n")
+
+	source, err := format.Source(append(header.Bytes(), out.Bytes()...))
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid Go code: %v", err)
+	}
+	return source, nil
+}
+
+// Writes `text` as Go comment, one line per line of `text`.
+func writeComment(out *bytes.Buffer, text string) {
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		fmt.Fprintf(out, "// %s\n", line)
+	}
+}
+
+func writeType(out *bytes.Buffer, name string, s *schema) error {
+	writeComment(out, s.Description)
+	fmt.Fprintf(out, "type %s struct {\n", name)
+
+	parts := []*schema{s}
+	if len(s.AllOf) > 0 {
+		parts = s.AllOf
+	}
+	for _, part := range parts {
+		if part.Ref != "" {
+			fmt.Fprintf(out, "%s\n", refName(part.Ref))
+			continue
+		}
+		for _, property := range part.Properties {
+			goType, err := typeOf(property.schema)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %v", name, property.name, err)
+			}
+			tag := property.name
+			if !slices.Contains(part.Required, property.name) {
+				tag += ",omitempty"
+				if part.GoPatch && !strings.HasPrefix(goType, "*") && !strings.HasPrefix(goType, "[]") {
+					goType = "*" + goType
+				}
+			}
+			writeComment(out, property.schema.Description)
+			fieldName := property.schema.GoName
+			if fieldName == "" {
+				fieldName = goName(property.name)
+			}
+			fmt.Fprintf(out, "%s %s `json:%q`\n", fieldName, goType, tag)
+		}
+	}
+
+	out.WriteString("}\n\n")
+	return nil
+}
+
+func typeOf(s *schema) (string, error) {
+	if s.Ref != "" {
+		return refName(s.Ref), nil
+	}
+	if len(s.AllOf) == 1 && s.AllOf[0].Ref != "" {
+		if s.Nullable {
+			return "*" + refName(s.AllOf[0].Ref), nil
+		}
+		return refName(s.AllOf[0].Ref), nil
+	}
+
+	switch s.Type {
+	case "string":
+		if s.Format == "date-time" {
+			if s.Nullable {
+				return "*time.Time", nil
+			}
+			return "time.Time", nil
+		}
+		return "string", nil
+	case "integer":
+		if s.Format == "int64" {
+			return "int64", nil
+		}
+		return "int", nil
+	case "boolean":
+		return "bool", nil
+	case "array":
+		if s.Items == nil {
+			return "", fmt.Errorf("array without items")
+		}
+		item, err := typeOf(s.Items)
+		return "[]" + item, err
+	case "object":
+		return "json.RawMessage", nil
+	}
+	return "", fmt.Errorf("unsupported schema type '%s'", s.Type)
+}
+
+func writeOperation(out *bytes.Buffer, doc *document, path string, method string, shared []*parameter, op *operation) error {
+	if op.OperationID == "" {
+		return fmt.Errorf("missing operationId")
+	}
+
+	params := []string{"ctx context.Context"}
+	pathFormat := path
+	var pathArgs []string
+	hasQuery := false
+	for _, param := range append(slices.Clone(shared), op.Parameters...) {
+		if param.Ref != "" {
+			resolved, ok := doc.Components.Parameters[strings.TrimPrefix(param.Ref, "#/components/parameters/")]
+			if !ok {
+				return fmt.Errorf("unknown parameter %s", param.Ref)
+			}
+			param = resolved
+		}
+
+		switch param.In {
+		case "path":
+			goType, err := typeOf(param.Schema)
+			if err != nil {
+				return err
+			}
+			arg := lowerFirst(goName(param.Name))
+			params = append(params, fmt.Sprintf("%s %s", arg, goType))
+			pathFormat = strings.Replace(pathFormat, "{"+param.Name+"}", "%s", 1)
+			pathArgs = append(pathArgs, fmt.Sprintf("pathParam(%s)", arg))
+		case "query":
+			hasQuery = true
+		}
+	}
+	if hasQuery {
+		params = append(params, "query url.Values")
+	}
+
+	bodyArg := "nil"
+	if op.RequestBody != nil {
+		if media, ok := op.RequestBody.Content["application/json"]; ok {
+			goType, err := typeOf(media.Schema)
+			if err != nil {
+				return err
+			}
+			params = append(params, "body "+goType)
+			bodyArg = "body"
+		}
+	}
+	queryArg := "nil"
+	if hasQuery {
+		queryArg = "query"
+	}
+
+	pathExpr := fmt.Sprintf("%q", pathFormat)
+	if len(pathArgs) > 0 {
+		pathExpr = fmt.Sprintf("fmt.Sprintf(%q, %s)", pathFormat, strings.Join(pathArgs, ", "))
+	}
+
+	results, err := successSchemas(op)
+	if err != nil {
+		return err
+	}
+
+	name := goName(op.OperationID)
+	fmt.Fprintf(out, "// %s\n", op.Summary)
+	switch {
+	case len(results) == 0:
+		fmt.Fprintf(out, "func (c *Client) %s(%s) error {\n", name, strings.Join(params, ", "))
+		fmt.Fprintf(out, "_, err := c.do(ctx, http.%s, %s, %s, %s, nil)\nreturn err\n}\n\n", method, pathExpr, queryArg, bodyArg)
+	case len(results) == 1:
+		goType, err := typeOf(results[0])
+		if err != nil {
+			return err
+		}
+		if results[0].Ref == "" {
+			fmt.Fprintf(out, "func (c *Client) %s(%s) (%s, error) {\n", name, strings.Join(params, ", "), goType)
+			fmt.Fprintf(out, "var result %s\n", goType)
+			fmt.Fprintf(out, "_, err := c.do(ctx, http.%s, %s, %s, %s, &result)\nreturn result, err\n}\n\n", method, pathExpr, queryArg, bodyArg)
+			break
+		}
+		fmt.Fprintf(out, "func (c *Client) %s(%s) (*%s, error) {\n", name, strings.Join(params, ", "), goType)
+		fmt.Fprintf(out, "var result %s\n", goType)
+		fmt.Fprintf(out, "if _, err := c.do(ctx, http.%s, %s, %s, %s, &result); err != nil {\nreturn nil, err\n}\nreturn &result, nil\n}\n\n", method, pathExpr, queryArg, bodyArg)
+	default:
+		// The caller decodes the body depending on the status
+		fmt.Fprintf(out, "func (c *Client) %s(%s) (status int, result json.RawMessage, err error) {\n", name, strings.Join(params, ", "))
+		fmt.Fprintf(out, "status, err = c.do(ctx, http.%s, %s, %s, %s, &result)\nreturn status, result, err\n}\n\n", method, pathExpr, queryArg, bodyArg)
+	}
+	return nil
+}
+
+// Returns the distinct JSON schemas of the successful responses of `op`, by status.
+func successSchemas(op *operation) ([]*schema, error) {
+	statuses := make([]string, 0, len(op.Responses))
+	for status := range op.Responses {
+		if status < "300" {
+			statuses = append(statuses, status)
+		}
+	}
+	sort.Strings(statuses)
+
+	var schemas []*schema
+	seen := map[string]bool{}
+	for _, status := range statuses {
+		media, ok := op.Responses[status].Content["application/json"]
+		if !ok || media.Schema == nil {
+			continue
+		}
+		goType, err := typeOf(media.Schema)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[goType] {
+			seen[goType] = true
+			schemas = append(schemas, media.Schema)
+		}
+	}
+	return schemas, nil
+}
+
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+var initialisms = map[string]string{"id": "ID", "api": "API", "sha": "SHA", "url": "URL", "json": "JSON", "openapi": "OpenAPI"}
+
+// Converts snake_case and camelCase names to exported Go names.
+func goName(name string) string {
+	var words []string
+	start := 0
+	for i, r := range name {
+		if r == '_' || r == '-' {
+			words = append(words, name[start:i])
+			start = i + 1
+		} else if r >= 'A' && r <= 'Z' && i > start {
+			words = append(words, name[start:i])
+			start = i
+		}
+	}
+	words = append(words, name[start:])
+
+	var result strings.Builder
+	for _, word := range words {
+		if word == "" {
+			continue
+		}
+		if initialism, ok := initialisms[strings.ToLower(word)]; ok {
+			result.WriteString(initialism)
+			continue
+		}
+		result.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return result.String()
+}
+
+func lowerFirst(name string) string {
+	for initialism := range initialisms {
+		if name == initialisms[initialism] {
+			return initialism
+		}
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestGeneratedClientUpToDate(t *testing.T) {
+	spec, err := os.ReadFile("../../api/openapi.yaml")
+	if err != nil {
+		t.Fatalf("could not read OpenAPI document: %v", err)
+	}
+	expected, err := generate(spec)
+	if err != nil {
+		t.Fatalf("could not generate client: %v", err)
+	}
+
+	actual, err := os.ReadFile("../client.gen.go")
+	if err != nil {
+		t.Fatalf("could not read generated client: %v", err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("client.gen.go is out of date, run `go generate ./client`")
+	}
+}
+
+func TestGoName(t *testing.T) {
+	tests := map[string]string{
+		"intra_login":    "IntraLogin",
+		"id":             "ID",
+		"commit_sha":     "CommitSHA",
+		"rotateApiToken": "RotateAPIToken",
+		"X-GitHub-Event": "XGitHubEvent",
+	}
+	for name, expected := range tests {
+		if actual := goName(name); actual != expected {
+			t.Fatalf("goName(%q): expected %q, got %q", name, expected, actual)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,11 +26,102 @@ type Config struct {
 	TemplateRepo string
 	TokenGithub  string
 	OrgaGithub   string
-	ServerAddr   string
-	ApiToken     string
-	BasePath     string
+
+	// GitHub App credentials, used instead of TokenGithub when set.
+	GithubAppID             int64
+	GithubAppInstallationID int64
+	GithubAppPrivateKeyPath string
+
+	ServerAddr string
+	BasePath   string
+
+	// Bearer token of the REST API and HMAC secret shared with GitHub. They must differ,
+	// and are rotated by restarting with the previous values set (see fetchSecrets).
+	ApiToken          *Secret
+	WebhookSecret     *Secret
+	SecretGracePeriod time.Duration
+
+	// Public URL GitHub delivers push events to, and whether the webhook is registered
+	// once for the whole organisation or on every participant repository.
+	WebhookURL   string
+	WebhookScope string
+
+	// Push deliveries older than this are rejected as stale.
+	WebhookMaxAge time.Duration
+
+	// OAuth2 application on the 42 Intra, used to log students and staff in.
+	// Intra login is disabled unless IntraClientID is set.
+	IntraURL          string
+	IntraClientID     string
+	IntraClientSecret string
+	IntraRedirectURL  string
+
+	// Intra users with one of these roles (group names, or 'staff' for the staff flag) get
+	// admin access, everyone else gets student access.
+	IntraStaffRoles []string
+
+	// If set, only users of this campus may log in.
+	IntraCampusID int
+
+	SessionDuration time.Duration
+
+	// Window during which students may register themselves. Registration is closed if unset.
+	RegistrationOpens  time.Time
+	RegistrationCloses time.Time
+
+	// Whether students must prove they own their GitHub account by creating a gist.
+	RegistrationVerifyGist bool
+
+	// What happens to the repos of a module once it is over, and how long after its end.
+	ModuleCloseAction string
+	ModuleCloseGrace  time.Duration
+
+	// Which attempt the final grade of a module is based on when the module closes.
+	FinalGradeRule string
+
+	// Path of the SQLite database, or URL of a PostgreSQL one.
+	DatabaseDSN string
+
+	// Where and how often the SQLite database is backed up, 0 disables backups. The newest
+	// backup of each of the last BackupKeepHourly hours and BackupKeepDaily days is kept.
+	BackupDir        string
+	BackupInterval   time.Duration
+	BackupKeepHourly int
+	BackupKeepDaily  int
+
+	// How long deleted participants and modules are kept after the Short, so that deletions
+	// can be undone, before they are purged.
+	DeletedRetention time.Duration
 }
 
+const (
+	WebhookScopeOrganisation = "organisation"
+	WebhookScopeRepository   = "repository"
+
+	webhookPath = "/shortinette/webhook/grademe"
+
+	defaultWebhookMaxAge = 10 * time.Minute
+
+	defaultSecretGracePeriod = 24 * time.Hour
+
+	ModuleCloseReadOnly = "read-only" // Participants are downgraded to read access
+	ModuleCloseArchive  = "archive"   // Repos are archived
+
+	FinalGradeBest = "best" // Best attempt before the module closed
+	FinalGradeLast = "last" // Last attempt before the module closed
+
+	defaultIntraURL        = "https://api.intra.42.fr"
+	intraCallbackPath      = "/shortinette/auth/callback"
+	defaultSessionDuration = 12 * time.Hour
+
+	defaultDatabaseDSN      = "./data/shortinette.db"
+	defaultBackupDir        = "./data/backups"
+	defaultBackupInterval   = time.Hour
+	defaultBackupKeepHourly = 24
+	defaultBackupKeepDaily  = 7
+	defaultDeletedRetention = 7 * 24 * time.Hour
+)
+
 // Group of exercises
 type Module struct {
 	ID           int
@@ -88,12 +180,12 @@ func NewModule(exercises []Exercise, minimumScore int) (mod *Module, err error)
 		return nil, fmt.Errorf("you need at least one exercise to initialize a module")
 	}
 
-	totalScore := 0
-	for _, ex := range exercises {
-		totalScore += ex.Score
+	mod = &Module{
+		Exercises:    exercises,
+		MinimumScore: minimumScore,
 	}
 
-	if totalScore < minimumScore {
+	if totalScore := mod.MaxScore(); totalScore < minimumScore {
 		return nil, fmt.Errorf("the total score of all exercises (%d) adds up to less than expected minimum score (%d)", totalScore, minimumScore)
 	}
 
@@ -101,10 +193,16 @@ func NewModule(exercises []Exercise, minimumScore int) (mod *Module, err error)
 		return nil, fmt.Errorf("minimumScore cannot be negative")
 	}
 
-	return &Module{
-		Exercises:    exercises,
-		MinimumScore: minimumScore,
-	}, nil
+	return mod, nil
+}
+
+// Score of a module whose exercises all passed.
+func (mod Module) MaxScore() int {
+	total := 0
+	for _, ex := range mod.Exercises {
+		total += ex.Score
+	}
+	return total
 }
 
 // Initializes a new Exercise (data structure for single exercises).
@@ -145,13 +243,14 @@ func (config *Config) FetchEnvVariables() error {
 	if err1 != nil && err2 != nil {
 		logger.Warning.Println(".env file not found, this is expected in the GitHub Actions environment, this is a problem if you are running this locally")
 	}
+	var apiToken, webhookSecret string
 	requiredEnvVars := map[string]*string{
-		"TEMPLATE_REPO": &config.TemplateRepo,
-		"TOKEN_GITHUB":  &config.TokenGithub,
-		"ORGA_GITHUB":   &config.OrgaGithub,
-		"API_TOKEN":     &config.ApiToken,
-		"SERVER_ADDR":   &config.ServerAddr,
-		"BASE_PATH":     &config.BasePath,
+		"TEMPLATE_REPO":  &config.TemplateRepo,
+		"ORGA_GITHUB":    &config.OrgaGithub,
+		"API_TOKEN":      &apiToken,
+		"WEBHOOK_SECRET": &webhookSecret,
+		"SERVER_ADDR":    &config.ServerAddr,
+		"BASE_PATH":      &config.BasePath,
 	}
 
 	missingEnvVars := make([]string, 0, len(requiredEnvVars))
@@ -166,5 +265,293 @@ func (config *Config) FetchEnvVariables() error {
 		return fmt.Errorf("missing environment variables: %s", strings.Join(missingEnvVars, ", "))
 	}
 
+	if err := config.fetchSecrets(apiToken, webhookSecret); err != nil {
+		return err
+	}
+
+	if err := config.fetchWebhookSettings(); err != nil {
+		return err
+	}
+
+	if err := config.fetchIntraSettings(); err != nil {
+		return err
+	}
+
+	if err := config.fetchRegistrationSettings(); err != nil {
+		return err
+	}
+
+	if err := config.fetchModuleCloseSettings(); err != nil {
+		return err
+	}
+
+	if err := config.fetchDatabaseSettings(); err != nil {
+		return err
+	}
+
+	return config.fetchGithubCredentials()
+}
+
+// API_TOKEN_PREVIOUS and WEBHOOK_SECRET_PREVIOUS are accepted alongside the new values for
+// SECRET_GRACE_PERIOD (defaults to 24h) after startup, so that secrets can be rotated with a
+// simple restart.
+func (config *Config) fetchSecrets(apiToken string, webhookSecret string) error {
+	config.SecretGracePeriod = defaultSecretGracePeriod
+	if gracePeriod := os.Getenv("SECRET_GRACE_PERIOD"); gracePeriod != "" {
+		var err error
+		if config.SecretGracePeriod, err = time.ParseDuration(gracePeriod); err != nil || config.SecretGracePeriod < 0 {
+			return fmt.Errorf("invalid SECRET_GRACE_PERIOD '%s': expected a duration like '24h'", gracePeriod)
+		}
+	}
+
+	previousUntil := time.Now().Add(config.SecretGracePeriod)
+	config.ApiToken = NewSecret(apiToken, os.Getenv("API_TOKEN_PREVIOUS"), previousUntil)
+	config.WebhookSecret = NewSecret(webhookSecret, os.Getenv("WEBHOOK_SECRET_PREVIOUS"), previousUntil)
+
+	// Anyone able to sign webhooks would otherwise have full access to the API, and the other way around
+	if config.ApiToken.Overlaps(config.WebhookSecret) {
+		return fmt.Errorf("API_TOKEN and WEBHOOK_SECRET (including their previous values) must differ")
+	}
+
+	return nil
+}
+
+// WEBHOOK_URL defaults to SERVER_ADDR, which only works if the latter is a full URL
+// reachable by GitHub. WEBHOOK_SCOPE defaults to 'organisation', WEBHOOK_MAX_AGE to 10 minutes.
+func (config *Config) fetchWebhookSettings() error {
+	config.WebhookURL = os.Getenv("WEBHOOK_URL")
+	if config.WebhookURL == "" {
+		config.WebhookURL = strings.TrimSuffix(config.ServerAddr, "/") + webhookPath
+		if !strings.HasPrefix(config.WebhookURL, "http://") && !strings.HasPrefix(config.WebhookURL, "https://") {
+			config.WebhookURL = "http://" + config.WebhookURL
+		}
+	}
+
+	config.WebhookScope = os.Getenv("WEBHOOK_SCOPE")
+	switch config.WebhookScope {
+	case "":
+		config.WebhookScope = WebhookScopeOrganisation
+	case WebhookScopeOrganisation, WebhookScopeRepository:
+	default:
+		return fmt.Errorf("invalid WEBHOOK_SCOPE '%s': expected '%s' or '%s'", config.WebhookScope, WebhookScopeOrganisation, WebhookScopeRepository)
+	}
+
+	config.WebhookMaxAge = defaultWebhookMaxAge
+	if maxAge := os.Getenv("WEBHOOK_MAX_AGE"); maxAge != "" {
+		var err error
+		if config.WebhookMaxAge, err = time.ParseDuration(maxAge); err != nil || config.WebhookMaxAge <= 0 {
+			return fmt.Errorf("invalid WEBHOOK_MAX_AGE '%s': expected a positive duration like '10m'", maxAge)
+		}
+	}
+
+	return nil
+}
+
+// Returns true if students and staff can log in with their Intra account.
+func (config *Config) IntraEnabled() bool {
+	return config.IntraClientID != ""
+}
+
+// INTRA_CLIENT_ID and INTRA_CLIENT_SECRET enable Intra login. INTRA_REDIRECT_URL defaults to
+// the callback route on SERVER_ADDR, INTRA_STAFF_ROLES to 'staff', SESSION_DURATION to 12 hours.
+// INTRA_URL can point to a local stand-in of the Intra for testing.
+func (config *Config) fetchIntraSettings() error {
+	config.IntraClientID = os.Getenv("INTRA_CLIENT_ID")
+	config.IntraClientSecret = os.Getenv("INTRA_CLIENT_SECRET")
+	if !config.IntraEnabled() {
+		return nil
+	}
+	if config.IntraClientSecret == "" {
+		return fmt.Errorf("missing environment variables: INTRA_CLIENT_SECRET")
+	}
+
+	config.IntraURL = os.Getenv("INTRA_URL")
+	if config.IntraURL == "" {
+		config.IntraURL = defaultIntraURL
+	}
+
+	config.IntraRedirectURL = os.Getenv("INTRA_REDIRECT_URL")
+	if config.IntraRedirectURL == "" {
+		config.IntraRedirectURL = strings.TrimSuffix(config.ServerAddr, "/") + intraCallbackPath
+		if !strings.HasPrefix(config.IntraRedirectURL, "http://") && !strings.HasPrefix(config.IntraRedirectURL, "https://") {
+			config.IntraRedirectURL = "http://" + config.IntraRedirectURL
+		}
+	}
+
+	config.IntraStaffRoles = []string{"staff"}
+	if roles := os.Getenv("INTRA_STAFF_ROLES"); roles != "" {
+		config.IntraStaffRoles = nil
+		for _, role := range strings.Split(roles, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				config.IntraStaffRoles = append(config.IntraStaffRoles, role)
+			}
+		}
+	}
+
+	if campusID := os.Getenv("INTRA_CAMPUS_ID"); campusID != "" {
+		var err error
+		if config.IntraCampusID, err = strconv.Atoi(campusID); err != nil || config.IntraCampusID <= 0 {
+			return fmt.Errorf("invalid INTRA_CAMPUS_ID '%s': expected a positive integer", campusID)
+		}
+	}
+
+	config.SessionDuration = defaultSessionDuration
+	if duration := os.Getenv("SESSION_DURATION"); duration != "" {
+		var err error
+		if config.SessionDuration, err = time.ParseDuration(duration); err != nil || config.SessionDuration <= 0 {
+			return fmt.Errorf("invalid SESSION_DURATION '%s': expected a positive duration like '12h'", duration)
+		}
+	}
+
+	return nil
+}
+
+// Returns true if students may register themselves at `now`.
+func (config *Config) RegistrationOpen(now time.Time) bool {
+	return !config.RegistrationOpens.IsZero() && !now.Before(config.RegistrationOpens) && now.Before(config.RegistrationCloses)
+}
+
+// REGISTRATION_OPENS and REGISTRATION_CLOSES are RFC 3339 timestamps, and must be set together.
+// REGISTRATION_VERIFY_GIST=true requires students to prove they own their GitHub account.
+func (config *Config) fetchRegistrationSettings() error {
+	opens, closes := os.Getenv("REGISTRATION_OPENS"), os.Getenv("REGISTRATION_CLOSES")
+	if (opens == "") != (closes == "") {
+		return fmt.Errorf("REGISTRATION_OPENS and REGISTRATION_CLOSES must be set together")
+	}
+
+	if opens != "" {
+		var err error
+		if config.RegistrationOpens, err = time.Parse(time.RFC3339, opens); err != nil {
+			return fmt.Errorf("invalid REGISTRATION_OPENS '%s': %v", opens, err)
+		}
+		if config.RegistrationCloses, err = time.Parse(time.RFC3339, closes); err != nil {
+			return fmt.Errorf("invalid REGISTRATION_CLOSES '%s': %v", closes, err)
+		}
+		if !config.RegistrationCloses.After(config.RegistrationOpens) {
+			return fmt.Errorf("REGISTRATION_CLOSES must be after REGISTRATION_OPENS")
+		}
+	}
+
+	if verifyGist := os.Getenv("REGISTRATION_VERIFY_GIST"); verifyGist != "" {
+		var err error
+		if config.RegistrationVerifyGist, err = strconv.ParseBool(verifyGist); err != nil {
+			return fmt.Errorf("invalid REGISTRATION_VERIFY_GIST '%s': expected 'true' or 'false'", verifyGist)
+		}
+	}
+
+	return nil
+}
+
+// MODULE_CLOSE_ACTION defaults to 'read-only', MODULE_CLOSE_GRACE to 0, FINAL_GRADE_RULE to 'best'.
+func (config *Config) fetchModuleCloseSettings() error {
+	config.ModuleCloseAction = os.Getenv("MODULE_CLOSE_ACTION")
+	switch config.ModuleCloseAction {
+	case "":
+		config.ModuleCloseAction = ModuleCloseReadOnly
+	case ModuleCloseReadOnly, ModuleCloseArchive:
+	default:
+		return fmt.Errorf("invalid MODULE_CLOSE_ACTION '%s': expected '%s' or '%s'", config.ModuleCloseAction, ModuleCloseReadOnly, ModuleCloseArchive)
+	}
+
+	if grace := os.Getenv("MODULE_CLOSE_GRACE"); grace != "" {
+		var err error
+		if config.ModuleCloseGrace, err = time.ParseDuration(grace); err != nil || config.ModuleCloseGrace < 0 {
+			return fmt.Errorf("invalid MODULE_CLOSE_GRACE '%s': expected a duration like '15m'", grace)
+		}
+	}
+
+	config.FinalGradeRule = os.Getenv("FINAL_GRADE_RULE")
+	switch config.FinalGradeRule {
+	case "":
+		config.FinalGradeRule = FinalGradeBest
+	case FinalGradeBest, FinalGradeLast:
+	default:
+		return fmt.Errorf("invalid FINAL_GRADE_RULE '%s': expected '%s' or '%s'", config.FinalGradeRule, FinalGradeBest, FinalGradeLast)
+	}
+
+	return nil
+}
+
+// DATABASE_DSN defaults to ./data/shortinette.db, BACKUP_DIR to ./data/backups, BACKUP_INTERVAL
+// to 1h, BACKUP_KEEP_HOURLY to 24, BACKUP_KEEP_DAILY to 7 and DELETED_RETENTION to 168h.
+func (config *Config) fetchDatabaseSettings() error {
+	config.DatabaseDSN = os.Getenv("DATABASE_DSN")
+	if config.DatabaseDSN == "" {
+		config.DatabaseDSN = defaultDatabaseDSN
+	}
+
+	config.BackupDir = os.Getenv("BACKUP_DIR")
+	if config.BackupDir == "" {
+		config.BackupDir = defaultBackupDir
+	}
+
+	config.BackupInterval = defaultBackupInterval
+	if interval := os.Getenv("BACKUP_INTERVAL"); interval != "" {
+		var err error
+		if config.BackupInterval, err = time.ParseDuration(interval); err != nil || config.BackupInterval < 0 {
+			return fmt.Errorf("invalid BACKUP_INTERVAL '%s': expected a duration like '1h', or 0 to disable backups", interval)
+		}
+	}
+
+	config.DeletedRetention = defaultDeletedRetention
+	if retention := os.Getenv("DELETED_RETENTION"); retention != "" {
+		var err error
+		if config.DeletedRetention, err = time.ParseDuration(retention); err != nil || config.DeletedRetention < 0 {
+			return fmt.Errorf("invalid DELETED_RETENTION '%s': expected a duration like '168h'", retention)
+		}
+	}
+
+	keep := map[string]*int{
+		"BACKUP_KEEP_HOURLY": &config.BackupKeepHourly,
+		"BACKUP_KEEP_DAILY":  &config.BackupKeepDaily,
+	}
+	config.BackupKeepHourly, config.BackupKeepDaily = defaultBackupKeepHourly, defaultBackupKeepDaily
+	for key, value := range keep {
+		if env := os.Getenv(key); env != "" {
+			count, err := strconv.Atoi(env)
+			if err != nil || count < 0 {
+				return fmt.Errorf("invalid %s '%s': expected a number of backups", key, env)
+			}
+			*value = count
+		}
+	}
+
+	return nil
+}
+
+// Returns true if shortinette authenticates as a GitHub App installation rather than
+// with a personal access token.
+func (config *Config) UsesGithubApp() bool {
+	return config.GithubAppID != 0
+}
+
+// Either TOKEN_GITHUB, or all of GITHUB_APP_ID, GITHUB_APP_INSTALLATION_ID and
+// GITHUB_APP_PRIVATE_KEY_PATH must be set. The GitHub App takes precedence if both are.
+func (config *Config) fetchGithubCredentials() error {
+	config.TokenGithub = os.Getenv("TOKEN_GITHUB")
+
+	appID := os.Getenv("GITHUB_APP_ID")
+	if appID == "" {
+		if config.TokenGithub == "" {
+			return fmt.Errorf("missing environment variables: TOKEN_GITHUB (or GITHUB_APP_ID, GITHUB_APP_INSTALLATION_ID, GITHUB_APP_PRIVATE_KEY_PATH)")
+		}
+		return nil
+	}
+
+	var err error
+	if config.GithubAppID, err = strconv.ParseInt(appID, 10, 64); err != nil {
+		return fmt.Errorf("invalid GITHUB_APP_ID '%s': %v", appID, err)
+	}
+
+	installationID := os.Getenv("GITHUB_APP_INSTALLATION_ID")
+	if config.GithubAppInstallationID, err = strconv.ParseInt(installationID, 10, 64); err != nil {
+		return fmt.Errorf("invalid GITHUB_APP_INSTALLATION_ID '%s': %v", installationID, err)
+	}
+
+	config.GithubAppPrivateKeyPath = os.Getenv("GITHUB_APP_PRIVATE_KEY_PATH")
+	if config.GithubAppPrivateKeyPath == "" {
+		return fmt.Errorf("missing environment variables: GITHUB_APP_PRIVATE_KEY_PATH")
+	}
+
 	return nil
 }
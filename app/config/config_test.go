@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 )
 
 func TestNewExerciseEmptyTurnInDirectory(t *testing.T) {
@@ -71,3 +72,304 @@ func TestNewModuleNilExercises(t *testing.T) {
 		t.Fatalf("exercises cannot be nil")
 	}
 }
+
+func setRequiredEnvVariables(t *testing.T) {
+	t.Helper()
+
+	for key, value := range map[string]string{
+		"TEMPLATE_REPO":  "template",
+		"ORGA_GITHUB":    "orga",
+		"API_TOKEN":      "token",
+		"WEBHOOK_SECRET": "webhook secret",
+		"SERVER_ADDR":    ":8080",
+		"BASE_PATH":      "/app",
+	} {
+		t.Setenv(key, value)
+	}
+}
+
+func TestFetchEnvVariablesMissingGithubCredentials(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "")
+	t.Setenv("GITHUB_APP_ID", "")
+
+	if err := (&Config{}).FetchEnvVariables(); err == nil {
+		t.Fatalf("either a personal access token or a GitHub App should be required")
+	}
+}
+
+func TestFetchEnvVariablesPersonalAccessToken(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "pat")
+	t.Setenv("GITHUB_APP_ID", "")
+
+	config := &Config{}
+	if err := config.FetchEnvVariables(); err != nil {
+		t.Fatalf("a personal access token should be enough to authenticate: %v", err)
+	}
+	if config.UsesGithubApp() {
+		t.Fatalf("no GitHub App is configured")
+	}
+}
+
+func TestFetchEnvVariablesGithubApp(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "")
+	t.Setenv("GITHUB_APP_ID", "1234")
+	t.Setenv("GITHUB_APP_INSTALLATION_ID", "5678")
+	t.Setenv("GITHUB_APP_PRIVATE_KEY_PATH", "/secrets/app.pem")
+
+	config := &Config{}
+	if err := config.FetchEnvVariables(); err != nil {
+		t.Fatalf("a GitHub App should be enough to authenticate: %v", err)
+	}
+	if !config.UsesGithubApp() || config.GithubAppID != 1234 || config.GithubAppInstallationID != 5678 {
+		t.Fatalf("GitHub App credentials were not parsed correctly: %+v", config)
+	}
+}
+
+func TestFetchEnvVariablesGithubAppMissingInstallation(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("GITHUB_APP_ID", "1234")
+	t.Setenv("GITHUB_APP_INSTALLATION_ID", "")
+
+	if err := (&Config{}).FetchEnvVariables(); err == nil {
+		t.Fatalf("a GitHub App without installation ID should be rejected")
+	}
+}
+
+func TestFetchEnvVariablesWebhookURLDefault(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "pat")
+	t.Setenv("SERVER_ADDR", "shortinette.example.com:8080")
+	t.Setenv("WEBHOOK_URL", "")
+	t.Setenv("WEBHOOK_SCOPE", "")
+
+	config := &Config{}
+	if err := config.FetchEnvVariables(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.WebhookURL != "http://shortinette.example.com:8080/shortinette/webhook/grademe" {
+		t.Fatalf("WebhookURL should default to SERVER_ADDR, got '%s'", config.WebhookURL)
+	}
+	if config.WebhookScope != WebhookScopeOrganisation {
+		t.Fatalf("WebhookScope should default to '%s', got '%s'", WebhookScopeOrganisation, config.WebhookScope)
+	}
+}
+
+func TestFetchEnvVariablesInvalidWebhookScope(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "pat")
+	t.Setenv("WEBHOOK_SCOPE", "everywhere")
+
+	if err := (&Config{}).FetchEnvVariables(); err == nil {
+		t.Fatalf("unknown webhook scopes should be rejected")
+	}
+}
+
+func TestFetchEnvVariablesIntraSettings(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "pat")
+	t.Setenv("INTRA_CLIENT_ID", "uid")
+	t.Setenv("INTRA_CLIENT_SECRET", "secret")
+	t.Setenv("INTRA_REDIRECT_URL", "")
+	t.Setenv("INTRA_STAFF_ROLES", "bocal, staff")
+
+	config := &Config{}
+	if err := config.FetchEnvVariables(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !config.IntraEnabled() {
+		t.Fatalf("Intra login should be enabled when INTRA_CLIENT_ID is set")
+	}
+	if config.IntraRedirectURL != "http://:8080/shortinette/auth/callback" {
+		t.Fatalf("IntraRedirectURL should default to SERVER_ADDR, got '%s'", config.IntraRedirectURL)
+	}
+	if len(config.IntraStaffRoles) != 2 || config.IntraStaffRoles[0] != "bocal" || config.IntraStaffRoles[1] != "staff" {
+		t.Fatalf("INTRA_STAFF_ROLES was not parsed correctly: %v", config.IntraStaffRoles)
+	}
+}
+
+func TestFetchEnvVariablesIntraMissingClientSecret(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "pat")
+	t.Setenv("INTRA_CLIENT_ID", "uid")
+	t.Setenv("INTRA_CLIENT_SECRET", "")
+
+	if err := (&Config{}).FetchEnvVariables(); err == nil {
+		t.Fatalf("an Intra client ID without secret should be rejected")
+	}
+}
+
+func TestFetchEnvVariablesRegistrationWindow(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "pat")
+	t.Setenv("REGISTRATION_OPENS", "2024-10-01T00:00:00Z")
+	t.Setenv("REGISTRATION_CLOSES", "2024-10-15T00:00:00Z")
+
+	config := &Config{}
+	if err := config.FetchEnvVariables(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !config.RegistrationOpen(time.Date(2024, 10, 7, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("registration should be open within the window")
+	}
+	if config.RegistrationOpen(time.Date(2024, 10, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("registration should be closed once the window is over")
+	}
+}
+
+func TestFetchEnvVariablesRegistrationWindowReversed(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "pat")
+	t.Setenv("REGISTRATION_OPENS", "2024-10-15T00:00:00Z")
+	t.Setenv("REGISTRATION_CLOSES", "2024-10-01T00:00:00Z")
+
+	if err := (&Config{}).FetchEnvVariables(); err == nil {
+		t.Fatalf("a registration window closing before it opens should be rejected")
+	}
+}
+
+func TestRegistrationClosedByDefault(t *testing.T) {
+	if (&Config{}).RegistrationOpen(time.Now()) {
+		t.Fatalf("registration should be closed when no window is configured")
+	}
+}
+
+func TestFetchEnvVariablesModuleCloseSettings(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "pat")
+	t.Setenv("MODULE_CLOSE_ACTION", "")
+	t.Setenv("MODULE_CLOSE_GRACE", "15m")
+
+	config := &Config{}
+	if err := config.FetchEnvVariables(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.ModuleCloseAction != ModuleCloseReadOnly {
+		t.Fatalf("ModuleCloseAction should default to '%s', got '%s'", ModuleCloseReadOnly, config.ModuleCloseAction)
+	}
+	if config.ModuleCloseGrace != 15*time.Minute {
+		t.Fatalf("MODULE_CLOSE_GRACE was not parsed correctly: %s", config.ModuleCloseGrace)
+	}
+	if config.FinalGradeRule != FinalGradeBest {
+		t.Fatalf("FinalGradeRule should default to '%s', got '%s'", FinalGradeBest, config.FinalGradeRule)
+	}
+
+	t.Setenv("MODULE_CLOSE_ACTION", "delete")
+	if err := (&Config{}).FetchEnvVariables(); err == nil {
+		t.Fatalf("unknown module close actions should be rejected")
+	}
+}
+
+func TestFetchEnvVariablesSameApiTokenAndWebhookSecret(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "pat")
+	t.Setenv("WEBHOOK_SECRET", "token")
+
+	if err := (&Config{}).FetchEnvVariables(); err == nil {
+		t.Fatalf("the API token and the webhook secret must differ")
+	}
+}
+
+func TestFetchEnvVariablesPreviousApiTokenIsWebhookSecret(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "pat")
+	t.Setenv("API_TOKEN_PREVIOUS", "webhook secret")
+
+	if err := (&Config{}).FetchEnvVariables(); err == nil {
+		t.Fatalf("the previous API token must not be usable as webhook secret either")
+	}
+}
+
+func TestSecretAcceptsPreviousDuringGracePeriod(t *testing.T) {
+	secret := NewSecret("new", "old", time.Now().Add(time.Hour))
+	if !secret.Accepts("new") || !secret.Accepts("old") {
+		t.Fatalf("both the current and the previous value should be accepted during the grace period")
+	}
+	if secret.Accepts("") || secret.Accepts("other") {
+		t.Fatalf("only the current and the previous value should be accepted")
+	}
+}
+
+func TestSecretRejectsPreviousAfterGracePeriod(t *testing.T) {
+	secret := NewSecret("new", "old", time.Now().Add(-time.Second))
+	if secret.Accepts("old") {
+		t.Fatalf("the previous value should not be accepted after the grace period")
+	}
+}
+
+func TestFetchEnvVariablesDatabaseDSN(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "pat")
+	t.Setenv("DATABASE_DSN", "")
+
+	config := &Config{}
+	if err := config.FetchEnvVariables(); err != nil {
+		t.Fatalf("failed to fetch environment variables: %v", err)
+	}
+	if config.DatabaseDSN != defaultDatabaseDSN {
+		t.Fatalf("expected database at %s by default, got '%s'", defaultDatabaseDSN, config.DatabaseDSN)
+	}
+
+	t.Setenv("DATABASE_DSN", "postgres://short@localhost/short")
+	if err := config.FetchEnvVariables(); err != nil {
+		t.Fatalf("failed to fetch environment variables: %v", err)
+	}
+	if config.DatabaseDSN != "postgres://short@localhost/short" {
+		t.Fatalf("expected DATABASE_DSN to be used, got '%s'", config.DatabaseDSN)
+	}
+}
+
+func TestFetchEnvVariablesBackupSettings(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "pat")
+	t.Setenv("BACKUP_INTERVAL", "")
+	t.Setenv("BACKUP_KEEP_HOURLY", "")
+	t.Setenv("BACKUP_KEEP_DAILY", "3")
+
+	config := &Config{}
+	if err := config.FetchEnvVariables(); err != nil {
+		t.Fatalf("failed to fetch environment variables: %v", err)
+	}
+	if config.BackupInterval != defaultBackupInterval || config.BackupKeepHourly != defaultBackupKeepHourly {
+		t.Fatalf("expected default backup interval and hourly retention, got %s and %d", config.BackupInterval, config.BackupKeepHourly)
+	}
+	if config.BackupKeepDaily != 3 {
+		t.Fatalf("BACKUP_KEEP_DAILY was not parsed correctly: %d", config.BackupKeepDaily)
+	}
+
+	t.Setenv("BACKUP_INTERVAL", "0")
+	if err := config.FetchEnvVariables(); err != nil || config.BackupInterval != 0 {
+		t.Fatalf("expected BACKUP_INTERVAL=0 to disable backups, got %s (%v)", config.BackupInterval, err)
+	}
+
+	t.Setenv("BACKUP_KEEP_HOURLY", "-1")
+	if err := config.FetchEnvVariables(); err == nil {
+		t.Fatalf("expected an error for a negative BACKUP_KEEP_HOURLY")
+	}
+}
+
+func TestFetchEnvVariablesDeletedRetention(t *testing.T) {
+	setRequiredEnvVariables(t)
+	t.Setenv("TOKEN_GITHUB", "pat")
+	t.Setenv("DELETED_RETENTION", "")
+
+	config := &Config{}
+	if err := config.FetchEnvVariables(); err != nil {
+		t.Fatalf("failed to fetch environment variables: %v", err)
+	}
+	if config.DeletedRetention != defaultDeletedRetention {
+		t.Fatalf("expected default retention of deleted records, got %s", config.DeletedRetention)
+	}
+
+	t.Setenv("DELETED_RETENTION", "48h")
+	if err := config.FetchEnvVariables(); err != nil || config.DeletedRetention != 48*time.Hour {
+		t.Fatalf("DELETED_RETENTION was not parsed correctly: %s (%v)", config.DeletedRetention, err)
+	}
+
+	t.Setenv("DELETED_RETENTION", "a week")
+	if err := config.FetchEnvVariables(); err == nil {
+		t.Fatalf("expected an error for an invalid DELETED_RETENTION")
+	}
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"crypto/subtle"
+	"time"
+)
+
+// Shared secret which can be rotated without downtime: after a rotation, the previous
+// value keeps being accepted until the end of a grace window.
+type Secret struct {
+	current       string
+	previous      string
+	previousUntil time.Time
+}
+
+// Initializes a new Secret.
+//
+//   - current: value in use
+//   - previous: value which is being rotated out, can be empty
+//   - previousUntil: time until which `previous` is still accepted
+func NewSecret(current string, previous string, previousUntil time.Time) *Secret {
+	return &Secret{
+		current:       current,
+		previous:      previous,
+		previousUntil: previousUntil,
+	}
+}
+
+// Returns the value which should be used when signing or sending the secret.
+func (s *Secret) Current() string {
+	return s.current
+}
+
+// Returns all values currently accepted: the current one, and the previous one
+// while the grace window lasts.
+func (s *Secret) Accepted() []string {
+	if s.previous != "" && time.Now().Before(s.previousUntil) {
+		return []string{s.current, s.previous}
+	}
+	return []string{s.current}
+}
+
+// Checks in constant time whether `candidate` is one of the accepted values.
+func (s *Secret) Accepts(candidate string) bool {
+	accepted := false
+	for _, value := range s.Accepted() {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(value)) == 1 {
+			accepted = true
+		}
+	}
+	return accepted
+}
+
+// Returns true if any value accepted by `s` would also be accepted by `other`.
+func (s *Secret) Overlaps(other *Secret) bool {
+	for _, value := range s.Accepted() {
+		if other.Accepts(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,26 +2,65 @@ package dao
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/42-Short/shortinette/apperr"
 	"github.com/42-Short/shortinette/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 )
 
-//TODO: support transactions
+// SQLSTATE codes of PostgreSQL errors, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgNotNullViolation    = "23502"
+	pgForeignKeyViolation = "23503"
+	pgUniqueViolation     = "23505"
+	pgCheckViolation      = "23514"
+	pgRaiseException      = "P0001" // Raised by the triggers keeping final grades immutable
+)
 
 // Data Access Object for interacting with the DB
 type DAO[T any] struct {
 	DB *db.DB
 	md metadata
+	q  querier // The DB, or the transaction the DAO is bound to, see In
+	w  querier // Same as q, but for writes, see db.DB.Writer
 }
 
 type metadata struct {
 	dbTags      []string
 	primaryKeys []string
 	tableName   string
+	columns     map[string]column
+	softDelete  bool // Whether the table has a deletedAtColumn
+}
+
+// Records of tables with this column are only marked as deleted by Delete, and hidden from
+// reads until they are restored or purged.
+const deletedAtColumn = "deleted_at"
+
+type column struct {
+	kind   reflect.Type
+	hidden bool // Not serialized to JSON, e.g. token hashes
+}
+
+// Parameters of DAO.GetPage.
+type PageQuery struct {
+	Filters    map[string]any
+	SortBy     string // Column to sort by, the primary keys if empty
+	Descending bool
+	Limit      int
+	Offset     int
+	Deleted    bool // Only deleted records instead of only live ones
 }
 
 var metadataCache sync.Map
@@ -31,79 +70,305 @@ func NewDAO[T any](db *db.DB) *DAO[T] {
 	return &DAO[T]{
 		DB: db,
 		md: md,
+		q:  db.Conn,
+		w:  db.Writer,
 	}
 }
 
-// Adds a new record to the table
+// Adds a new record to the table, with its defaults filled in (see Defaulter)
 func (dao *DAO[T]) Insert(ctx context.Context, data T) error {
+	SetDefaults(&data)
 	query := buildInsertQuery(dao.md.tableName, dao.md.dbTags)
-	_, err := dao.DB.Conn.NamedExecContext(ctx, query, data)
+	_, err := dao.w.NamedExecContext(ctx, query, data)
 	if err != nil {
-		return fmt.Errorf("failed to insert data in table %s: %v", dao.md.tableName, err)
+		return dao.classify(err, "failed to insert data in table %s", dao.md.tableName)
 	}
 	return nil
 }
 
-// Modifies an existing record in the table using the DAO's primary keys.
+// Modifies an existing record in the table using the DAO's primary keys. Returns an
+// apperr.NotFound error if there is no such record. Deleted records cannot be modified, and
+// whether a record is deleted cannot be changed, see Delete and Restore.
 func (dao *DAO[T]) Update(ctx context.Context, data T) error {
-	query := buildUpdateQuery(dao.md.tableName, dao.md.dbTags, dao.md.primaryKeys)
-	_, err := dao.DB.Conn.NamedExecContext(ctx, query, data)
+	query := dao.md.updateQuery()
+	result, err := dao.w.NamedExecContext(ctx, query, data)
 	if err != nil {
-		return fmt.Errorf("failed to update data in table %s: %v", dao.md.tableName, err)
+		return dao.classify(err, "failed to update data in table %s", dao.md.tableName)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return apperr.NotFoundf("failed to update data in table %s: no such record", dao.md.tableName)
 	}
 	return nil
 }
 
-// Retrieves all records from the table corresponding to the DAO's type.
-func (dao *DAO[T]) GetAll(ctx context.Context) ([]T, error) {
-	query := buildSelectQuery(dao.md.tableName, []string{})
-	var retrievedData []T
-	err := dao.DB.Conn.SelectContext(ctx, &retrievedData, query)
+// Inserts a record, or updates the existing one with the same primary keys, even if it
+// is deleted. Whether it is deleted does not change.
+func (dao *DAO[T]) Upsert(ctx context.Context, data T) error {
+	query := buildUpsertQuery(dao.md.tableName, dao.md.dbTags, dao.md.primaryKeys)
+	_, err := dao.w.NamedExecContext(ctx, query, data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get data from table %s: %v", dao.md.tableName, err)
+		return dao.classify(err, "failed to upsert data in table %s", dao.md.tableName)
+	}
+	return nil
+}
+
+// Adds all records in a single transaction: if one fails, none are added. Their defaults are
+// filled in, see Defaulter.
+func (dao *DAO[T]) InsertMany(ctx context.Context, items []T) error {
+	for i := range items {
+		SetDefaults(&items[i])
+	}
+	query := buildInsertQuery(dao.md.tableName, dao.md.dbTags)
+	return dao.batch(ctx, query, items, func(i int, result sql.Result, err error) error {
+		if err != nil {
+			return dao.classify(err, "failed to insert record %d in table %s", i, dao.md.tableName)
+		}
+		return nil
+	})
+}
+
+// Modifies all records in a single transaction: if one fails or does not exist, none are
+// modified.
+func (dao *DAO[T]) UpdateMany(ctx context.Context, items []T) error {
+	query := dao.md.updateQuery()
+	return dao.batch(ctx, query, items, func(i int, result sql.Result, err error) error {
+		if err != nil {
+			return dao.classify(err, "failed to update record %d in table %s", i, dao.md.tableName)
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			return apperr.NotFoundf("failed to update record %d in table %s: no such record", i, dao.md.tableName)
+		}
+		return nil
+	})
+}
+
+// Runs the named `query` once per item with a prepared statement, in the transaction the DAO
+// is bound to or in a new one. `check` turns the result of each execution into an error.
+func (dao *DAO[T]) batch(ctx context.Context, query string, items []T, check func(i int, result sql.Result, err error) error) error {
+	if len(items) == 0 {
+		return nil
+	}
+	if _, ok := dao.w.(*sqlx.Tx); !ok {
+		return WithTx(ctx, dao.DB, func(tx *Tx) error {
+			return dao.In(tx).batch(ctx, query, items, check)
+		})
+	}
+
+	stmt, err := dao.w.PrepareNamedContext(ctx, query)
+	if err != nil {
+		return dao.classify(err, "failed to prepare statement on table %s", dao.md.tableName)
+	}
+	defer stmt.Close()
+
+	for i, item := range items {
+		result, err := stmt.ExecContext(ctx, item)
+		if err := check(i, result, err); err != nil {
+			return err
+		}
 	}
-	return retrievedData, nil
+	return nil
+}
+
+// Retrieves all records from the table corresponding to the DAO's type.
+func (dao *DAO[T]) GetAll(ctx context.Context) ([]T, error) {
+	return dao.Query().All(ctx)
 }
 
-// Retrieves a single record by the primary keys from the table.
+// Retrieves a single record by the primary keys from the table. Returns an apperr.NotFound
+// error if there is no such record.
 func (dao *DAO[T]) Get(ctx context.Context, args ...any) (*T, error) {
-	query := buildSelectQuery(dao.md.tableName, dao.md.primaryKeys)
+	query := dao.q.Rebind(dao.md.live(buildSelectQuery(dao.md.tableName, dao.md.primaryKeys)))
 	fmt.Println(query)
 	var retrievedData T
 	fmt.Println(retrievedData)
-	err := dao.DB.Conn.GetContext(ctx, &retrievedData, query, args...)
+	err := dao.q.GetContext(ctx, &retrievedData, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get data from table %s: %v", dao.md.tableName, err)
+		return nil, dao.classify(err, "failed to get data from table %s", dao.md.tableName)
 	}
 	return &retrievedData, err
 }
 
 // Retrieves records from the table that match the given filters.
 func (dao *DAO[T]) GetFiltered(ctx context.Context, filters map[string]any) ([]T, error) {
-	fields, args := extractFieldsAndArgs(filters)
-	query := buildSelectQuery(dao.md.tableName, fields)
-	var retrievedData []T
-	err := dao.DB.Conn.SelectContext(ctx, &retrievedData, query, args...)
+	return dao.Query().Filter(filters).All(ctx)
+}
+
+// Retrieves one page of the records matching `query.Filters`, along with the total amount of
+// matching records. Records are sorted by `query.SortBy`, then by the primary keys so that
+// pages are stable.
+func (dao *DAO[T]) GetPage(ctx context.Context, query PageQuery) (items []T, total int, err error) {
+	q := dao.Query().Filter(query.Filters)
+	if query.Deleted {
+		q.OnlyDeleted()
+	}
+	total, err = q.Count(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get data from table %s: %v", dao.md.tableName, err)
+		return nil, 0, err
 	}
-	return retrievedData, nil
+
+	if query.SortBy != "" {
+		q.OrderBy(query.SortBy, query.Descending)
+	}
+	for _, key := range dao.md.primaryKeys {
+		q.OrderBy(key, query.Descending)
+	}
+	items, err = q.Limit(query.Limit).Offset(query.Offset).All(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return items, total, nil
 }
 
-// Removes a record from the table using the DAO's primary keys.
+// Removes a record from the table using the DAO's primary keys. Returns an apperr.NotFound
+// error if there is no such record. Records of tables with a deleted_at column are only
+// marked as deleted, see Restore and Purge.
 func (dao *DAO[T]) Delete(ctx context.Context, args ...any) error {
+	return dao.DeleteAt(ctx, time.Now(), args...)
+}
+
+// Same as Delete, but records are marked as deleted at `at`, so that records deleted together
+// can be told apart from others.
+func (dao *DAO[T]) DeleteAt(ctx context.Context, at time.Time, args ...any) error {
 	query := buildDeleteQuery(dao.md.tableName, dao.md.primaryKeys)
-	_, err := dao.DB.Conn.ExecContext(ctx, query, args...)
+	if dao.md.softDelete {
+		query = dao.md.live(buildSetDeletedQuery(dao.md.tableName, dao.md.primaryKeys))
+		args = append([]any{at}, args...)
+	}
+	result, err := dao.w.ExecContext(ctx, dao.w.Rebind(query), args...)
 	if err != nil {
-		return fmt.Errorf("failed to execute query on table %s: %v", dao.md.tableName, err)
+		return dao.classify(err, "failed to execute query on table %s", dao.md.tableName)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return apperr.NotFoundf("failed to delete data from table %s: no such record", dao.md.tableName)
 	}
 	return nil
 }
 
+// Undoes the deletion of a record. Returns an apperr.NotFound error if there is no such
+// deleted record.
+func (dao *DAO[T]) Restore(ctx context.Context, args ...any) error {
+	if !dao.md.softDelete {
+		return apperr.Validationf("records of table %s cannot be restored", dao.md.tableName)
+	}
+	query := buildSetDeletedQuery(dao.md.tableName, dao.md.primaryKeys) + " AND " + deletedAtColumn + " IS NOT NULL"
+	result, err := dao.w.ExecContext(ctx, dao.w.Rebind(query), append([]any{nil}, args...)...)
+	if err != nil {
+		return dao.classify(err, "failed to restore data in table %s", dao.md.tableName)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return apperr.NotFoundf("failed to restore data in table %s: no such deleted record", dao.md.tableName)
+	}
+	return nil
+}
+
+// Permanently removes the records deleted before `before`, and returns how many there were.
+func (dao *DAO[T]) Purge(ctx context.Context, before time.Time) (int64, error) {
+	if !dao.md.softDelete {
+		return 0, apperr.Validationf("records of table %s cannot be purged", dao.md.tableName)
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s < ?", dao.md.tableName, deletedAtColumn)
+	result, err := dao.w.ExecContext(ctx, dao.w.Rebind(query), before)
+	if err != nil {
+		return 0, dao.classify(err, "failed to purge data from table %s", dao.md.tableName)
+	}
+	return result.RowsAffected()
+}
+
 func (dao *DAO[T]) Name() string {
 	return dao.md.tableName
 }
 
+// Returns the columns which can be filtered and sorted on through the API, i.e. all but
+// the ones hidden from JSON.
+func (dao *DAO[T]) PublicColumns() []string {
+	columns := make([]string, 0, len(dao.md.dbTags))
+	for _, tag := range dao.md.dbTags {
+		if !dao.md.columns[tag].hidden {
+			columns = append(columns, tag)
+		}
+	}
+	return columns
+}
+
+// Returns the columns identifying a record.
+func (dao *DAO[T]) PrimaryKeys() []string {
+	return dao.md.primaryKeys
+}
+
+// Returns the values of the primary keys of `item`, in the order Get and Delete expect them.
+func (dao *DAO[T]) KeysOf(item T) []any {
+	value := reflect.ValueOf(item)
+	keys := make([]any, 0, len(dao.md.primaryKeys))
+	for i := 0; i < value.NumField(); i++ {
+		if value.Type().Field(i).Tag.Get("primaryKey") != "" {
+			keys = append(keys, value.Field(i).Interface())
+		}
+	}
+	return keys
+}
+
+// Converts `raw`, e.g. a query parameter, to the type of `columnName`.
+func (dao *DAO[T]) ParseValue(columnName string, raw string) (any, error) {
+	col, ok := dao.md.columns[columnName]
+	if !ok {
+		return nil, apperr.Validationf("unknown column '%s' in table %s", columnName, dao.md.tableName)
+	}
+
+	kind := col.kind
+	if kind.Kind() == reflect.Pointer {
+		kind = kind.Elem()
+	}
+	var value any
+	var err error
+	switch {
+	case kind == reflect.TypeOf(time.Duration(0)):
+		value, err = time.ParseDuration(raw)
+	case kind == reflect.TypeOf(time.Time{}):
+		err = fmt.Errorf("cannot filter on timestamp column '%s'", columnName)
+	case kind.Kind() == reflect.Bool:
+		value, err = strconv.ParseBool(raw)
+	case kind.Kind() == reflect.Int:
+		value, err = strconv.Atoi(raw)
+	case kind.Kind() == reflect.String:
+		value = raw
+	default:
+		err = fmt.Errorf("cannot filter on column '%s'", columnName)
+	}
+	if err != nil {
+		return nil, apperr.Wrap(apperr.Validation, err, fmt.Sprintf("invalid value for column '%s'", columnName))
+	}
+	return value, nil
+}
+
+// Wraps an error of the database driver into an apperr.Error of the matching kind.
+func (dao *DAO[T]) classify(err error, format string, args ...any) error {
+	kind := apperr.Internal
+
+	var sqliteErr sqlite3.Error
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		kind = apperr.NotFound
+	case errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint:
+		switch sqliteErr.ExtendedCode {
+		case sqlite3.ErrConstraintNotNull, sqlite3.ErrConstraintCheck:
+			kind = apperr.Validation
+		default:
+			// Duplicate keys, references to missing records and immutable rows
+			kind = apperr.Conflict
+		}
+	case errors.As(err, &pqErr):
+		switch pqErr.Code {
+		case pgNotNullViolation, pgCheckViolation:
+			kind = apperr.Validation
+		case pgUniqueViolation, pgForeignKeyViolation, pgRaiseException:
+			kind = apperr.Conflict
+		}
+	}
+
+	return apperr.Wrap(kind, err, fmt.Sprintf(format, args...))
+}
+
 // Example query:
 //
 //	INSERT INTO participant (intra_login, github_login)
@@ -125,6 +390,29 @@ func buildUpdateQuery(tableName string, dbTags, primaryKeys []string) string {
 	return fmt.Sprintf("UPDATE %s SET %s WHERE %s", tableName, setClauses, whereClauses)
 }
 
+// Example query:
+//
+//	INSERT INTO participant (intra_login, github_login)
+//	VALUES (:intra_login, :github_login)
+//	ON CONFLICT (intra_login) DO UPDATE SET github_login = excluded.github_login;
+//
+// SQLite and PostgreSQL share this syntax.
+func buildUpsertQuery(tableName string, dbTags, primaryKeys []string) string {
+	updates := make([]string, 0, len(dbTags))
+	for _, tag := range dbTags {
+		if !slices.Contains(primaryKeys, tag) && tag != deletedAtColumn {
+			updates = append(updates, fmt.Sprintf("%s = excluded.%s", tag, tag))
+		}
+	}
+
+	conflict := "DO NOTHING"
+	if len(updates) > 0 {
+		conflict = "DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+	insert := strings.TrimSuffix(buildInsertQuery(tableName, dbTags), ";")
+	return fmt.Sprintf("%s ON CONFLICT (%s) %s;", insert, strings.Join(primaryKeys, ", "), conflict)
+}
+
 // Example query:
 //
 //	SELECT * FROM participant
@@ -137,6 +425,32 @@ func buildSelectQuery(tableName string, fields []string) string {
 	return fmt.Sprintf("SELECT * FROM %s WHERE %s", tableName, strings.Join(conditions, " AND "))
 }
 
+// Example query:
+//
+//	UPDATE participant SET deleted_at = ?
+//	WHERE intra_login = ?
+func buildSetDeletedQuery(tableName string, primaryKeys []string) string {
+	conditions := buildConditions(primaryKeys)
+	return fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s", tableName, deletedAtColumn, strings.Join(conditions, " AND "))
+}
+
+// Restricts `query`, which must end with a WHERE clause, to records which are not deleted.
+func (md metadata) live(query string) string {
+	if !md.softDelete {
+		return query
+	}
+	return fmt.Sprintf("%s AND %s IS NULL", query, deletedAtColumn)
+}
+
+// Update query which leaves deleted records and deleted_at alone.
+func (md metadata) updateQuery() string {
+	columns := md.dbTags
+	if md.softDelete {
+		columns = slices.DeleteFunc(slices.Clone(columns), func(tag string) bool { return tag == deletedAtColumn })
+	}
+	return md.live(buildUpdateQuery(md.tableName, columns, md.primaryKeys))
+}
+
 // Example query:
 //
 //	DELETE FROM participant
@@ -185,6 +499,8 @@ func getOrExtractMetadata[T any]() metadata {
 		dbTags:      dbTags,
 		primaryKeys: primaryKeys,
 		tableName:   tableName,
+		columns:     extractColumns(dummyType),
+		softDelete:  slices.Contains(dbTags, deletedAtColumn),
 	}
 	metadataCache.Store(dummyType, md)
 	return md
@@ -204,12 +520,13 @@ func extractTags[T any](data *T, key string) []string {
 	return tags
 }
 
-func extractFieldsAndArgs(filters map[string]any) ([]string, []any) {
-	fields := make([]string, 0, len(filters))
-	args := make([]any, 0, len(filters))
-	for field, value := range filters {
-		fields = append(fields, field)
-		args = append(args, value)
+func extractColumns(t reflect.Type) map[string]column {
+	columns := make(map[string]column, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if tag := field.Tag.Get("db"); tag != "" {
+			columns[tag] = column{kind: field.Type, hidden: field.Tag.Get("json") == "-"}
+		}
 	}
-	return fields, args
+	return columns
 }
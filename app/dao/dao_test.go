@@ -2,10 +2,17 @@ package dao
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/42-Short/shortinette/apperr"
+	"github.com/42-Short/shortinette/config"
 	"github.com/42-Short/shortinette/db"
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -26,6 +33,69 @@ func TestInsert(t *testing.T) {
 	assert.Equal(t, actualSize, expectedSize, fmt.Sprintf("expected %d participants after insertion but got %d", expectedSize, actualSize))
 }
 
+func TestUpsert(t *testing.T) {
+	db, modules, _ := newDummyDB(t)
+	moduleDAO := NewDAO[Module](db)
+	defer db.Close()
+	ctx := context.Background()
+
+	module := modules[0]
+	module.Score = 42
+	require.NoError(t, moduleDAO.Upsert(ctx, module), "existing records should be updated")
+	retrievedModule, err := moduleDAO.Get(ctx, module.Id, module.IntraLogin)
+	require.NoError(t, err)
+	assert.Equal(t, 42, retrievedModule.Score)
+
+	module.Id = 7
+	require.NoError(t, moduleDAO.Upsert(ctx, module), "missing records should be inserted")
+	retrievedModule, err = moduleDAO.Get(ctx, module.Id, module.IntraLogin)
+	require.NoError(t, err)
+	assert.Equal(t, 42, retrievedModule.Score)
+
+	err = moduleDAO.Upsert(ctx, Module{Id: 0, IntraLogin: "nobody"})
+	assert.True(t, apperr.Is(err, apperr.Conflict), "unknown participants should be reported as conflict: %v", err)
+}
+
+func TestBatch(t *testing.T) {
+	db, modules, participants := newDummyDB(t)
+	participantDAO := NewDAO[Participant](db)
+	moduleDAO := NewDAO[Module](db)
+	defer db.Close()
+	ctx := context.Background()
+
+	newParticipants := []Participant{*NewDummyParticipant(100), *NewDummyParticipant(101), participants[0]}
+	err := participantDAO.InsertMany(ctx, newParticipants)
+	assert.True(t, apperr.Is(err, apperr.Conflict), "duplicate primary keys should be reported as conflict: %v", err)
+	retrievedParticipants, err := participantDAO.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Len(t, retrievedParticipants, len(participants), "no record should be inserted if one fails")
+
+	require.NoError(t, participantDAO.InsertMany(ctx, newParticipants[:2]))
+	retrievedParticipants, err = participantDAO.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Len(t, retrievedParticipants, len(participants)+2)
+
+	updated := []Module{modules[0], modules[1], {Id: 0, IntraLogin: "nobody"}}
+	updated[0].Score, updated[1].Score = 10, 20
+	err = moduleDAO.UpdateMany(ctx, updated)
+	assert.True(t, apperr.Is(err, apperr.NotFound), "updating a missing record should be reported as not found: %v", err)
+	retrievedModule, err := moduleDAO.Get(ctx, modules[0].Id, modules[0].IntraLogin)
+	require.NoError(t, err)
+	assert.Equal(t, 0, retrievedModule.Score, "no record should be updated if one fails")
+
+	require.NoError(t, moduleDAO.UpdateMany(ctx, updated[:2]))
+	retrievedModule, err = moduleDAO.Get(ctx, modules[1].Id, modules[1].IntraLogin)
+	require.NoError(t, err)
+	assert.Equal(t, 20, retrievedModule.Score)
+
+	err = WithTx(ctx, db, func(tx *Tx) error {
+		return moduleDAO.In(tx).InsertMany(ctx, []Module{*NewDummyModule(7, "dummy_participant100")})
+	})
+	require.NoError(t, err, "batches should run in the transaction the DAO is bound to")
+	_, err = moduleDAO.Get(ctx, 7, "dummy_participant100")
+	assert.NoError(t, err)
+}
+
 func TestUpdate(t *testing.T) {
 	db, modules, _ := newDummyDB(t)
 	moduleDAO := NewDAO[Module](db)
@@ -78,6 +148,80 @@ func TestGetFiltered(t *testing.T) {
 	}
 }
 
+func TestGetPage(t *testing.T) {
+	db, modules, _ := newDummyDB(t)
+	moduleDAO := NewDAO[Module](db)
+	defer db.Close()
+
+	query := PageQuery{Filters: map[string]any{"id": 3}, SortBy: "intra_login", Descending: true, Limit: 5, Offset: 5}
+	retrievedModules, total, err := moduleDAO.GetPage(context.Background(), query)
+	require.NoError(t, err)
+	assert.Equal(t, len(modules)/7, total)
+	require.Len(t, retrievedModules, 5)
+	for idx := 1; idx < len(retrievedModules); idx++ {
+		assert.Equal(t, 3, retrievedModules[idx].Id)
+		assert.Greater(t, retrievedModules[idx-1].IntraLogin, retrievedModules[idx].IntraLogin)
+	}
+
+	_, _, err = moduleDAO.GetPage(context.Background(), PageQuery{SortBy: "score; DROP TABLE module", Limit: 5})
+	assert.Error(t, err, "unknown columns should be refused")
+	_, err = moduleDAO.GetFiltered(context.Background(), map[string]any{"1 = 1 OR id": 0})
+	assert.Error(t, err, "unknown columns should be refused")
+}
+
+func TestQuery(t *testing.T) {
+	db, modules, _ := newDummyDB(t)
+	moduleDAO := NewDAO[Module](db)
+	defer db.Close()
+	ctx := context.Background()
+
+	for i, module := range modules[:7] {
+		module.Score = i * 10
+		require.NoError(t, moduleDAO.Update(ctx, module))
+	}
+
+	retrievedModules, err := moduleDAO.Query().
+		Where("intra_login", Eq, modules[0].IntraLogin).
+		Where("score", Ge, 20).
+		WhereIn("id", 1, 2, 3, 6).
+		OrderBy("score", true).
+		Limit(2).
+		Offset(1).
+		All(ctx)
+	require.NoError(t, err)
+	require.Len(t, retrievedModules, 2)
+	assert.Equal(t, 3, retrievedModules[0].Id)
+	assert.Equal(t, 2, retrievedModules[1].Id)
+
+	count, err := moduleDAO.Query().Where("score", Gt, 0).Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 6, count)
+
+	first, err := moduleDAO.Query().Where("score", Lt, 100).OrderBy("score", true).First(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 60, first.Score)
+
+	exists, err := moduleDAO.Query().Where("score", Ne, 0).Where("id", Eq, 0).Exists(ctx)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	exists, err = moduleDAO.Query().Where("intra_login", EqFold, strings.ToUpper(modules[0].IntraLogin)).Exists(ctx)
+	require.NoError(t, err)
+	assert.True(t, exists, "EqFold should ignore case")
+	exists, err = moduleDAO.Query().WhereIn("id").Exists(ctx)
+	require.NoError(t, err)
+	assert.False(t, exists, "empty IN lists should match nothing")
+
+	_, err = moduleDAO.Query().Where("score", Ge, 0).First(ctx)
+	require.NoError(t, err)
+	_, err = moduleDAO.Query().Where("score", Gt, 1000).First(ctx)
+	assert.True(t, apperr.Is(err, apperr.NotFound), "no match for First should be reported as not found: %v", err)
+
+	_, err = moduleDAO.Query().OrderBy("score DESC; DROP TABLE module", false).All(ctx)
+	assert.True(t, apperr.Is(err, apperr.Validation), "unknown columns should be reported as invalid: %v", err)
+	_, err = moduleDAO.Query().Where("score", Op("= 0 OR 1 ="), 0).Count(ctx)
+	assert.True(t, apperr.Is(err, apperr.Validation), "unknown operators should be reported as invalid: %v", err)
+}
+
 func TestGetAll(t *testing.T) {
 	db, _, participants := newDummyDB(t)
 	participantDAO := NewDAO[Participant](db)
@@ -102,12 +246,283 @@ func TestDelete(t *testing.T) {
 	assert.Equal(t, len(retrievedModules), len(modules)-1, "failed to delete module from DB")
 }
 
+func TestSoftDelete(t *testing.T) {
+	db, modules, _ := newDummyDB(t)
+	moduleDAO := NewDAO[Module](db)
+	defer db.Close()
+	ctx := context.Background()
+	module := modules[0]
+
+	require.NoError(t, moduleDAO.Delete(ctx, module.Id, module.IntraLogin))
+	_, err := moduleDAO.Get(ctx, module.Id, module.IntraLogin)
+	assert.True(t, apperr.Is(err, apperr.NotFound), "deleted records should be hidden")
+	assert.True(t, apperr.Is(moduleDAO.Delete(ctx, module.Id, module.IntraLogin), apperr.NotFound))
+	assert.True(t, apperr.Is(moduleDAO.Update(ctx, module), apperr.NotFound), "deleted records should not be updatable")
+
+	deleted, err := moduleDAO.Query().OnlyDeleted().All(ctx)
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	assert.NotNil(t, deleted[0].DeletedAt)
+	all, err := moduleDAO.Query().WithDeleted().Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(modules), all)
+
+	require.NoError(t, moduleDAO.Restore(ctx, module.Id, module.IntraLogin))
+	assert.True(t, apperr.Is(moduleDAO.Restore(ctx, module.Id, module.IntraLogin), apperr.NotFound), "live records should not be restorable")
+	restored, err := moduleDAO.Get(ctx, module.Id, module.IntraLogin)
+	require.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+
+	require.NoError(t, moduleDAO.DeleteAt(ctx, time.Now().Add(-time.Hour), module.Id, module.IntraLogin))
+	require.NoError(t, moduleDAO.Delete(ctx, modules[1].Id, modules[1].IntraLogin))
+	purged, err := moduleDAO.Purge(ctx, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged, "only records deleted before the cutoff should be purged")
+	all, err = moduleDAO.Query().WithDeleted().Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(modules)-1, all)
+}
+
+func TestErrorKinds(t *testing.T) {
+	db, modules, participants := newDummyDB(t)
+	participantDAO := NewDAO[Participant](db)
+	moduleDAO := NewDAO[Module](db)
+	defer db.Close()
+	ctx := context.Background()
+
+	_, err := participantDAO.Get(ctx, "nobody")
+	assert.True(t, apperr.Is(err, apperr.NotFound), "missing records should be reported as not found: %v", err)
+	err = participantDAO.Update(ctx, Participant{IntraLogin: "nobody", GitHubLogin: "nobody"})
+	assert.True(t, apperr.Is(err, apperr.NotFound), "updating a missing record should be reported as not found: %v", err)
+	err = moduleDAO.Delete(ctx, 42, "nobody")
+	assert.True(t, apperr.Is(err, apperr.NotFound), "deleting a missing record should be reported as not found: %v", err)
+
+	err = participantDAO.Insert(ctx, participants[0])
+	assert.True(t, apperr.Is(err, apperr.Conflict), "duplicate primary keys should be reported as conflict: %v", err)
+	err = moduleDAO.Insert(ctx, Module{Id: modules[0].Id, IntraLogin: "nobody"})
+	assert.True(t, apperr.Is(err, apperr.Conflict), "unknown participants should be reported as conflict: %v", err)
+
+	_, err = participantDAO.GetFiltered(ctx, map[string]any{"1=1; --": 1})
+	assert.True(t, apperr.Is(err, apperr.Validation), "unknown columns should be reported as invalid: %v", err)
+}
+
+func TestValidate(t *testing.T) {
+	exercise, err := config.NewExercise(25, []string{"*.rs"}, "ex00")
+	require.NoError(t, err)
+	module, err := config.NewModule([]config.Exercise{*exercise, *exercise}, 25)
+	require.NoError(t, err)
+	conf := config.Config{Modules: []config.Module{*module}}
+
+	assert.NoError(t, Validate(*NewDummyModule(0, "dummy_participant0"), conf))
+	assert.NoError(t, Validate(*NewDummyParticipant(0), conf))
+	assert.NoError(t, Validate(Token{}, conf), "records without rules are always valid")
+
+	tests := []struct {
+		item   any
+		fields []string
+	}{
+		{Module{Id: 1, IntraLogin: "foo"}, []string{"id"}},
+		{Module{Id: 0, IntraLogin: "", Score: 51, Attempts: -1, WaitTime: -time.Second}, []string{"intra_login", "score", "attempts", "wait_time"}},
+		{Participant{IntraLogin: "foo/../bar", GitHubLogin: "bar", CurrentModuleId: 2}, []string{"intra_login", "current_module_id", "status"}},
+		{Participant{IntraLogin: "foo", Status: "asleep"}, []string{"github_login", "status"}},
+	}
+	for _, test := range tests {
+		err := Validate(test.item, conf)
+		require.True(t, apperr.Is(err, apperr.Validation), "%+v should be invalid: %v", test.item, err)
+
+		var fieldErrors FieldErrors
+		require.ErrorAs(t, err, &fieldErrors)
+		fields := []string{}
+		for _, fieldErr := range fieldErrors {
+			fields = append(fields, fieldErr.Field)
+		}
+		assert.ElementsMatch(t, test.fields, fields, "%+v", test.item)
+	}
+}
+
+func TestInsertParticipantDefaultsToActive(t *testing.T) {
+	db, _, _ := newDummyDB(t)
+	defer db.Close()
+	participantDAO := NewDAO[Participant](db)
+	ctx := context.Background()
+
+	require.NoError(t, participantDAO.Insert(ctx, Participant{IntraLogin: "newcomer", GitHubLogin: "newcomer-gh"}))
+	participant, err := participantDAO.Get(ctx, "newcomer")
+	require.NoError(t, err)
+	assert.Equal(t, ParticipantActive, participant.Status)
+	assert.True(t, participant.IsActive())
+}
+
+func TestWithTx(t *testing.T) {
+	db, modules, participants := newDummyDB(t)
+	moduleDAO := NewDAO[Module](db)
+	participantDAO := NewDAO[Participant](db)
+	defer db.Close()
+	ctx := context.Background()
+
+	module, participant := modules[0], participants[0]
+	module.Score, participant.CurrentModuleId = 100, 1
+	err := WithTx(ctx, db, func(tx *Tx) error {
+		if err := moduleDAO.In(tx).Update(ctx, module); err != nil {
+			return err
+		}
+		return participantDAO.In(tx).Update(ctx, Participant{IntraLogin: "nobody"})
+	})
+	require.True(t, apperr.Is(err, apperr.NotFound), "the error of the failed operation should be returned: %v", err)
+
+	retrievedModule, err := moduleDAO.Get(ctx, module.Id, module.IntraLogin)
+	require.NoError(t, err)
+	assert.Equal(t, 0, retrievedModule.Score, "operations before the failed one should be rolled back")
+
+	err = WithTx(ctx, db, func(tx *Tx) error {
+		if err := moduleDAO.In(tx).Update(ctx, module); err != nil {
+			return err
+		}
+		return participantDAO.In(tx).Update(ctx, participant)
+	})
+	require.NoError(t, err)
+
+	retrievedModule, err = moduleDAO.Get(ctx, module.Id, module.IntraLogin)
+	require.NoError(t, err)
+	assert.Equal(t, 100, retrievedModule.Score)
+	retrievedParticipant, err := participantDAO.Get(ctx, participant.IntraLogin)
+	require.NoError(t, err)
+	assert.Equal(t, 1, retrievedParticipant.CurrentModuleId)
+}
+
+func TestFinalGradeImmutable(t *testing.T) {
+	db, modules, _ := newDummyDB(t)
+	finalGradeDAO := NewDAO[FinalGrade](db)
+	defer db.Close()
+
+	grade := FinalGrade{ModuleId: modules[0].Id, IntraLogin: modules[0].IntraLogin, Score: 42, Rule: "best", FrozenAt: time.Now()}
+	require.NoError(t, finalGradeDAO.Insert(context.Background(), grade))
+
+	grade.Score = 100
+	assert.Error(t, finalGradeDAO.Update(context.Background(), grade), "final grades should not be updatable")
+	assert.Error(t, finalGradeDAO.Delete(context.Background(), grade.ModuleId, grade.IntraLogin), "final grades should not be deletable")
+
+	retrievedGrade, err := finalGradeDAO.Get(context.Background(), grade.ModuleId, grade.IntraLogin)
+	require.NoError(t, err)
+	assert.Equal(t, 42, retrievedGrade.Score)
+}
+
+func TestAuditEntry(t *testing.T) {
+	db, modules, _ := newDummyDB(t)
+	moduleDAO := NewDAO[Module](db)
+	auditDAO := NewDAO[AuditEntry](db)
+	defer db.Close()
+
+	assert.Equal(t, []any{modules[0].Id, modules[0].IntraLogin}, moduleDAO.KeysOf(modules[0]))
+
+	entry := AuditEntry{Id: "entry", Actor: "admin", Action: "grade", Target: "module", After: `{"score":20}`, CreatedAt: time.Now()}
+	require.NoError(t, auditDAO.Insert(context.Background(), entry))
+	retrievedEntry, err := auditDAO.Get(context.Background(), entry.Id)
+	require.NoError(t, err)
+	assert.Equal(t, entry.After, retrievedEntry.After)
+
+	encoded, err := json.Marshal(retrievedEntry)
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"after":{"score":20}`, "documents should be serialized as JSON, not as strings")
+	assert.NotContains(t, string(encoded), `"before"`)
+
+	entry.Actor = "someone else"
+	assert.Error(t, auditDAO.Update(context.Background(), entry), "audit entries should not be updatable")
+	assert.Error(t, auditDAO.Delete(context.Background(), entry.Id), "audit entries should not be deletable")
+}
+
+func TestPostgresQueries(t *testing.T) {
+	postgres := &db.DB{Conn: sqlx.NewDb(nil, string(db.Postgres)), Dialect: db.Postgres}
+	moduleDAO := NewDAO[Module](postgres)
+
+	query, args := moduleDAO.Query().
+		Where("intra_login", Eq, "dummy_participant0").
+		WhereIn("id", 1, 2).
+		OrderBy("score", true).
+		Offset(5).
+		build("*", true)
+	assert.Equal(t, "SELECT * FROM module WHERE intra_login = $1 AND id IN ($2, $3) AND deleted_at IS NULL ORDER BY score DESC OFFSET $4", query)
+	assert.Equal(t, []any{"dummy_participant0", 1, 2, 5}, args)
+
+	query, _ = moduleDAO.Query().Limit(10).build("*", true)
+	assert.Equal(t, "SELECT * FROM module WHERE deleted_at IS NULL LIMIT $1 OFFSET $2", query)
+
+	query, _ = moduleDAO.Query().Limit(1).ForUpdate().build("*", true)
+	assert.Equal(t, "SELECT * FROM module WHERE deleted_at IS NULL LIMIT $1 OFFSET $2 FOR UPDATE", query)
+}
+
+// Runs the DAO against the PostgreSQL server at $SHORTINETTE_TEST_POSTGRES, see db.TestMigratePostgres.
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("SHORTINETTE_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("SHORTINETTE_TEST_POSTGRES is not set")
+	}
+	ctx := context.Background()
+
+	admin, err := db.NewDB(ctx, dsn)
+	require.NoError(t, err)
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	_, err = admin.Conn.Exec("CREATE SCHEMA " + schema)
+	require.NoError(t, err)
+	defer func() {
+		_, _ = admin.Conn.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	}()
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	postgres, err := db.NewDB(ctx, dsn+separator+"search_path="+schema)
+	require.NoError(t, err)
+	defer postgres.Close()
+	require.NoError(t, postgres.Migrate(ctx))
+
+	data, err := SeedDB(postgres)
+	require.NoError(t, err)
+	participantDAO := NewDAO[Participant](postgres)
+	moduleDAO := NewDAO[Module](postgres)
+	finalGradeDAO := NewDAO[FinalGrade](postgres)
+
+	module := data.modules[0]
+	module.Score, module.WaitTime = 42, time.Hour
+	require.NoError(t, moduleDAO.Upsert(ctx, module))
+	retrievedModule, err := moduleDAO.Get(ctx, module.Id, module.IntraLogin)
+	require.NoError(t, err)
+	assert.Equal(t, 42, retrievedModule.Score)
+	assert.Equal(t, time.Hour, retrievedModule.WaitTime)
+
+	count, err := moduleDAO.Query().Where("score", Gt, 0).WhereIn("id", 0, 1).Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	exists, err := participantDAO.Query().Where("github_login", Eq, data.participants[1].GitHubLogin).Exists(ctx)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	page, total, err := moduleDAO.GetPage(ctx, PageQuery{SortBy: "score", Descending: true, Limit: 3, Offset: 0})
+	require.NoError(t, err)
+	assert.Equal(t, len(data.modules), total)
+	assert.Equal(t, 42, page[0].Score)
+
+	err = participantDAO.Insert(ctx, data.participants[0])
+	assert.True(t, apperr.Is(err, apperr.Conflict), "duplicate primary keys should be reported as conflict: %v", err)
+	err = moduleDAO.Insert(ctx, Module{Id: 0, IntraLogin: "nobody"})
+	assert.True(t, apperr.Is(err, apperr.Conflict), "unknown participants should be reported as conflict: %v", err)
+	_, err = participantDAO.Get(ctx, "nobody")
+	assert.True(t, apperr.Is(err, apperr.NotFound), "missing records should be reported as not found: %v", err)
+
+	grade := FinalGrade{ModuleId: 0, IntraLogin: module.IntraLogin, Score: 42, Rule: "best", FrozenAt: time.Now()}
+	require.NoError(t, finalGradeDAO.Insert(ctx, grade))
+	grade.Score = 100
+	err = finalGradeDAO.Update(ctx, grade)
+	assert.True(t, apperr.Is(err, apperr.Conflict), "final grades should not be updatable: %v", err)
+}
+
 func newDummyDB(t *testing.T) (*db.DB, []Module, []Participant) {
 	t.Helper()
 
 	db, err := db.NewDB(context.Background(), "file::memory:?cache=shared")
 	require.NoError(t, err)
-	err = db.Initialize("../db/schema.sql")
+	err = db.Migrate(context.Background())
 	require.NoError(t, err)
 
 	data, err := SeedDB(db)
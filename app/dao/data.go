@@ -11,10 +11,135 @@ type Module struct {
 	Score      int           `db:"score" json:"score"`
 	LastGraded time.Time     `db:"last_graded" json:"last_graded"`
 	WaitTime   time.Duration `db:"wait_time" json:"wait_time"`
+	ClosedAt   *time.Time    `db:"closed_at" json:"closed_at,omitempty"`
+	Reopened   bool          `db:"reopened" json:"reopened"` // Reopened by an admin, not closed with the rest of the module
+	DeletedAt  *time.Time    `db:"deleted_at" json:"deleted_at,omitempty"`
 }
 
 type Participant struct {
-	IntraLogin  string `db:"intra_login" json:"intra_login" primaryKey:"intra_login"`
-	GitHubLogin string `db:"github_login" json:"github_login"`
-	CurrentModuleId int `db:"current_module_id" json:"current_module_id"`
+	IntraLogin      string     `db:"intra_login" json:"intra_login" primaryKey:"intra_login"`
+	GitHubLogin     string     `db:"github_login" json:"github_login"`
+	CurrentModuleId int        `db:"current_module_id" json:"current_module_id"`
+	Status          string     `db:"status" json:"status"`
+	DeletedAt       *time.Time `db:"deleted_at" json:"deleted_at,omitempty"`
+}
+
+// Statuses of a participant. Only active participants get repos and are graded.
+const (
+	ParticipantActive    = "active"
+	ParticipantWithdrawn = "withdrawn"
+	ParticipantBanned    = "banned"
+)
+
+var ParticipantStatuses = []string{ParticipantActive, ParticipantWithdrawn, ParticipantBanned}
+
+func (participant Participant) IsActive() bool {
+	return participant.Status == ParticipantActive
+}
+
+// Participants created without status are active.
+func (participant *Participant) SetDefaults() {
+	if participant.Status == "" {
+		participant.Status = ParticipantActive
+	}
+}
+
+type WebhookDelivery struct {
+	DeliveryId string    `db:"delivery_id" json:"delivery_id" primaryKey:"delivery_id"`
+	Event      string    `db:"event" json:"event"`
+	Repository string    `db:"repository" json:"repository"`
+	ReceivedAt time.Time `db:"received_at" json:"received_at"`
+	Outcome    string    `db:"outcome" json:"outcome"`
+}
+
+// API token issued through the token management API. Only the SHA-256 hash of the
+// token is stored, the token itself is shown once when it is issued.
+type Token struct {
+	Id         string     `db:"id" json:"id" primaryKey:"id"`
+	Name       string     `db:"name" json:"name"`
+	Hash       string     `db:"hash" json:"-"`
+	Scope      string     `db:"scope" json:"scope"`
+	IntraLogin string     `db:"intra_login" json:"intra_login,omitempty"` // Only set for student tokens
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+}
+
+// Intra login session. Only the SHA-256 hash of the session ID is stored, the ID itself
+// is only known to the browser holding the session cookie.
+type Session struct {
+	Id         string    `db:"id" json:"-" primaryKey:"id"`
+	IntraLogin string    `db:"intra_login" json:"intra_login"`
+	Scope      string    `db:"scope" json:"scope"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	ExpiresAt  time.Time `db:"expires_at" json:"expires_at"`
+}
+
+// Audit record of a self-service registration.
+type Registration struct {
+	IntraLogin   string     `db:"intra_login" json:"intra_login" primaryKey:"intra_login"`
+	GitHubLogin  string     `db:"github_login" json:"github_login"`
+	Status       string     `db:"status" json:"status"`
+	Verification string     `db:"verification" json:"verification"` // How ownership of the GitHub account was proven
+	Challenge    string     `db:"challenge" json:"-"`
+	RemoteAddr   string     `db:"remote_addr" json:"remote_addr"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	RegisteredAt *time.Time `db:"registered_at" json:"registered_at,omitempty"`
+}
+
+// Result of a single grading attempt.
+type Attempt struct {
+	Id         string    `db:"id" json:"id" primaryKey:"id"`
+	ModuleId   int       `db:"module_id" json:"module_id"`
+	IntraLogin string    `db:"intra_login" json:"intra_login"`
+	Score      int       `db:"score" json:"score"`
+	Passed     bool      `db:"passed" json:"passed"`
+	CommitSHA  string    `db:"commit_sha" json:"commit_sha"`
+	TraceRef   string    `db:"trace_ref" json:"trace_ref"` // <branch>:<path> of the trace in the participant's repo
+	GradedAt   time.Time `db:"graded_at" json:"graded_at"`
+}
+
+// Grade of a participant for a module, frozen when the module closes. Final grades cannot
+// be changed once written.
+type FinalGrade struct {
+	ModuleId   int       `db:"module_id" json:"module_id" primaryKey:"module_id"`
+	IntraLogin string    `db:"intra_login" json:"intra_login" primaryKey:"intra_login"`
+	Score      int       `db:"score" json:"score"`
+	Passed     bool      `db:"passed" json:"passed"`
+	AttemptId  string    `db:"attempt_id" json:"attempt_id"` // Empty if the participant never got graded
+	CommitSHA  string    `db:"commit_sha" json:"commit_sha"`
+	TraceRef   string    `db:"trace_ref" json:"trace_ref"`
+	Rule       string    `db:"rule" json:"rule"`
+	FrozenAt   time.Time `db:"frozen_at" json:"frozen_at"`
+}
+
+// Record of a change made through the API or by the grader. Audit entries cannot be changed
+// once written.
+type AuditEntry struct {
+	Id        string    `db:"id" json:"id" primaryKey:"id"`
+	Actor     string    `db:"actor" json:"actor"`   // Name of the token or session, or `github:<pusher>` for webhooks
+	Action    string    `db:"action" json:"action"` // e.g. `PUT /shortinette/v1/modules` or `grade`
+	Target    string    `db:"target" json:"target"` // e.g. `module [0 jdoe]`
+	Before    JSON      `db:"before_value" json:"before,omitempty"`
+	After     JSON      `db:"after_value" json:"after,omitempty"`
+	RequestId string    `db:"request_id" json:"request_id,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// JSON document stored as text. It is serialized as is rather than as a string, and is
+// empty if there is no document.
+type JSON string
+
+func (j JSON) MarshalJSON() ([]byte, error) {
+	if j == "" {
+		return []byte("null"), nil
+	}
+	return []byte(j), nil
+}
+
+func (j *JSON) UnmarshalJSON(data []byte) error {
+	*j = ""
+	if string(data) != "null" {
+		*j = JSON(data)
+	}
+	return nil
 }
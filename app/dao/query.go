@@ -0,0 +1,292 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/42-Short/shortinette/apperr"
+	"github.com/42-Short/shortinette/db"
+)
+
+// Comparison operator of a Query condition.
+type Op string
+
+const (
+	Eq Op = "="
+	Ne Op = "<>"
+	Lt Op = "<"
+	Le Op = "<="
+	Gt Op = ">"
+	Ge Op = ">="
+
+	// Case-insensitive Eq, for text columns such as GitHub logins
+	EqFold Op = "=~"
+)
+
+type condition struct {
+	column string
+	op     Op
+	values []any // Several for IN lists
+	in     bool
+}
+
+type ordering struct {
+	column     string
+	descending bool
+}
+
+// Which records of tables with a deleted_at column a Query matches.
+type scope int
+
+const (
+	liveRecords scope = iota
+	allRecords
+	deletedRecords
+)
+
+// Builds a SELECT on the table of a DAO:
+//
+//	modules, err := moduleDAO.Query().
+//		Where("score", dao.Ge, 50).
+//		WhereIn("id", 0, 1).
+//		OrderBy("score", true).
+//		Limit(10).
+//		All(ctx)
+//
+// Column names are checked against the db tags of T, values are always passed as arguments.
+// Conditions are joined with AND. Deleted records are left out, see WithDeleted.
+type Query[T any] struct {
+	dao        *DAO[T]
+	conditions []condition
+	orderBy    []ordering
+	limit      int
+	offset     int
+	scope      scope
+	lock       bool
+	err        error // First invalid column or operator, returned when the query is run
+}
+
+// Starts a query matching every record of the table.
+func (dao *DAO[T]) Query() *Query[T] {
+	return &Query[T]{dao: dao}
+}
+
+// Keeps the records whose `column` compares to `value` with `op`.
+func (q *Query[T]) Where(column string, op Op, value any) *Query[T] {
+	switch op {
+	case Eq, Ne, Lt, Le, Gt, Ge, EqFold:
+	default:
+		q.fail(apperr.Validationf("invalid operator '%s'", op))
+	}
+	q.check(column)
+	q.conditions = append(q.conditions, condition{column: column, op: op, values: []any{value}})
+	return q
+}
+
+// Keeps the records whose `column` equals one of `values`. No record matches an empty list.
+func (q *Query[T]) WhereIn(column string, values ...any) *Query[T] {
+	q.check(column)
+	q.conditions = append(q.conditions, condition{column: column, values: values, in: true})
+	return q
+}
+
+// Adds an equality condition for each entry of `filters`, in the order of the column names.
+func (q *Query[T]) Filter(filters map[string]any) *Query[T] {
+	columns := make([]string, 0, len(filters))
+	for column := range filters {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		q.Where(column, Eq, filters[column])
+	}
+	return q
+}
+
+// Also matches deleted records.
+func (q *Query[T]) WithDeleted() *Query[T] {
+	q.scope = allRecords
+	return q
+}
+
+// Only matches deleted records.
+func (q *Query[T]) OnlyDeleted() *Query[T] {
+	q.scope = deletedRecords
+	return q
+}
+
+// Locks the matching records until the end of the transaction the DAO is bound to, see In.
+// Transactions are already serialized with SQLite, so this only matters with PostgreSQL.
+func (q *Query[T]) ForUpdate() *Query[T] {
+	q.lock = true
+	return q
+}
+
+// Sorts the records by `column`, after the columns of previous OrderBy calls.
+func (q *Query[T]) OrderBy(column string, descending bool) *Query[T] {
+	q.check(column)
+	q.orderBy = append(q.orderBy, ordering{column: column, descending: descending})
+	return q
+}
+
+// Returns at most `limit` records, all of them if `limit` is 0.
+func (q *Query[T]) Limit(limit int) *Query[T] {
+	if limit < 0 {
+		q.fail(apperr.Validationf("invalid limit %d", limit))
+	}
+	q.limit = limit
+	return q
+}
+
+// Skips the first `offset` records.
+func (q *Query[T]) Offset(offset int) *Query[T] {
+	if offset < 0 {
+		q.fail(apperr.Validationf("invalid offset %d", offset))
+	}
+	q.offset = offset
+	return q
+}
+
+// Retrieves the matching records.
+func (q *Query[T]) All(ctx context.Context) ([]T, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	query, args := q.build("*", true)
+	items := []T{}
+	if err := q.dao.q.SelectContext(ctx, &items, query, args...); err != nil {
+		return nil, q.dao.classify(err, "failed to get data from table %s", q.dao.md.tableName)
+	}
+	return items, nil
+}
+
+// Retrieves the first matching record. Returns an apperr.NotFound error if there is none.
+func (q *Query[T]) First(ctx context.Context) (*T, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	limit := q.limit
+	q.limit = 1
+	query, args := q.build("*", true)
+	q.limit = limit
+
+	var item T
+	if err := q.dao.q.GetContext(ctx, &item, query, args...); err != nil {
+		return nil, q.dao.classify(err, "failed to get data from table %s", q.dao.md.tableName)
+	}
+	return &item, nil
+}
+
+// Counts the matching records, ignoring Limit and Offset.
+func (q *Query[T]) Count(ctx context.Context) (int, error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+	query, args := q.build("COUNT(*)", false)
+	var count int
+	if err := q.dao.q.GetContext(ctx, &count, query, args...); err != nil {
+		return 0, q.dao.classify(err, "failed to count data in table %s", q.dao.md.tableName)
+	}
+	return count, nil
+}
+
+// Reports whether any record matches.
+func (q *Query[T]) Exists(ctx context.Context) (bool, error) {
+	if q.err != nil {
+		return false, q.err
+	}
+	query, args := q.build("1", false)
+	var exists bool
+	err := q.dao.q.GetContext(ctx, &exists, fmt.Sprintf("SELECT EXISTS (%s)", query), args...)
+	if err != nil {
+		return false, q.dao.classify(err, "failed to get data from table %s", q.dao.md.tableName)
+	}
+	return exists, nil
+}
+
+// Example query:
+//
+//	SELECT * FROM module
+//	WHERE score >= ? AND id IN (?, ?)
+//	ORDER BY score DESC
+//	LIMIT ? OFFSET ?
+func (q *Query[T]) build(selection string, paginate bool) (string, []any) {
+	var query strings.Builder
+	var args []any
+	fmt.Fprintf(&query, "SELECT %s FROM %s", selection, q.dao.md.tableName)
+
+	conditions := make([]string, len(q.conditions))
+	for i, cond := range q.conditions {
+		switch {
+		case cond.in && len(cond.values) == 0:
+			conditions[i] = "1 = 0"
+		case cond.in:
+			placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(cond.values)), ", ")
+			conditions[i] = fmt.Sprintf("%s IN (%s)", cond.column, placeholders)
+		case cond.op == EqFold:
+			conditions[i] = fmt.Sprintf("LOWER(%s) = LOWER(?)", cond.column)
+		default:
+			conditions[i] = fmt.Sprintf("%s %s ?", cond.column, cond.op)
+		}
+		args = append(args, cond.values...)
+	}
+	switch {
+	case q.scope == allRecords:
+	case q.scope == deletedRecords && !q.dao.md.softDelete:
+		conditions = append(conditions, "1 = 0") // Records of other tables are never deleted
+	case q.scope == deletedRecords:
+		conditions = append(conditions, deletedAtColumn+" IS NOT NULL")
+	case q.dao.md.softDelete:
+		conditions = append(conditions, deletedAtColumn+" IS NULL")
+	}
+	if len(conditions) > 0 {
+		fmt.Fprintf(&query, " WHERE %s", strings.Join(conditions, " AND "))
+	}
+
+	if !paginate {
+		return q.dao.q.Rebind(query.String()), args
+	}
+
+	if len(q.orderBy) > 0 {
+		order := make([]string, len(q.orderBy))
+		for i, o := range q.orderBy {
+			direction := "ASC"
+			if o.descending {
+				direction = "DESC"
+			}
+			order[i] = fmt.Sprintf("%s %s", o.column, direction)
+		}
+		fmt.Fprintf(&query, " ORDER BY %s", strings.Join(order, ", "))
+	}
+	switch {
+	case q.limit > 0:
+		query.WriteString(" LIMIT ? OFFSET ?")
+		args = append(args, q.limit, q.offset)
+	case q.offset > 0 && q.dao.DB.Dialect == db.SQLite:
+		// SQLite needs a LIMIT for OFFSET, -1 means none
+		query.WriteString(" LIMIT -1 OFFSET ?")
+		args = append(args, q.offset)
+	case q.offset > 0:
+		query.WriteString(" OFFSET ?")
+		args = append(args, q.offset)
+	}
+	if q.lock && q.dao.DB.Dialect != db.SQLite {
+		query.WriteString(" FOR UPDATE")
+	}
+	return q.dao.q.Rebind(query.String()), args
+}
+
+// Column names are interpolated into queries, so they must be checked against the table's.
+func (q *Query[T]) check(column string) {
+	if _, ok := q.dao.md.columns[column]; !ok {
+		q.fail(apperr.Validationf("unknown column '%s' in table %s", column, q.dao.md.tableName))
+	}
+}
+
+func (q *Query[T]) fail(err error) {
+	if q.err == nil {
+		q.err = err
+	}
+}
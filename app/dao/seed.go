@@ -27,20 +27,19 @@ func SeedDB(db *db.DB) (*data, error) {
 
 	for i := 0; i < participantAmount; i++ {
 		participant := NewDummyParticipant(i)
-
-		if err := participantDao.Insert(context.Background(), *participant); err != nil {
-			return nil, fmt.Errorf("failed to insert participant into DB: %v", err)
-		}
 		participants = append(participants, *participant)
 
 		for j := 0; j < moduleAmount; j++ {
-			module := NewDummyModule(j, participant.IntraLogin)
-			if err := moduleDao.Insert(context.Background(), *module); err != nil {
-				return nil, fmt.Errorf("failed to insert module into DB: %v", err)
-			}
-			modules = append(modules, *module)
+			modules = append(modules, *NewDummyModule(j, participant.IntraLogin))
 		}
 	}
+
+	if err := participantDao.InsertMany(context.Background(), participants); err != nil {
+		return nil, fmt.Errorf("failed to insert participants into DB: %v", err)
+	}
+	if err := moduleDao.InsertMany(context.Background(), modules); err != nil {
+		return nil, fmt.Errorf("failed to insert modules into DB: %v", err)
+	}
 	return &data{participants: participants, modules: modules}, nil
 }
 
@@ -60,5 +59,6 @@ func NewDummyParticipant(id int) *Participant {
 	return &Participant{
 		IntraLogin:  intraLogin,
 		GitHubLogin: "dummy_git_" + intraLogin,
+		Status:      ParticipantActive,
 	}
 }
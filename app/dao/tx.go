@@ -0,0 +1,60 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/42-Short/shortinette/db"
+	"github.com/jmoiron/sqlx"
+)
+
+// Statements a DAO runs, implemented by both *sqlx.DB and *sqlx.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	NamedExecContext(ctx context.Context, query string, arg any) (sql.Result, error)
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+	PrepareNamedContext(ctx context.Context, query string) (*sqlx.NamedStmt, error)
+	Rebind(query string) string
+}
+
+// Transaction DAOs can be bound to, see WithTx.
+type Tx struct {
+	tx *sqlx.Tx
+}
+
+// Runs `fn` in a transaction, which is committed if `fn` returns nil and rolled back
+// otherwise. DAOs bound to the transaction with In must not be used after `fn` returns.
+func WithTx(ctx context.Context, database *db.DB, fn func(tx *Tx) error) error {
+	tx, err := database.Writer.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&Tx{tx: tx}); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Returns a copy of the DAO whose operations run in `tx`.
+func (dao *DAO[T]) In(tx *Tx) *DAO[T] {
+	bound := *dao
+	bound.q, bound.w = tx.tx, tx.tx
+	return &bound
+}
@@ -0,0 +1,118 @@
+package dao
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/42-Short/shortinette/apperr"
+	"github.com/42-Short/shortinette/config"
+)
+
+// Logins end up in repo names and URLs.
+var loginPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Problem with a single field of a record.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type FieldErrors []FieldError
+
+func (errs FieldErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, fmt.Sprintf("%s %s", err.Field, err.Message))
+	}
+	return strings.Join(messages, ", ")
+}
+
+func (errs *FieldErrors) add(field string, format string, args ...any) {
+	*errs = append(*errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Implemented by records whose fields have rules beyond their type. Some rules depend on
+// the configuration of the Short, e.g. the amount of modules.
+type Validator interface {
+	Validate(conf config.Config) FieldErrors
+}
+
+// Implemented by records with fields which get a default value when left empty, which
+// would otherwise be inserted as is instead of getting their column's DEFAULT.
+type Defaulter interface {
+	SetDefaults()
+}
+
+// Fills in the defaults of `item` if it has any, see Defaulter. `item` must be a pointer.
+func SetDefaults(item any) {
+	if defaulter, ok := item.(Defaulter); ok {
+		defaulter.SetDefaults()
+	}
+}
+
+// Checks `item` against its rules if it has any, see Validator. The returned error is of
+// kind apperr.Validation and wraps the FieldErrors.
+func Validate(item any, conf config.Config) error {
+	validator, ok := item.(Validator)
+	if !ok {
+		return nil
+	}
+	if errs := validator.Validate(conf); len(errs) > 0 {
+		return apperr.Validationf("invalid %s: %w", strings.ToLower(reflect.TypeOf(item).Name()), errs)
+	}
+	return nil
+}
+
+func (module Module) Validate(conf config.Config) FieldErrors {
+	var errs FieldErrors
+
+	if module.Id < 0 || module.Id >= len(conf.Modules) {
+		errs.add("id", "must be between 0 and %d", len(conf.Modules)-1)
+	} else if maxScore := conf.Modules[module.Id].MaxScore(); module.Score < 0 || module.Score > maxScore {
+		errs.add("score", "must be between 0 and %d", maxScore)
+	}
+	validateLogin(&errs, "intra_login", module.IntraLogin)
+	if module.Attempts < 0 {
+		errs.add("attempts", "must not be negative")
+	}
+	if module.WaitTime < 0 {
+		errs.add("wait_time", "must not be negative")
+	}
+	validateNotDeleted(&errs, module.DeletedAt)
+	return errs
+}
+
+func (participant Participant) Validate(conf config.Config) FieldErrors {
+	var errs FieldErrors
+
+	validateLogin(&errs, "intra_login", participant.IntraLogin)
+	validateLogin(&errs, "github_login", participant.GitHubLogin)
+	// Participants who passed the last module are past it
+	if participant.CurrentModuleId < 0 || participant.CurrentModuleId > len(conf.Modules) {
+		errs.add("current_module_id", "must be between 0 and %d", len(conf.Modules))
+	}
+	if !slices.Contains(ParticipantStatuses, participant.Status) {
+		errs.add("status", "must be one of %v", ParticipantStatuses)
+	}
+	validateNotDeleted(&errs, participant.DeletedAt)
+	return errs
+}
+
+func validateLogin(errs *FieldErrors, field string, login string) {
+	if login == "" {
+		errs.add(field, "must not be empty")
+	} else if !loginPattern.MatchString(login) {
+		errs.add(field, "may only contain letters, digits, '.', '_' and '-'")
+	}
+}
+
+// Records are deleted and restored through their own endpoints, see DAO.Delete.
+func validateNotDeleted(errs *FieldErrors, deletedAt *time.Time) {
+	if deletedAt != nil {
+		errs.add("deleted_at", "cannot be set, delete or restore the record instead")
+	}
+}
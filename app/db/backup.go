@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/42-Short/shortinette/logger"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	backupPrefix     = "shortinette-"
+	backupSuffix     = ".db"
+	backupTimeFormat = "20060102T150405.000Z"
+)
+
+// Which backups are kept when pruning: the newest backup of each of the last `Hourly` hours
+// and of each of the last `Daily` days which have one. The newest backup is always kept.
+type Retention struct {
+	Hourly int
+	Daily  int
+}
+
+type backupFile struct {
+	path    string
+	takenAt time.Time
+}
+
+// Copies the database into a new file in `backupDir` and checks the integrity of the copy.
+// VACUUM INTO reads a consistent snapshot without blocking writers. Returns the path of the backup.
+func (db *DB) Backup(ctx context.Context, backupDir string) (string, error) {
+	if db.Dialect != SQLite {
+		return "", fmt.Errorf("backups are only supported for SQLite, use pg_dump for %s", db.Dialect)
+	}
+
+	err := os.MkdirAll(backupDir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup directory %s: %v", backupDir, err)
+	}
+
+	path := filepath.Join(backupDir, backupPrefix+time.Now().UTC().Format(backupTimeFormat)+backupSuffix)
+	if _, err := db.Conn.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to back up DB to %s: %v", path, err)
+	}
+	if err := checkIntegrity(ctx, path); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	logger.Info.Printf("successfully created DB backup at %s\n", path)
+	return path, nil
+}
+
+// Backs the database up into `backupDir` every `interval` and prunes the backups `retention`
+// does not keep, until `ctx` is done.
+func (db *DB) StartBackupScheduler(ctx context.Context, backupDir string, interval time.Duration, retention Retention) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if _, err := db.Backup(ctx, backupDir); err != nil {
+				logger.Error.Printf("failed to backup DB: %v\n", err)
+				continue
+			}
+			if err := PruneBackups(backupDir, retention); err != nil {
+				logger.Error.Printf("failed to prune DB backups: %v\n", err)
+			}
+		}
+	}()
+}
+
+// Deletes the backups in `backupDir` which `retention` does not keep.
+func PruneBackups(backupDir string, retention Retention) error {
+	backups, err := listBackups(backupDir)
+	if err != nil {
+		return err
+	}
+
+	keep := retention.keep(backups)
+	for i, backup := range backups {
+		if keep[i] {
+			continue
+		}
+		if err := os.Remove(backup.path); err != nil {
+			return fmt.Errorf("failed to delete backup %s: %v", backup.path, err)
+		}
+		logger.Info.Printf("deleted DB backup %s\n", backup.path)
+	}
+	return nil
+}
+
+// Replaces the SQLite database at `dsn` with the backup at `backupPath`, after checking the
+// integrity of the backup. shortinette must not be running.
+func Restore(ctx context.Context, backupPath string, dsn string) error {
+	path := strings.TrimPrefix(strings.SplitN(dsn, "?", 2)[0], "file:")
+	if dialectOf(dsn) != SQLite || path == "" || strings.Contains(path, ":memory:") {
+		return fmt.Errorf("can only restore SQLite databases stored in a file, not '%s'", redact(dsn))
+	}
+	if err := checkIntegrity(ctx, backupPath); err != nil {
+		return err
+	}
+
+	// Copied next to the database first, so that a failed copy leaves the database untouched
+	restored := path + ".restore"
+	if err := copyFile(backupPath, restored); err != nil {
+		os.Remove(restored)
+		return fmt.Errorf("failed to copy backup %s: %v", backupPath, err)
+	}
+
+	// The write-ahead log belongs to the replaced database, SQLite would apply it to the backup
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			os.Remove(restored)
+			return fmt.Errorf("failed to delete %s%s: %v", path, suffix, err)
+		}
+	}
+	if err := os.Rename(restored, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", path, err)
+	}
+
+	logger.Info.Printf("restored DB %s from %s\n", path, backupPath)
+	return nil
+}
+
+func checkIntegrity(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("failed to open backup: %v", err)
+	}
+
+	conn, err := sqlx.Open(string(SQLite), "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open backup %s: %v", path, err)
+	}
+	defer conn.Close()
+
+	var results []string
+	if err := conn.SelectContext(ctx, &results, "PRAGMA integrity_check"); err != nil {
+		return fmt.Errorf("failed to check integrity of backup %s: %v", path, err)
+	}
+	if len(results) != 1 || results[0] != "ok" {
+		return fmt.Errorf("backup %s is corrupt: %s", path, strings.Join(results, "; "))
+	}
+	return nil
+}
+
+// Returns the backups in `backupDir`, newest first.
+func listBackups(backupDir string) ([]backupFile, error) {
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups in %s: %v", backupDir, err)
+	}
+
+	backups := make([]backupFile, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		takenAt, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{path: filepath.Join(backupDir, name), takenAt: takenAt})
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].takenAt.After(backups[j].takenAt) })
+	return backups, nil
+}
+
+// Returns the indexes of the `backups` (newest first) to keep.
+func (retention Retention) keep(backups []backupFile) map[int]bool {
+	keep := map[int]bool{}
+	if len(backups) > 0 {
+		keep[0] = true
+	}
+
+	byPeriod := func(periods int, period func(time.Time) time.Time) {
+		seen := map[time.Time]bool{}
+		for i, backup := range backups {
+			start := period(backup.takenAt)
+			if seen[start] {
+				continue
+			}
+			if len(seen) == periods {
+				return
+			}
+			seen[start] = true
+			keep[i] = true
+		}
+	}
+	byPeriod(retention.Hourly, func(t time.Time) time.Time { return t.Truncate(time.Hour) })
+	byPeriod(retention.Daily, func(t time.Time) time.Time {
+		year, month, day := t.Date()
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	})
+	return keep
+}
+
+func copyFile(source string, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -3,95 +3,117 @@ package db
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"time"
+	"strings"
 
 	"github.com/42-Short/shortinette/logger"
 	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// SQL flavour of a database, named after its database/sql driver.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite3"
+	Postgres Dialect = "postgres"
+)
+
+// SQLite settings of every connection: readers do not block the writer thanks to WAL, writers
+// wait for each other instead of failing with "database is locked", and foreign keys are enforced.
+const sqliteParams = "_journal_mode=WAL&_busy_timeout=10000&_foreign_keys=on"
+
 type DB struct {
 	Conn *sqlx.DB
-	dsn  string
+
+	// Connection writes go through. For SQLite, which only allows one writer at a time, it is
+	// a single connection starting transactions with BEGIN IMMEDIATE, so that concurrent writes
+	// queue up here instead of failing on lock upgrades. For PostgreSQL, it is the same as Conn.
+	Writer *sqlx.DB
+
+	Dialect Dialect
+	dsn     string
 }
 
-// Creates a new database connection using the provided DSN.
+// Creates a new database connection using the provided DSN: PostgreSQL for
+// `postgres://` URLs, a SQLite file otherwise.
 func NewDB(ctx context.Context, dsn string) (*DB, error) {
+	dialect := dialectOf(dsn)
+	if dialect == Postgres {
+		conn, err := open(ctx, dialect, dsn)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info.Println("Database successfully created.")
+		return &DB{Conn: conn, Writer: conn, Dialect: dialect, dsn: dsn}, nil
+	}
+
 	if err := os.MkdirAll(filepath.Dir(dsn), os.FileMode(0755)); err != nil {
 		return nil, fmt.Errorf("could not create directory '%s' for sqlite database: %v", filepath.Dir(dsn), err)
 	}
 
-	db, err := sqlx.Open("sqlite3", dsn)
+	writer, err := open(ctx, dialect, withParams(dsn, sqliteParams+"&_txlock=immediate"))
 	if err != nil {
-		return nil, fmt.Errorf("failed to open DB with DSN '%s': %v", dsn, err)
+		return nil, err
 	}
+	writer.SetMaxOpenConns(1)
 
-	err = db.PingContext(ctx)
+	conn, err := open(ctx, dialect, withParams(dsn, sqliteParams))
 	if err != nil {
-		db.Close()
-		return nil, fmt.Errorf("can not ping DB: %v", err)
+		writer.Close()
+		return nil, err
 	}
 
 	logger.Info.Println("Database successfully created.")
-	return &DB{db, dsn}, nil
+	return &DB{Conn: conn, Writer: writer, Dialect: dialect, dsn: dsn}, nil
 }
 
-// Sets up the necessary schema in the database
-func (db *DB) Initialize(schemaPath string) error {
-	data, err := os.ReadFile(schemaPath)
+func open(ctx context.Context, dialect Dialect, dsn string) (*sqlx.DB, error) {
+	db, err := sqlx.Open(string(dialect), dsn)
 	if err != nil {
-		return fmt.Errorf("error reading schema '%s': %v", schemaPath, err)
+		return nil, fmt.Errorf("failed to open DB with DSN '%s': %v", redact(dsn), err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-	defer cancel()
-
-	_, err = db.Conn.ExecContext(ctx, string(data))
+	err = db.PingContext(ctx)
 	if err != nil {
-		return fmt.Errorf("error creating Module schema: %v", err)
+		db.Close()
+		return nil, fmt.Errorf("can not ping DB: %v", err)
 	}
-
-	logger.Info.Println("Schema Tables successfully created.")
-	return nil
+	return db, nil
 }
 
-// creates a backup of the DB in the specified directory
-func (db *DB) Backup(backupDir string) error {
-	err := os.MkdirAll(backupDir, 0755)
-	if err != nil {
-		return fmt.Errorf("failed to create backup directory %s: %v", backupDir, err)
+func withParams(dsn string, params string) string {
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + params
 	}
+	return dsn + "?" + params
+}
 
-	backupFile := fmt.Sprintf("%s/%s-backup.db", backupDir, time.Now().Format(time.RFC3339))
-	cmd := exec.Command("sqlite3", db.dsn, fmt.Sprintf(".backup %s", backupFile))
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to execute sqlite3 backup: %v, output: %s", err, string(output))
+func dialectOf(dsn string) Dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return Postgres
 	}
-
-	logger.Info.Printf("successfully created DB backup at %s\n", backupFile)
-	return nil
+	return SQLite
 }
 
-// schedules backups at specified intervals
-func (db *DB) StartBackupScheduler(backupDir string, interval time.Duration) {
-	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			err := db.Backup(backupDir)
-			if err != nil {
-				logger.Error.Printf("failed to backup DB: %v\n", err)
-			}
-		}
-	}()
+// Hides the password of PostgreSQL URLs, which must not end up in logs.
+func redact(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return dsn
 }
 
 // Closes the database connection.
 func (db *DB) Close() error {
+	if db.Writer != db.Conn {
+		if err := db.Writer.Close(); err != nil {
+			db.Conn.Close()
+			return err
+		}
+	}
 	return db.Conn.Close()
 }
@@ -0,0 +1,292 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42-Short/shortinette/logger"
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// A schema change, read from `migrations/<dialect>/<version>_<name>.sql`. Both dialects have
+// the same versions, so that a database can be moved from one to the other.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Whether a migration has been applied to the database, and when.
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+var schemaVersionTable = map[Dialect]string{
+	SQLite: `CREATE TABLE IF NOT EXISTS schema_version (
+  version INTEGER PRIMARY KEY NOT NULL,
+  name TEXT NOT NULL,
+  applied_at DATETIME NOT NULL
+)`,
+	Postgres: `CREATE TABLE IF NOT EXISTS schema_version (
+  version INTEGER PRIMARY KEY NOT NULL,
+  name TEXT NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL
+)`,
+}
+
+// Directory of the migrations of each dialect, under migrations/.
+var dialectDirs = map[Dialect]string{
+	SQLite:   "sqlite",
+	Postgres: "postgres",
+}
+
+// SQLite databases created by schema.sql before migrations existed have no schema_version table.
+// The schema they were created with is recognized by the last table or column each migration
+// added, so that only the missing migrations are applied to them.
+var legacyMarkers = []struct {
+	table  string
+	column string
+}{
+	{"participant", ""},
+	{"webhookdelivery", ""},
+	{"token", ""},
+	{"session", ""},
+	{"registration", ""},
+	{"participant", "status"},
+	{"module", "reopened"},
+	{"finalgrade", ""},
+}
+
+// Returns the embedded migrations of `dialect`, ordered by version.
+func Migrations(dialect Dialect) ([]Migration, error) {
+	dir := path.Join("migrations", dialectDirs[dialect])
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s migrations: %v", dialect, err)
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		version, name, found := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		number, err := strconv.Atoi(version)
+		if !found || err != nil {
+			return nil, fmt.Errorf("invalid migration file name '%s': expected '<version>_<name>.sql'", entry.Name())
+		}
+
+		data, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration '%s': %v", entry.Name(), err)
+		}
+		migrations = append(migrations, Migration{Version: number, Name: name, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration versions are not contiguous: expected %d, found %d", i+1, migration.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Applies all pending migrations, each in its own transaction.
+func (db *DB) Migrate(ctx context.Context) error {
+	migrations, err := Migrations(db.Dialect)
+	if err != nil {
+		return err
+	}
+	return db.migrate(ctx, migrations)
+}
+
+func (db *DB) migrate(ctx context.Context, migrations []Migration) error {
+	if err := db.adoptLegacySchema(ctx, migrations); err != nil {
+		return err
+	}
+
+	current, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than the latest known migration %d", current, len(migrations))
+	}
+
+	for _, migration := range migrations[current:] {
+		if err := db.apply(ctx, migration); err != nil {
+			return err
+		}
+		logger.Info.Printf("applied migration %04d_%s\n", migration.Version, migration.Name)
+	}
+	return nil
+}
+
+func (db *DB) apply(ctx context.Context, migration Migration) error {
+	tx, err := db.Writer.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %04d_%s: %v", migration.Version, migration.Name, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+		return fmt.Errorf("failed to apply migration %04d_%s: %v", migration.Version, migration.Name, err)
+	}
+	if err := recordMigration(ctx, tx, migration); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %04d_%s: %v", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+// Creates the schema_version table. If the database already has tables from before migrations
+// existed, the migrations they correspond to are recorded as applied.
+func (db *DB) adoptLegacySchema(ctx context.Context, migrations []Migration) error {
+	exists, err := db.hasTable(ctx, "schema_version")
+	if err != nil || exists {
+		return err
+	}
+
+	baseline := 0
+	if db.Dialect == SQLite {
+		if baseline, err = db.legacyVersion(ctx, migrations); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Writer.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, schemaVersionTable[db.Dialect]); err != nil {
+		return fmt.Errorf("failed to create schema_version table: %v", err)
+	}
+
+	for _, migration := range migrations[:baseline] {
+		if err := recordMigration(ctx, tx, migration); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit schema_version table: %v", err)
+	}
+	if baseline > 0 {
+		logger.Info.Printf("found schema from before migrations, recorded as version %d\n", baseline)
+	}
+	return nil
+}
+
+// Returns the version of the schema created by schema.sql, 0 if there are no tables.
+func (db *DB) legacyVersion(ctx context.Context, migrations []Migration) (int, error) {
+	version := 0
+	for _, marker := range legacyMarkers[:min(len(legacyMarkers), len(migrations))] {
+		present, err := db.hasColumn(ctx, marker.table, marker.column)
+		if err != nil {
+			return 0, err
+		}
+		if !present {
+			break
+		}
+		version++
+	}
+	return version, nil
+}
+
+// Returns the version of the last applied migration, 0 if there is none.
+func (db *DB) SchemaVersion(ctx context.Context) (int, error) {
+	var version sql.NullInt64
+	if err := db.Conn.GetContext(ctx, &version, "SELECT MAX(version) FROM schema_version"); err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %v", err)
+	}
+	return int(version.Int64), nil
+}
+
+// Lists every known migration and whether it has been applied.
+func (db *DB) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	migrations, err := Migrations(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[int]time.Time{}
+	exists, err := db.hasTable(ctx, "schema_version")
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		var rows []struct {
+			Version   int       `db:"version"`
+			AppliedAt time.Time `db:"applied_at"`
+		}
+		if err := db.Conn.SelectContext(ctx, &rows, "SELECT version, applied_at FROM schema_version"); err != nil {
+			return nil, fmt.Errorf("failed to get applied migrations: %v", err)
+		}
+		for _, row := range rows {
+			applied[row.Version] = row.AppliedAt
+		}
+	}
+
+	states := make([]MigrationState, len(migrations))
+	for i, migration := range migrations {
+		states[i] = MigrationState{Migration: migration}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			states[i].AppliedAt = &appliedAt
+		}
+	}
+	return states, nil
+}
+
+func recordMigration(ctx context.Context, tx *sqlx.Tx, migration Migration) error {
+	query := tx.Rebind("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)")
+	if _, err := tx.ExecContext(ctx, query, migration.Version, migration.Name, time.Now()); err != nil {
+		return fmt.Errorf("failed to record migration %04d_%s: %v", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+func (db *DB) hasTable(ctx context.Context, table string) (bool, error) {
+	query := "SELECT count(*) FROM sqlite_master WHERE type='table' AND name=?"
+	if db.Dialect == Postgres {
+		query = "SELECT count(*) FROM information_schema.tables WHERE table_schema=current_schema() AND table_name=$1"
+	}
+
+	var count int
+	if err := db.Conn.GetContext(ctx, &count, query, table); err != nil {
+		return false, fmt.Errorf("failed to look up table '%s': %v", table, err)
+	}
+	return count > 0, nil
+}
+
+// Reports whether `table` has `column`, or just whether `table` exists if `column` is empty.
+func (db *DB) hasColumn(ctx context.Context, table string, column string) (bool, error) {
+	if column == "" {
+		return db.hasTable(ctx, table)
+	}
+
+	query := "SELECT count(*) FROM pragma_table_info(?) WHERE name=?"
+	if db.Dialect == Postgres {
+		query = "SELECT count(*) FROM information_schema.columns WHERE table_schema=current_schema() AND table_name=$1 AND column_name=$2"
+	}
+
+	var count int
+	if err := db.Conn.GetContext(ctx, &count, query, table, column); err != nil {
+		return false, fmt.Errorf("failed to look up column '%s.%s': %v", table, column, err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS registration (
+  intra_login TEXT PRIMARY KEY NOT NULL,
+  github_login TEXT NOT NULL,
+  status TEXT NOT NULL,
+  verification TEXT NOT NULL DEFAULT '',
+  challenge TEXT NOT NULL DEFAULT '',
+  remote_addr TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL,
+  registered_at TIMESTAMPTZ
+);
@@ -0,0 +1 @@
+ALTER TABLE participant ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
@@ -0,0 +1,2 @@
+ALTER TABLE module ADD COLUMN closed_at TIMESTAMPTZ;
+ALTER TABLE module ADD COLUMN reopened BOOLEAN NOT NULL DEFAULT FALSE;
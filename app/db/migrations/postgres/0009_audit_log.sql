@@ -0,0 +1,25 @@
+-- Append-only record of who changed what, kept even if the target is deleted
+CREATE TABLE IF NOT EXISTS auditentry (
+  id TEXT PRIMARY KEY NOT NULL,
+  actor TEXT NOT NULL,
+  action TEXT NOT NULL,
+  target TEXT NOT NULL,
+  before_value TEXT NOT NULL DEFAULT '',
+  after_value TEXT NOT NULL DEFAULT '',
+  request_id TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS auditentry_created_at ON auditentry (created_at);
+
+CREATE OR REPLACE FUNCTION auditentry_immutable() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit entries are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER auditentry_no_update BEFORE UPDATE ON auditentry
+  FOR EACH ROW EXECUTE FUNCTION auditentry_immutable();
+
+CREATE TRIGGER auditentry_no_delete BEFORE DELETE ON auditentry
+  FOR EACH ROW EXECUTE FUNCTION auditentry_immutable();
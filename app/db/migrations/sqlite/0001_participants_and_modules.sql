@@ -1,12 +1,10 @@
-PRAGMA foreign_keys = ON;
-
 CREATE TABLE IF NOT EXISTS participant (
   intra_login TEXT PRIMARY KEY NOT NULL UNIQUE,
   github_login TEXT NOT NULL UNIQUE,
   current_module_id INTEGER DEFAULT 0
 );
 
-CREATE TABLE IF NOT EXISTS  module(
+CREATE TABLE IF NOT EXISTS module (
   id INTEGER NOT NULL,
   intra_login TEXT NOT NULL,
   attempts INTEGER DEFAULT 0,
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS webhookdelivery (
+  delivery_id TEXT PRIMARY KEY NOT NULL,
+  event TEXT NOT NULL,
+  repository TEXT NOT NULL DEFAULT '',
+  received_at DATETIME NOT NULL,
+  outcome TEXT NOT NULL DEFAULT ''
+);
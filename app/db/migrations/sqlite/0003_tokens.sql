@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS token (
+  id TEXT PRIMARY KEY NOT NULL,
+  name TEXT NOT NULL,
+  hash TEXT NOT NULL UNIQUE,
+  scope TEXT NOT NULL,
+  intra_login TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL,
+  revoked_at DATETIME
+);
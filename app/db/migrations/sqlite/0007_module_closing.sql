@@ -0,0 +1,2 @@
+ALTER TABLE module ADD COLUMN closed_at DATETIME;
+ALTER TABLE module ADD COLUMN reopened BOOLEAN NOT NULL DEFAULT 0;
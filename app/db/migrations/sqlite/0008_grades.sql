@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS attempt (
+  id TEXT PRIMARY KEY NOT NULL,
+  module_id INTEGER NOT NULL,
+  intra_login TEXT NOT NULL,
+  score INTEGER NOT NULL DEFAULT 0,
+  passed BOOLEAN NOT NULL DEFAULT 0,
+  commit_sha TEXT NOT NULL DEFAULT '',
+  trace_ref TEXT NOT NULL DEFAULT '',
+  graded_at DATETIME NOT NULL,
+  FOREIGN KEY (intra_login) REFERENCES participant(intra_login) ON DELETE CASCADE
+);
+
+-- Official results, kept even if the participant is deleted
+CREATE TABLE IF NOT EXISTS finalgrade (
+  module_id INTEGER NOT NULL,
+  intra_login TEXT NOT NULL,
+  score INTEGER NOT NULL,
+  passed BOOLEAN NOT NULL,
+  attempt_id TEXT NOT NULL DEFAULT '',
+  commit_sha TEXT NOT NULL DEFAULT '',
+  trace_ref TEXT NOT NULL DEFAULT '',
+  rule TEXT NOT NULL,
+  frozen_at DATETIME NOT NULL,
+  PRIMARY KEY (module_id, intra_login)
+);
+
+CREATE TRIGGER IF NOT EXISTS finalgrade_no_update BEFORE UPDATE ON finalgrade
+BEGIN
+  SELECT RAISE(ABORT, 'final grades are immutable');
+END;
+
+CREATE TRIGGER IF NOT EXISTS finalgrade_no_delete BEFORE DELETE ON finalgrade
+BEGIN
+  SELECT RAISE(ABORT, 'final grades are immutable');
+END;
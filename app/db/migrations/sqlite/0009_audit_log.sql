@@ -0,0 +1,23 @@
+-- Append-only record of who changed what, kept even if the target is deleted
+CREATE TABLE IF NOT EXISTS auditentry (
+  id TEXT PRIMARY KEY NOT NULL,
+  actor TEXT NOT NULL,
+  action TEXT NOT NULL,
+  target TEXT NOT NULL,
+  before_value TEXT NOT NULL DEFAULT '',
+  after_value TEXT NOT NULL DEFAULT '',
+  request_id TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS auditentry_created_at ON auditentry (created_at);
+
+CREATE TRIGGER IF NOT EXISTS auditentry_no_update BEFORE UPDATE ON auditentry
+BEGIN
+  SELECT RAISE(ABORT, 'audit entries are immutable');
+END;
+
+CREATE TRIGGER IF NOT EXISTS auditentry_no_delete BEFORE DELETE ON auditentry
+BEGIN
+  SELECT RAISE(ABORT, 'audit entries are immutable');
+END;
@@ -0,0 +1,3 @@
+-- Deleted participants and modules are kept until they are purged, so that deletions can be undone
+ALTER TABLE participant ADD COLUMN deleted_at DATETIME;
+ALTER TABLE module ADD COLUMN deleted_at DATETIME;
@@ -0,0 +1,59 @@
+-- A database created by schema.sql before participant.status, module.closed_at and
+-- module.reopened were added, and before grades were recorded.
+CREATE TABLE participant (
+  intra_login TEXT PRIMARY KEY NOT NULL UNIQUE,
+  github_login TEXT NOT NULL UNIQUE,
+  current_module_id INTEGER DEFAULT 0
+);
+
+CREATE TABLE  module(
+  id INTEGER NOT NULL,
+  intra_login TEXT NOT NULL,
+  attempts INTEGER DEFAULT 0,
+  score INTEGER DEFAULT 0,
+  last_graded DATETIME,
+  wait_time INTEGER DEFAULT 0,
+  PRIMARY KEY (id, intra_login),
+  FOREIGN KEY (intra_login) REFERENCES participant(intra_login) ON DELETE CASCADE
+);
+
+CREATE TABLE webhookdelivery (
+  delivery_id TEXT PRIMARY KEY NOT NULL,
+  event TEXT NOT NULL,
+  repository TEXT NOT NULL DEFAULT '',
+  received_at DATETIME NOT NULL,
+  outcome TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE token (
+  id TEXT PRIMARY KEY NOT NULL,
+  name TEXT NOT NULL,
+  hash TEXT NOT NULL UNIQUE,
+  scope TEXT NOT NULL,
+  intra_login TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL,
+  revoked_at DATETIME
+);
+
+CREATE TABLE session (
+  id TEXT PRIMARY KEY NOT NULL,
+  intra_login TEXT NOT NULL,
+  scope TEXT NOT NULL,
+  created_at DATETIME NOT NULL,
+  expires_at DATETIME NOT NULL
+);
+
+CREATE TABLE registration (
+  intra_login TEXT PRIMARY KEY NOT NULL,
+  github_login TEXT NOT NULL,
+  status TEXT NOT NULL,
+  verification TEXT NOT NULL DEFAULT '',
+  challenge TEXT NOT NULL DEFAULT '',
+  remote_addr TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL,
+  registered_at DATETIME
+);
+
+INSERT INTO participant (intra_login, github_login, current_module_id) VALUES ('old_participant', 'old-github', 1);
+INSERT INTO module (id, intra_login, attempts, score, wait_time) VALUES (0, 'old_participant', 3, 100, 0);
+INSERT INTO module (id, intra_login, attempts, score, wait_time) VALUES (1, 'old_participant', 1, 40, 60);
@@ -0,0 +1,82 @@
+package git
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Returns a client talking to a fake GitHub serving `user` and their `gists`.
+func newFakeAccountClient(t *testing.T, user *github.User, gists []*github.Gist) *github.Client {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/"+user.GetLogin(), func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(user)
+	})
+	mux.HandleFunc("/users/"+user.GetLogin()+"/gists", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(gists)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+	return client
+}
+
+func TestDoesAccountExistOrganisation(t *testing.T) {
+	client := newFakeAccountClient(t, &github.User{Login: github.String("42-Short"), Type: github.String("Organization")}, nil)
+
+	found, err := doesAccountExist(context.Background(), client, "42-Short")
+	require.NoError(t, err)
+	assert.False(t, found, "organisations are not valid participant accounts")
+
+	found, err = doesAccountExist(context.Background(), client, "nobody")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestHasGistWithDescription(t *testing.T) {
+	gists := []*github.Gist{
+		{Description: github.String("shortinette-private"), Public: github.Bool(false)},
+		{Description: github.String("shortinette-public\n"), Public: github.Bool(true)},
+	}
+	client := newFakeAccountClient(t, &github.User{Login: github.String("jdoe"), Type: github.String("User")}, gists)
+
+	found, err := hasGistWithDescription(context.Background(), client, "jdoe", "shortinette-public")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	found, err = hasGistWithDescription(context.Background(), client, "jdoe", "shortinette-private")
+	require.NoError(t, err)
+	assert.False(t, found, "only public gists should count")
+}
+
+func TestAccountLookupsAuthenticated(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		_ = json.NewEncoder(w).Encode(&github.User{Login: github.String("jdoe"), Type: github.String("User")})
+	}))
+	t.Cleanup(server.Close)
+
+	gh := NewGithubService("pat", "orga", "")
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	gh.Client.BaseURL = baseURL
+
+	found, err := gh.DoesAccountExist(context.Background(), "jdoe")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "Bearer pat", authorization, "lookups should not be subject to the unauthenticated rate limit")
+}
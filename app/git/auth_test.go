@@ -0,0 +1,178 @@
+package git
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPrivateKey(t *testing.T) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return key, keyPEM
+}
+
+// Stands in for GitHub's installation token endpoint, counting how many tokens were minted.
+func newInstallationTokenServer(t *testing.T, key *rsa.PrivateKey, expiresIn time.Duration) (*httptest.Server, *int) {
+	t.Helper()
+
+	minted := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/app/installations/42/access_tokens" {
+			http.NotFound(w, r)
+			return
+		}
+
+		jwt := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if err := verifyJWT(jwt, &key.PublicKey); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		minted++
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"token":      fmt.Sprintf("installation-token-%d", minted),
+			"expires_at": time.Now().Add(expiresIn).Format(time.RFC3339),
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &minted
+}
+
+func verifyJWT(jwt string, key *rsa.PublicKey) error {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed JWT")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+}
+
+func newTestAppTokenSource(t *testing.T, key *rsa.PrivateKey, server *httptest.Server) *appTokenSource {
+	t.Helper()
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	return &appTokenSource{appID: 1, installationID: 42, privateKey: key, client: client}
+}
+
+func TestAppTokenSourceSignJWT(t *testing.T) {
+	key, _ := newTestPrivateKey(t)
+	source := &appTokenSource{appID: 1234, privateKey: key}
+
+	now := time.Now()
+	jwt, err := source.signJWT(now)
+	require.NoError(t, err)
+	require.NoError(t, verifyJWT(jwt, &key.PublicKey))
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.Split(jwt, ".")[1])
+	require.NoError(t, err)
+
+	var claims struct {
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+		Iss string `json:"iss"`
+	}
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	assert.Equal(t, "1234", claims.Iss)
+	assert.Less(t, claims.Iat, now.Unix(), "iat should be backdated")
+	assert.LessOrEqual(t, claims.Exp, now.Add(10*time.Minute).Unix(), "GitHub rejects JWTs valid for more than 10 minutes")
+}
+
+func TestAppTokenSourceCachesToken(t *testing.T) {
+	key, _ := newTestPrivateKey(t)
+	server, minted := newInstallationTokenServer(t, key, time.Hour)
+	source := newTestAppTokenSource(t, key, server)
+
+	first, err := source.Token(context.Background())
+	require.NoError(t, err)
+	second, err := source.Token(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, "installation-token-1", first)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, *minted, "a valid installation token should be reused")
+}
+
+func TestAppTokenSourceRefreshesExpiringToken(t *testing.T) {
+	key, _ := newTestPrivateKey(t)
+	server, minted := newInstallationTokenServer(t, key, installationTokenRefreshMargin-time.Minute)
+	source := newTestAppTokenSource(t, key, server)
+
+	first, err := source.Token(context.Background())
+	require.NoError(t, err)
+	second, err := source.Token(context.Background())
+	require.NoError(t, err)
+
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, 2, *minted, "an installation token about to expire should be refreshed")
+}
+
+func TestNewAppTokenSourceKeyRotation(t *testing.T) {
+	_, keyPEM := newTestPrivateKey(t)
+	_, rotatedPEM := newTestPrivateKey(t)
+
+	source, err := newAppTokenSource(1, 4242, keyPEM)
+	require.NoError(t, err)
+	cached, err := newAppTokenSource(1, 4242, keyPEM)
+	require.NoError(t, err)
+	assert.Same(t, source, cached, "token sources should be shared")
+
+	rotated, err := newAppTokenSource(1, 4242, rotatedPEM)
+	require.NoError(t, err)
+	assert.NotSame(t, source, rotated, "a rotated key should not keep using the previous one")
+	current, err := newAppTokenSource(1, 4242, rotatedPEM)
+	require.NoError(t, err)
+	assert.Same(t, rotated, current)
+}
+
+func TestParsePrivateKeyPKCS8(t *testing.T) {
+	key, _ := newTestPrivateKey(t)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	parsed, err := parsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	require.NoError(t, err)
+	assert.True(t, key.Equal(parsed))
+}
+
+func TestParsePrivateKeyNotPEM(t *testing.T) {
+	_, err := parsePrivateKey([]byte("definitely not a key"))
+	require.Error(t, err)
+}
+
+func TestNewGithubAppServiceInvalidKey(t *testing.T) {
+	if _, err := NewGithubAppService(1, 42, []byte("definitely not a key"), orga, basePath); err == nil {
+		t.Fatalf("NewGithubAppService should fail on an invalid private key")
+	}
+}
@@ -0,0 +1,126 @@
+package git
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveCollaboratorDeletesPendingInvitation(t *testing.T) {
+	var removed, deletedInvitation bool
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /repos/orga/jdoe-00/collaborators/jdoe-gh", func(w http.ResponseWriter, r *http.Request) {
+		removed = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /repos/orga/jdoe-00/invitations", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]*github.RepositoryInvitation{
+			{ID: github.Int64(1), Invitee: &github.User{Login: github.String("someone-else")}},
+			{ID: github.Int64(2), Invitee: &github.User{Login: github.String("JDoe-GH")}},
+		})
+	})
+	mux.HandleFunc("DELETE /repos/orga/jdoe-00/invitations/2", func(w http.ResponseWriter, r *http.Request) {
+		deletedInvitation = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	gh := NewGithubService("token", "orga", basePath)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	gh.Client.BaseURL = baseURL
+
+	require.NoError(t, gh.RemoveCollaborator("jdoe-00", "jdoe-gh"))
+	assert.True(t, removed, "collaborator should be removed")
+	assert.True(t, deletedInvitation, "pending invitation should be deleted")
+}
+
+func TestSetCollaboratorPermissionUpdatesPendingInvitation(t *testing.T) {
+	var updatedPermission string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/orga/jdoe-00/invitations", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]*github.RepositoryInvitation{
+			{ID: github.Int64(2), Invitee: &github.User{Login: github.String("jdoe-gh")}},
+		})
+	})
+	mux.HandleFunc("PATCH /repos/orga/jdoe-00/invitations/2", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Permissions string `json:"permissions"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		updatedPermission = body.Permissions
+		_ = json.NewEncoder(w).Encode(github.RepositoryInvitation{ID: github.Int64(2)})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	gh := NewGithubService("token", "orga", basePath)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	gh.Client.BaseURL = baseURL
+
+	require.NoError(t, gh.SetCollaboratorPermission("jdoe-00", "jdoe-gh", "pull"))
+	assert.Equal(t, "read", updatedPermission)
+}
+
+func TestSetCollaboratorPermissionUpdatesCollaborator(t *testing.T) {
+	var updatedPermission string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/orga/jdoe-00/collaborators/jdoe-gh", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("PUT /repos/orga/jdoe-00/collaborators/jdoe-gh", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Permission string `json:"permission"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		updatedPermission = body.Permission
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	gh := NewGithubService("token", "orga", basePath)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	gh.Client.BaseURL = baseURL
+
+	require.NoError(t, gh.SetCollaboratorPermission("jdoe-00", "jdoe-gh", "pull"))
+	assert.Equal(t, "pull", updatedPermission)
+}
+
+func TestSetCollaboratorPermissionDoesNotInviteRemovedUser(t *testing.T) {
+	invited := false
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/orga/jdoe-00/invitations", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]*github.RepositoryInvitation{})
+	})
+	mux.HandleFunc("GET /repos/orga/jdoe-00/collaborators/jdoe-gh", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("PUT /repos/orga/jdoe-00/collaborators/jdoe-gh", func(w http.ResponseWriter, r *http.Request) {
+		invited = true
+		w.WriteHeader(http.StatusCreated)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	gh := NewGithubService("token", "orga", basePath)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	gh.Client.BaseURL = baseURL
+
+	require.NoError(t, gh.SetCollaboratorPermission("jdoe-00", "jdoe-gh", "pull"))
+	assert.False(t, invited, "users who are neither collaborator nor invited should not be invited")
+}
@@ -0,0 +1,34 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeadCommit(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GIT_AUTHOR_NAME", "shortinette")
+	t.Setenv("GIT_AUTHOR_EMAIL", "shortinette@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "shortinette")
+	t.Setenv("GIT_COMMITTER_EMAIL", "shortinette@example.com")
+
+	require.NoError(t, exec.Command("git", "init", "-q", dir).Run())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hello.rs"), []byte("fn main() {}\n"), 0644))
+	require.NoError(t, add(dir))
+	require.NoError(t, commit(dir, "grademe"))
+
+	sha, err := HeadCommit(dir)
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{40}$`), sha)
+}
+
+func TestHeadCommitNotARepo(t *testing.T) {
+	_, err := HeadCommit(t.TempDir())
+	assert.Error(t, err)
+}
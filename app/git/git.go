@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/42-Short/shortinette/config"
 	"github.com/42-Short/shortinette/logger"
 	"github.com/google/go-github/v66/github"
 )
@@ -20,17 +21,49 @@ import (
 type GithubService struct {
 	Client   *github.Client
 	Orga     string
-	Token    string
 	BasePath string
+
+	auth tokenSource
 }
 
+// Authenticates with a personal access token, which is used for both API calls and git operations.
 func NewGithubService(authToken string, orga string, basePath string) *GithubService {
 	return &GithubService{
 		Client:   github.NewClient(nil).WithAuthToken(authToken),
 		Orga:     orga,
-		Token:    authToken,
 		BasePath: basePath,
+		auth:     &staticTokenSource{token: authToken},
+	}
+}
+
+// Authenticates as the installation `installationID` of the GitHub App `appID`. Installation
+// tokens are short-lived and refreshed automatically, for both API calls and git operations.
+func NewGithubAppService(appID int64, installationID int64, privateKeyPEM []byte, orga string, basePath string) (*GithubService, error) {
+	source, err := newAppTokenSource(appID, installationID, privateKeyPEM)
+	if err != nil {
+		return nil, err
 	}
+
+	return &GithubService{
+		Client:   newClient(source),
+		Orga:     orga,
+		BasePath: basePath,
+		auth:     source,
+	}, nil
+}
+
+// Picks the authentication mode configured in `conf`: the GitHub App if one is set up,
+// the personal access token otherwise.
+func NewGithubServiceFromConfig(conf config.Config, basePath string) (*GithubService, error) {
+	if !conf.UsesGithubApp() {
+		return NewGithubService(conf.TokenGithub, conf.OrgaGithub, basePath), nil
+	}
+
+	privateKey, err := os.ReadFile(conf.GithubAppPrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read GitHub App private key '%s': %v", conf.GithubAppPrivateKeyPath, err)
+	}
+	return NewGithubAppService(conf.GithubAppID, conf.GithubAppInstallationID, privateKey, conf.OrgaGithub, basePath)
 }
 
 func (gh *GithubService) deleteRepo(name string) (err error) {
@@ -108,6 +141,87 @@ func (gh *GithubService) AddCollaborator(repoName string, collaboratorName strin
 	return nil
 }
 
+// Removes collaborator `collaboratorName` from repo `repoName`, along with their pending
+// invitation if they have not accepted it yet.
+func (gh *GithubService) RemoveCollaborator(repoName string, collaboratorName string) (err error) {
+	ctx := context.Background()
+
+	if _, err := gh.Client.Repositories.RemoveCollaborator(ctx, gh.Orga, repoName, collaboratorName); err != nil {
+		return fmt.Errorf("could not remove collaborator %s from repo %s: %v", collaboratorName, repoName, err)
+	}
+
+	invitations, _, err := gh.Client.Repositories.ListInvitations(ctx, gh.Orga, repoName, nil)
+	if err != nil {
+		return fmt.Errorf("could not list invitations of repo %s: %v", repoName, err)
+	}
+	for _, invitation := range invitations {
+		if !strings.EqualFold(invitation.GetInvitee().GetLogin(), collaboratorName) {
+			continue
+		}
+		if _, err := gh.Client.Repositories.DeleteInvitation(ctx, gh.Orga, repoName, invitation.GetID()); err != nil {
+			return fmt.Errorf("could not delete invitation of %s to repo %s: %v", collaboratorName, repoName, err)
+		}
+	}
+
+	logger.Info.Printf("user %s removed from repo %s\n", collaboratorName, repoName)
+	return nil
+}
+
+// Sets the access level of collaborator `collaboratorName` on repo `repoName` to `permission`
+// ('pull', 'push', ...), including on their pending invitation if they have not accepted it yet.
+// Does nothing for users who are neither a collaborator nor invited, e.g. because their access
+// was revoked, so that they are never invited back.
+func (gh *GithubService) SetCollaboratorPermission(repoName string, collaboratorName string, permission string) (err error) {
+	ctx := context.Background()
+
+	isCollaborator, _, err := gh.Client.Repositories.IsCollaborator(ctx, gh.Orga, repoName, collaboratorName)
+	if err != nil {
+		return fmt.Errorf("could not check whether %s is a collaborator of repo %s: %v", collaboratorName, repoName, err)
+	}
+	if isCollaborator {
+		// Changes the access level of existing collaborators without inviting them again
+		options := &github.RepositoryAddCollaboratorOptions{Permission: permission}
+		if _, _, err := gh.Client.Repositories.AddCollaborator(ctx, gh.Orga, repoName, collaboratorName, options); err != nil {
+			return fmt.Errorf("could not set access of %s to repo %s: %v", collaboratorName, repoName, err)
+		}
+		logger.Info.Printf("user %s set to %s access on repo %s\n", collaboratorName, permission, repoName)
+		return nil
+	}
+
+	invitations, _, err := gh.Client.Repositories.ListInvitations(ctx, gh.Orga, repoName, nil)
+	if err != nil {
+		return fmt.Errorf("could not list invitations of repo %s: %v", repoName, err)
+	}
+	for _, invitation := range invitations {
+		if !strings.EqualFold(invitation.GetInvitee().GetLogin(), collaboratorName) {
+			continue
+		}
+		// Invitations use different names for the same access levels
+		invitationPermission := map[string]string{"pull": "read", "push": "write"}[permission]
+		if invitationPermission == "" {
+			invitationPermission = permission
+		}
+		if _, _, err := gh.Client.Repositories.UpdateInvitation(ctx, gh.Orga, repoName, invitation.GetID(), invitationPermission); err != nil {
+			return fmt.Errorf("could not update invitation of %s to repo %s: %v", collaboratorName, repoName, err)
+		}
+		logger.Info.Printf("invitation of %s to repo %s set to %s access\n", collaboratorName, repoName, invitationPermission)
+		return nil
+	}
+
+	logger.Warning.Printf("%s is neither a collaborator nor invited to repo %s, access left unchanged\n", collaboratorName, repoName)
+	return nil
+}
+
+// Archives repo `name`, making it read-only for everyone, or unarchives it.
+func (gh *GithubService) SetArchived(name string, archived bool) (err error) {
+	if _, _, err := gh.Client.Repositories.Edit(context.Background(), gh.Orga, name, &github.Repository{Archived: &archived}); err != nil {
+		return fmt.Errorf("could not set archived=%t on repo %s: %v", archived, name, err)
+	}
+
+	logger.Info.Printf("repo %s archived=%t\n", name, archived)
+	return nil
+}
+
 // Clones repo `name` (from the GitHub organisation).
 // Does nothing if the directory is cloned already.
 func (gh *GithubService) Clone(name string) (err error) {
@@ -116,7 +230,10 @@ func (gh *GithubService) Clone(name string) (err error) {
 		return nil
 	}
 
-	cloneURL := fmt.Sprintf("https://%s@github.com/%s/%s.git", gh.Token, gh.Orga, name)
+	cloneURL, err := gh.remoteURL(name)
+	if err != nil {
+		return fmt.Errorf("could not clone '%s': %v", name, err)
+	}
 
 	cmd := exec.Command("git", "clone", cloneURL)
 	cmd.Stdout = os.Stdout
@@ -130,6 +247,34 @@ func (gh *GithubService) Clone(name string) (err error) {
 	return nil
 }
 
+// Builds the authenticated HTTPS URL of repo `name`.
+// The 'x-access-token' user works for both personal access tokens and installation tokens.
+func (gh *GithubService) remoteURL(name string) (url string, err error) {
+	token, err := gh.auth.Token(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("https://x-access-token:%s@github.com/%s/%s.git", token, gh.Orga, name), nil
+}
+
+// Installation tokens expire after an hour, so the one embedded in the remote at clone time
+// might not be valid anymore by the time we push.
+func (gh *GithubService) refreshRemote(name string) (err error) {
+	url, err := gh.remoteURL(name)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("git", "remote", "set-url", "origin", url)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Dir = name
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("git remote set-url: %v", err)
+	}
+	return nil
+}
+
 func add(dir string) (err error) {
 	cmd := exec.Command("git", "add", ".")
 	cmd.Stdout = os.Stdout
@@ -154,6 +299,18 @@ func commit(dir string, commitMessage string) (err error) {
 	return nil
 }
 
+// Returns the SHA of the commit checked out in `dir`.
+func HeadCommit(dir string) (sha string, err error) {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Stderr = os.Stderr
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse: %v", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
 func push(dir string) (err error) {
 	cmd := exec.Command("git", "push")
 	cmd.Stdout = os.Stdout
@@ -279,6 +436,9 @@ func (gh *GithubService) NewBranch(repoName string, branch string) (err error) {
 	if err := gh.Clone(repoName); err != nil {
 		return fmt.Errorf("could not create branch '%s' on repo '%s': %v", branch, repoName, err)
 	}
+	if err := gh.refreshRemote(repoName); err != nil {
+		return fmt.Errorf("could not create branch '%s' on repo '%s': %v", branch, repoName, err)
+	}
 	if err := checkout(repoName, branch, true); err != nil {
 		return fmt.Errorf("could not create branch '%s' on repo '%s': %v", branch, repoName, err)
 	}
@@ -301,6 +461,10 @@ func (gh *GithubService) UploadFiles(repoName string, commitMessage string, bran
 		}
 	}()
 
+	if err = gh.refreshRemote(repoName); err != nil {
+		return fmt.Errorf("could not upload files to '%s': %v", repoName, err)
+	}
+
 	if err = checkout(repoName, branch, createBranch); err != nil {
 		return fmt.Errorf("could not upload files to '%s': %v", repoName, err)
 	}
@@ -351,20 +515,48 @@ func (gh *GithubService) NewRelease(repoName string, tagName string, releaseName
 // returns a bool indicating if the Account exists
 //
 // WARNING: Returns an error when the github api request was not successful
-func DoesAccountExist(username string) (bool, error) {
-	client := github.NewClient(nil)
+func (gh *GithubService) DoesAccountExist(ctx context.Context, username string) (bool, error) {
+	return doesAccountExist(ctx, gh.Client, username)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func doesAccountExist(ctx context.Context, client *github.Client, username string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	user, response, err := client.Users.Get(ctx, username)
 	if err != nil {
-		if response.StatusCode == http.StatusNotFound {
+		if response != nil && response.StatusCode == http.StatusNotFound {
 			return false, nil
 		}
 		return false, fmt.Errorf("github API error for username '%s': %v", username, err)
 	}
-	return *user.Type == "User", nil
+	return user.GetType() == "User", nil
+}
+
+// HasGistWithDescription checks if `username` owns a public gist with `description`.
+// Asking someone to create a gist with a random description proves they control the account.
+//
+// WARNING: Returns an error when the github api request was not successful
+func (gh *GithubService) HasGistWithDescription(ctx context.Context, username string, description string) (bool, error) {
+	return hasGistWithDescription(ctx, gh.Client, username, description)
+}
+
+func hasGistWithDescription(ctx context.Context, client *github.Client, username string, description string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// The gist is expected to have been created moments ago, so the first page is enough
+	gists, _, err := client.Gists.List(ctx, username, &github.GistListOptions{ListOptions: github.ListOptions{PerPage: 30}})
+	if err != nil {
+		return false, fmt.Errorf("could not list gists of '%s': %v", username, err)
+	}
+
+	for _, gist := range gists {
+		if gist.GetPublic() && strings.TrimSpace(gist.GetDescription()) == description {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 func (gh *GithubService) CreateModuleTemplate(module int) (templateName string, err error) {
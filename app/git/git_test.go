@@ -296,13 +296,15 @@ func TestNewReleaseNonExistingrepo(t *testing.T) {
 }
 
 func TestDoesAccountExistNonExisting(t *testing.T) {
-	found, err := DoesAccountExist("thisuserdoesnotexist_42424242424242424242424000")
+	gh := NewGithubService(token, orga, basePath)
+	found, err := gh.DoesAccountExist(context.Background(), "thisuserdoesnotexist_42424242424242424242424000")
 	require.NoError(t, err)
 	assert.Equal(t, found, false, "DoesAccountExist returned true on an invalid user")
 }
 
 func TestDoesAccountExistExisting(t *testing.T) {
-	found, err := DoesAccountExist("winstonallo")
+	gh := NewGithubService(token, orga, basePath)
+	found, err := gh.DoesAccountExist(context.Background(), "winstonallo")
 	require.NoError(t, err)
 	assert.Equal(t, found, true, "DoesAccountExist returned false on a valid user")
 }
@@ -0,0 +1,206 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/42-Short/shortinette/logger"
+	"github.com/google/go-github/v66/github"
+)
+
+// Events shortinette needs to be notified about. Gradings are triggered by pushes only.
+var webhookEvents = []string{"push"}
+
+// Abstracts over organisation and repository webhooks, which have the exact same
+// API apart from the owner they are attached to.
+type hookService struct {
+	owner  string
+	list   func(ctx context.Context, opts *github.ListOptions) ([]*github.Hook, *github.Response, error)
+	create func(ctx context.Context, hook *github.Hook) (*github.Hook, *github.Response, error)
+	edit   func(ctx context.Context, id int64, hook *github.Hook) (*github.Hook, *github.Response, error)
+	delete func(ctx context.Context, id int64) (*github.Response, error)
+}
+
+func (gh *GithubService) orgHooks() hookService {
+	return hookService{
+		owner: gh.Orga,
+		list: func(ctx context.Context, opts *github.ListOptions) ([]*github.Hook, *github.Response, error) {
+			return gh.Client.Organizations.ListHooks(ctx, gh.Orga, opts)
+		},
+		create: func(ctx context.Context, hook *github.Hook) (*github.Hook, *github.Response, error) {
+			return gh.Client.Organizations.CreateHook(ctx, gh.Orga, hook)
+		},
+		edit: func(ctx context.Context, id int64, hook *github.Hook) (*github.Hook, *github.Response, error) {
+			return gh.Client.Organizations.EditHook(ctx, gh.Orga, id, hook)
+		},
+		delete: func(ctx context.Context, id int64) (*github.Response, error) {
+			return gh.Client.Organizations.DeleteHook(ctx, gh.Orga, id)
+		},
+	}
+}
+
+func (gh *GithubService) repoHooks(repoName string) hookService {
+	return hookService{
+		owner: fmt.Sprintf("%s/%s", gh.Orga, repoName),
+		list: func(ctx context.Context, opts *github.ListOptions) ([]*github.Hook, *github.Response, error) {
+			return gh.Client.Repositories.ListHooks(ctx, gh.Orga, repoName, opts)
+		},
+		create: func(ctx context.Context, hook *github.Hook) (*github.Hook, *github.Response, error) {
+			return gh.Client.Repositories.CreateHook(ctx, gh.Orga, repoName, hook)
+		},
+		edit: func(ctx context.Context, id int64, hook *github.Hook) (*github.Hook, *github.Response, error) {
+			return gh.Client.Repositories.EditHook(ctx, gh.Orga, repoName, id, hook)
+		},
+		delete: func(ctx context.Context, id int64) (*github.Response, error) {
+			return gh.Client.Repositories.DeleteHook(ctx, gh.Orga, repoName, id)
+		},
+	}
+}
+
+// Creates the organisation webhook delivering push events to `hookURL`, signed with `secret`.
+// If a webhook pointing at `hookURL` already exists, mismatches in its settings are reported
+// and fixed.
+func (gh *GithubService) EnsureOrgWebhook(hookURL string, secret string) (err error) {
+	return gh.ensureWebhook(gh.orgHooks(), hookURL, secret)
+}
+
+// Same as EnsureOrgWebhook, for the single repo `repoName`.
+func (gh *GithubService) EnsureRepoWebhook(repoName string, hookURL string, secret string) (err error) {
+	return gh.ensureWebhook(gh.repoHooks(repoName), hookURL, secret)
+}
+
+// Deletes the organisation webhook pointing at `hookURL`. Does nothing if there is none.
+func (gh *GithubService) DeleteOrgWebhook(hookURL string) (err error) {
+	return gh.deleteWebhook(gh.orgHooks(), hookURL)
+}
+
+// Same as DeleteOrgWebhook, for the single repo `repoName`.
+func (gh *GithubService) DeleteRepoWebhook(repoName string, hookURL string) (err error) {
+	return gh.deleteWebhook(gh.repoHooks(repoName), hookURL)
+}
+
+func (gh *GithubService) ensureWebhook(hooks hookService, hookURL string, secret string) (err error) {
+	if err := validateWebhookURL(hookURL); err != nil {
+		return err
+	}
+
+	existing, err := findWebhook(hooks, hookURL)
+	if err != nil {
+		return err
+	}
+
+	desired := newWebhook(hookURL, secret)
+
+	if existing == nil {
+		if _, _, err := hooks.create(context.Background(), desired); err != nil {
+			return fmt.Errorf("could not create webhook for '%s' on %s: %v", hookURL, hooks.owner, err)
+		}
+		logger.Info.Printf("webhook for '%s' created on %s\n", hookURL, hooks.owner)
+		return nil
+	}
+
+	if mismatches := webhookMismatches(existing); len(mismatches) > 0 {
+		logger.Warning.Printf("webhook %d on %s does not match the expected configuration (%s), fixing it\n", existing.GetID(), hooks.owner, strings.Join(mismatches, ", "))
+	}
+
+	// GitHub never returns the secret, so we cannot tell whether it is out of date.
+	// Always setting it also takes care of secret rotations.
+	if _, _, err := hooks.edit(context.Background(), existing.GetID(), desired); err != nil {
+		return fmt.Errorf("could not update webhook %d on %s: %v", existing.GetID(), hooks.owner, err)
+	}
+
+	logger.Info.Printf("webhook for '%s' verified on %s\n", hookURL, hooks.owner)
+	return nil
+}
+
+func (gh *GithubService) deleteWebhook(hooks hookService, hookURL string) (err error) {
+	existing, err := findWebhook(hooks, hookURL)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		logger.Warning.Printf("no webhook for '%s' found on %s\n", hookURL, hooks.owner)
+		return nil
+	}
+
+	if resp, err := hooks.delete(context.Background(), existing.GetID()); err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return fmt.Errorf("could not delete webhook %d on %s: %v", existing.GetID(), hooks.owner, err)
+	}
+
+	logger.Info.Printf("webhook for '%s' deleted on %s\n", hookURL, hooks.owner)
+	return nil
+}
+
+// Returns the webhook delivering to `hookURL`, or nil if there is none.
+func findWebhook(hooks hookService, hookURL string) (hook *github.Hook, err error) {
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := hooks.list(context.Background(), opts)
+		if err != nil {
+			return nil, fmt.Errorf("could not list webhooks on %s: %v", hooks.owner, err)
+		}
+
+		for _, hook := range page {
+			if hook.GetConfig().GetURL() == hookURL {
+				return hook, nil
+			}
+		}
+
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func newWebhook(hookURL string, secret string) *github.Hook {
+	active := true
+	return &github.Hook{
+		Config: &github.HookConfig{
+			URL:         github.String(hookURL),
+			ContentType: github.String("json"),
+			InsecureSSL: github.String("0"),
+			Secret:      github.String(secret),
+		},
+		Events: webhookEvents,
+		Active: &active,
+	}
+}
+
+// Lists the settings of `hook` which differ from the ones shortinette relies on.
+func webhookMismatches(hook *github.Hook) (mismatches []string) {
+	if !hook.GetActive() {
+		mismatches = append(mismatches, "inactive")
+	}
+	if contentType := hook.GetConfig().GetContentType(); contentType != "json" {
+		mismatches = append(mismatches, fmt.Sprintf("content type '%s'", contentType))
+	}
+	if hook.GetConfig().GetInsecureSSL() == "1" {
+		mismatches = append(mismatches, "SSL verification disabled")
+	}
+	for _, event := range webhookEvents {
+		if !slices.Contains(hook.Events, event) && !slices.Contains(hook.Events, "*") {
+			mismatches = append(mismatches, fmt.Sprintf("'%s' event missing", event))
+		}
+	}
+	return mismatches
+}
+
+// GitHub needs an absolute URL it can reach, a bare listen address like ':8080' is not enough.
+func validateWebhookURL(hookURL string) error {
+	parsed, err := url.Parse(hookURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL '%s': %v", hookURL, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return fmt.Errorf("invalid webhook URL '%s': expected an absolute http(s) URL", hookURL)
+	}
+	return nil
+}
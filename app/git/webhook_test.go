@@ -0,0 +1,141 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v66/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// In-memory stand-in for GitHub's organisation webhook API.
+type fakeHookServer struct {
+	mu     sync.Mutex
+	hooks  map[int64]*github.Hook
+	nextID int64
+	edits  int
+}
+
+func newFakeHookServer(t *testing.T) (*fakeHookServer, *GithubService) {
+	t.Helper()
+
+	fake := &fakeHookServer{hooks: map[int64]*github.Hook{}, nextID: 1}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	gh := NewGithubService("token", "orga", basePath)
+	baseURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	gh.Client.BaseURL = baseURL
+
+	return fake, gh
+}
+
+func (f *fakeHookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/orgs/orga/hooks")
+	switch {
+	case r.Method == http.MethodGet && path == "":
+		hooks := make([]*github.Hook, 0, len(f.hooks))
+		for _, hook := range f.hooks {
+			hooks = append(hooks, hook)
+		}
+		_ = json.NewEncoder(w).Encode(hooks)
+	case r.Method == http.MethodPost && path == "":
+		var hook github.Hook
+		_ = json.NewDecoder(r.Body).Decode(&hook)
+		hook.ID = github.Int64(f.nextID)
+		f.hooks[f.nextID] = &hook
+		f.nextID++
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(hook)
+	case r.Method == http.MethodPatch || r.Method == http.MethodDelete:
+		id, err := strconv.ParseInt(strings.TrimPrefix(path, "/"), 10, 64)
+		if _, exists := f.hooks[id]; err != nil || !exists {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(f.hooks, id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		var hook github.Hook
+		_ = json.NewDecoder(r.Body).Decode(&hook)
+		hook.ID = github.Int64(id)
+		f.hooks[id] = &hook
+		f.edits++
+		_ = json.NewEncoder(w).Encode(hook)
+	default:
+		http.Error(w, fmt.Sprintf("unexpected request: %s %s", r.Method, r.URL.Path), http.StatusNotImplemented)
+	}
+}
+
+const testHookURL = "https://shortinette.example.com/shortinette/webhook/grademe"
+
+func TestEnsureOrgWebhookCreatesMissingHook(t *testing.T) {
+	fake, gh := newFakeHookServer(t)
+
+	require.NoError(t, gh.EnsureOrgWebhook(testHookURL, "secret"))
+
+	require.Len(t, fake.hooks, 1)
+	hook := fake.hooks[1]
+	assert.Equal(t, testHookURL, hook.GetConfig().GetURL())
+	assert.Equal(t, "secret", hook.GetConfig().GetSecret())
+	assert.Equal(t, "json", hook.GetConfig().GetContentType())
+	assert.Equal(t, []string{"push"}, hook.Events)
+	assert.True(t, hook.GetActive())
+}
+
+func TestEnsureOrgWebhookFixesMismatchedHook(t *testing.T) {
+	fake, gh := newFakeHookServer(t)
+	fake.hooks[1] = &github.Hook{
+		ID:     github.Int64(1),
+		Config: &github.HookConfig{URL: github.String(testHookURL), ContentType: github.String("form")},
+		Events: []string{"issues"},
+		Active: github.Bool(false),
+	}
+	fake.nextID = 2
+
+	assert.Len(t, webhookMismatches(fake.hooks[1]), 3)
+	require.NoError(t, gh.EnsureOrgWebhook(testHookURL, "secret"))
+
+	require.Len(t, fake.hooks, 1, "the existing webhook should be updated, not duplicated")
+	assert.Equal(t, 1, fake.edits)
+	assert.Empty(t, webhookMismatches(fake.hooks[1]))
+}
+
+func TestDeleteOrgWebhook(t *testing.T) {
+	fake, gh := newFakeHookServer(t)
+	require.NoError(t, gh.EnsureOrgWebhook(testHookURL, "secret"))
+	require.NoError(t, gh.EnsureOrgWebhook("https://someone.else/hook", "secret"))
+
+	require.NoError(t, gh.DeleteOrgWebhook(testHookURL))
+
+	require.Len(t, fake.hooks, 1, "only shortinette's webhook should be deleted")
+	for _, hook := range fake.hooks {
+		assert.Equal(t, "https://someone.else/hook", hook.GetConfig().GetURL())
+	}
+}
+
+func TestDeleteOrgWebhookMissing(t *testing.T) {
+	_, gh := newFakeHookServer(t)
+	require.NoError(t, gh.DeleteOrgWebhook(testHookURL))
+}
+
+func TestEnsureOrgWebhookInvalidURL(t *testing.T) {
+	_, gh := newFakeHookServer(t)
+	if err := gh.EnsureOrgWebhook("http://:8080/shortinette/webhook/grademe", "secret"); err == nil {
+		t.Fatalf("a webhook URL without host should be rejected")
+	}
+}
@@ -0,0 +1,149 @@
+// `intra` is the package responsible for interactions with the 42 Intra API, which shortinette
+// uses as OAuth2 identity provider for students and staff.
+package intra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Intra role matching the `staff?` flag of a user, as opposed to the names of their groups.
+const StaffRole = "staff"
+
+type Client struct {
+	BaseURL      string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	HTTP         *http.Client
+}
+
+// Subset of the Intra's /v2/me response shortinette cares about.
+type User struct {
+	Login       string       `json:"login"`
+	Staff       bool         `json:"staff?"`
+	Groups      []Group      `json:"groups"`
+	CampusUsers []CampusUser `json:"campus_users"`
+}
+
+type Group struct {
+	Name string `json:"name"`
+}
+
+// Membership of a user in a campus.
+type CampusUser struct {
+	CampusID  int  `json:"campus_id"`
+	IsPrimary bool `json:"is_primary"`
+}
+
+func NewClient(baseURL string, clientID string, clientSecret string, redirectURL string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		HTTP:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Returns the URL of the Intra's consent page. `state` is sent back to the redirect URL
+// along with the authorization code.
+func (c *Client) AuthorizeURL(state string) string {
+	query := url.Values{
+		"client_id":     {c.ClientID},
+		"redirect_uri":  {c.RedirectURL},
+		"response_type": {"code"},
+		"scope":         {"public"},
+		"state":         {state},
+	}
+	return fmt.Sprintf("%s/oauth/authorize?%s", c.BaseURL, query.Encode())
+}
+
+// Exchanges the authorization `code` for an access token.
+func (c *Client) Exchange(ctx context.Context, code string) (accessToken string, err error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {c.ClientID},
+		"client_secret": {c.ClientSecret},
+		"code":          {code},
+		"redirect_uri":  {c.RedirectURL},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := c.do(req, &token); err != nil {
+		return "", fmt.Errorf("could not exchange authorization code: %v", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("could not exchange authorization code: no access token in response")
+	}
+	return token.AccessToken, nil
+}
+
+// Fetches the user `accessToken` was issued to.
+func (c *Client) Me(ctx context.Context, accessToken string) (user *User, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/v2/me", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	user = &User{}
+	if err := c.do(req, user); err != nil {
+		return nil, fmt.Errorf("could not fetch user: %v", err)
+	}
+	if user.Login == "" {
+		return nil, fmt.Errorf("could not fetch user: no login in response")
+	}
+	return user, nil
+}
+
+func (c *Client) do(req *http.Request, target any) error {
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// Returns true if `user` has one of `roles`, which are either names of Intra groups,
+// or StaffRole.
+func (user *User) HasRole(roles []string) bool {
+	if user.Staff && slices.Contains(roles, StaffRole) {
+		return true
+	}
+	for _, group := range user.Groups {
+		if slices.Contains(roles, group.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if `user` belongs to campus `campusID`.
+func (user *User) IsInCampus(campusID int) bool {
+	for _, campusUser := range user.CampusUsers {
+		if campusUser.CampusID == campusID {
+			return true
+		}
+	}
+	return false
+}
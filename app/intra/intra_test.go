@@ -0,0 +1,88 @@
+package intra
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func newStandInClient(t *testing.T, users ...User) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(NewStandIn(users...))
+	t.Cleanup(server.Close)
+	return NewClient(server.URL, "client id", "client secret", "http://localhost/callback")
+}
+
+func TestLoginFlow(t *testing.T) {
+	client := newStandInClient(t, User{Login: "jdoe", Staff: true})
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(client.AuthorizeURL("some state") + "&login=jdoe")
+	if err != nil {
+		t.Fatalf("could not open consent page: %v", err)
+	}
+	resp.Body.Close()
+
+	callback, err := resp.Location()
+	if err != nil {
+		t.Fatalf("consent page did not redirect: %v", err)
+	}
+	if callback.Query().Get("state") != "some state" {
+		t.Fatalf("state was not passed back, got '%s'", callback.Query().Get("state"))
+	}
+
+	accessToken, err := client.Exchange(context.Background(), callback.Query().Get("code"))
+	if err != nil {
+		t.Fatalf("could not exchange code: %v", err)
+	}
+	user, err := client.Me(context.Background(), accessToken)
+	if err != nil {
+		t.Fatalf("could not fetch user: %v", err)
+	}
+	if user.Login != "jdoe" || !user.Staff {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+}
+
+func TestExchangeInvalidCode(t *testing.T) {
+	client := newStandInClient(t, User{Login: "jdoe"})
+
+	if _, err := client.Exchange(context.Background(), "someone else"); err == nil {
+		t.Fatalf("unknown authorization codes should be rejected")
+	}
+}
+
+func TestAuthorizeURL(t *testing.T) {
+	client := NewClient("https://intra.example.com/", "uid", "secret", "https://shortinette.example.com/callback")
+
+	authorizeURL, err := url.Parse(client.AuthorizeURL("state"))
+	if err != nil {
+		t.Fatalf("invalid authorize URL: %v", err)
+	}
+	if authorizeURL.Host != "intra.example.com" || authorizeURL.Path != "/oauth/authorize" {
+		t.Fatalf("unexpected authorize URL: %s", authorizeURL)
+	}
+	if authorizeURL.Query().Get("redirect_uri") != "https://shortinette.example.com/callback" {
+		t.Fatalf("redirect URL was not passed, got '%s'", authorizeURL.Query().Get("redirect_uri"))
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	roles := []string{StaffRole, "bocal"}
+
+	if !(&User{Staff: true}).HasRole(roles) {
+		t.Fatalf("staff flag should match the '%s' role", StaffRole)
+	}
+	if !(&User{Groups: []Group{{Name: "bocal"}}}).HasRole(roles) {
+		t.Fatalf("group names should match roles")
+	}
+	if (&User{Groups: []Group{{Name: "tutor"}}}).HasRole(roles) {
+		t.Fatalf("unconfigured groups should not match")
+	}
+	if (&User{Staff: true}).HasRole([]string{"bocal"}) {
+		t.Fatalf("staff flag should only match if the '%s' role is configured", StaffRole)
+	}
+}
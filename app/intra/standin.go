@@ -0,0 +1,105 @@
+package intra
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Local stand-in for the Intra's OAuth2 flow and /v2/me endpoint, for testing and local
+// development without registering an Intra application.
+//
+// The consent page is skipped: /oauth/authorize redirects right away with the login passed
+// in the `login` query parameter as authorization code.
+type StandIn struct {
+	mu    sync.Mutex
+	users map[string]User
+}
+
+func NewStandIn(users ...User) *StandIn {
+	standIn := &StandIn{users: make(map[string]User, len(users))}
+	for _, user := range users {
+		standIn.users[user.Login] = user
+	}
+	return standIn
+}
+
+// Adds or replaces `user`.
+func (s *StandIn) AddUser(user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user.Login] = user
+}
+
+func (s *StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/oauth/authorize":
+		s.authorize(w, r)
+	case "/oauth/token":
+		s.token(w, r)
+	case "/v2/me":
+		s.me(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *StandIn) authorize(w http.ResponseWriter, r *http.Request) {
+	redirectURL, err := url.Parse(r.URL.Query().Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	query := redirectURL.Query()
+	query.Set("code", r.URL.Query().Get("login"))
+	query.Set("state", r.URL.Query().Get("state"))
+	redirectURL.RawQuery = query.Encode()
+
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+// Authorization codes and access tokens are both the user's login.
+func (s *StandIn) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		http.Error(w, "invalid token request", http.StatusBadRequest)
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	if _, exists := s.lookup(code); !exists {
+		http.Error(w, "invalid authorization code", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"access_token": code, "token_type": "bearer", "expires_in": 7200})
+}
+
+func (s *StandIn) me(w http.ResponseWriter, r *http.Request) {
+	const prefix = "Bearer "
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) <= len(prefix) {
+		http.Error(w, "missing access token", http.StatusUnauthorized)
+		return
+	}
+
+	user, exists := s.lookup(authHeader[len(prefix):])
+	if !exists {
+		http.Error(w, "invalid access token", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(user)
+}
+
+func (s *StandIn) lookup(login string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[login]
+	return user, exists
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
@@ -11,6 +12,7 @@ import (
 	"github.com/42-Short/shortinette/config"
 	"github.com/42-Short/shortinette/db"
 	"github.com/42-Short/shortinette/logger"
+	"github.com/42-Short/shortinette/short"
 	"github.com/gin-gonic/gin"
 )
 
@@ -125,28 +127,45 @@ func getMockConfig() *config.Config {
 	)
 }
 
-func run() {
-	db, err := db.NewDB(context.Background(), "./data/shortinette.db")
+func loadConfig() *config.Config {
+	config := getMockConfig()
+	if err := config.FetchEnvVariables(); err != nil {
+		logger.Error.Fatalf("could not fetch environment variables: %v", err)
+	}
+	return config
+}
+
+// Reads the configuration and opens the database it points to. The database is shared by
+// the API, the grader and the Short.
+func setup() (*config.Config, *db.DB) {
+	config := loadConfig()
+
+	db, err := db.NewDB(context.Background(), config.DatabaseDSN)
 	if err != nil {
 		logger.Error.Fatalf("failed to create db: %v", err)
 	}
-	defer db.Close()
+	return config, db
+}
+
+func run() {
+	config, database := setup()
+	defer database.Close()
 
-	err = db.Initialize("./db/schema.sql")
+	err := database.Migrate(context.Background())
 	if err != nil {
-		logger.Error.Fatalf("failed to initialize db: %v", err)
+		logger.Error.Fatalf("failed to migrate db: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if database.Dialect == db.SQLite && config.BackupInterval > 0 {
+		database.StartBackupScheduler(ctx, config.BackupDir, config.BackupInterval, retention(config))
 	}
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	config := getMockConfig()
-
-	if err := config.FetchEnvVariables(); err != nil {
-		logger.Error.Fatalf("could not fetch environment variables: %v", err)
-	}
-
-	api := api.NewAPI(config, db, gin.DebugMode)
+	api := api.NewAPI(config, database, gin.DebugMode)
 	api.SetupRouter()
 	go shutdown(api, sigCh)
 	err = api.Run()
@@ -155,6 +174,93 @@ func run() {
 	}
 }
 
+// Applies pending migrations (`up`) or lists which migrations have been applied (`status`).
+func migrate(command string) {
+	_, db := setup()
+	defer db.Close()
+
+	switch command {
+	case "up":
+		if err := db.Migrate(context.Background()); err != nil {
+			logger.Error.Fatalf("failed to migrate db: %v", err)
+		}
+	case "status":
+		states, err := db.MigrationStatus(context.Background())
+		if err != nil {
+			logger.Error.Fatalf("failed to get migration status: %v", err)
+		}
+		for _, state := range states {
+			status := "pending"
+			if state.AppliedAt != nil {
+				status = "applied " + state.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", state.Version, state.Name, status)
+		}
+	default:
+		logger.Error.Fatalf("unknown migrate command '%s': expected 'up' or 'status'", command)
+	}
+}
+
+func retention(config *config.Config) db.Retention {
+	return db.Retention{Hourly: config.BackupKeepHourly, Daily: config.BackupKeepDaily}
+}
+
+// Backs the database up once and prunes old backups, like the scheduler started by run.
+func backup() {
+	config, database := setup()
+	defer database.Close()
+
+	path, err := database.Backup(context.Background(), config.BackupDir)
+	if err != nil {
+		logger.Error.Fatalf("failed to backup db: %v", err)
+	}
+	if err := db.PruneBackups(config.BackupDir, retention(config)); err != nil {
+		logger.Error.Fatalf("failed to prune db backups: %v", err)
+	}
+	fmt.Println(path)
+}
+
+// Replaces the database with `backupPath`. shortinette must be stopped first.
+func restore(backupPath string) {
+	config := loadConfig()
+	if err := db.Restore(context.Background(), backupPath, config.DatabaseDSN); err != nil {
+		logger.Error.Fatalf("failed to restore db: %v", err)
+	}
+}
+
+// Permanently removes the participants and modules deleted more than DELETED_RETENTION ago.
+func purge() {
+	config, database := setup()
+	defer database.Close()
+
+	if err := short.PurgeDeleted(context.Background(), database, time.Now().Add(-config.DeletedRetention)); err != nil {
+		logger.Error.Fatalf("failed to purge deleted records: %v", err)
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		command := "up"
+		if len(os.Args) > 2 {
+			command = os.Args[2]
+		}
+		migrate(command)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		backup()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "purge" {
+		purge()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		if len(os.Args) != 3 {
+			logger.Error.Fatalf("usage: %s restore <backup file>", os.Args[0])
+		}
+		restore(os.Args[2])
+		return
+	}
 	run()
 }
@@ -0,0 +1,95 @@
+package short
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/42-Short/shortinette/config"
+	"github.com/42-Short/shortinette/dao"
+	"github.com/42-Short/shortinette/logger"
+)
+
+// Picks the attempt a final grade is based on, according to `rule`. Attempts graded after
+// `deadline` do not count. Returns nil if no attempt counts.
+func selectFinalAttempt(attempts []dao.Attempt, rule string, deadline time.Time) *dao.Attempt {
+	var selected *dao.Attempt
+	for idx := range attempts {
+		attempt := &attempts[idx]
+		if attempt.GradedAt.After(deadline) {
+			continue
+		}
+
+		switch {
+		case selected == nil:
+			selected = attempt
+		case rule == config.FinalGradeLast && attempt.GradedAt.After(selected.GradedAt):
+			selected = attempt
+		// The first attempt reaching the best score counts
+		case rule == config.FinalGradeBest && (attempt.Score > selected.Score || (attempt.Score == selected.Score && attempt.GradedAt.Before(selected.GradedAt))):
+			selected = attempt
+		}
+	}
+	return selected
+}
+
+// Writes the final grade of every participant for module `moduleNumber`. Repos reopened by
+// an admin are skipped, their grade is frozen once they are closed again.
+func (sh *Short) freezeGrades(moduleNumber int) (err error) {
+	ctx := context.Background()
+	moduleDAO := dao.NewDAO[dao.Module](sh.DB)
+	attemptDAO := dao.NewDAO[dao.Attempt](sh.DB)
+	finalGradeDAO := dao.NewDAO[dao.FinalGrade](sh.DB)
+
+	modules, err := moduleDAO.GetFiltered(ctx, map[string]any{"id": moduleNumber})
+	if err != nil {
+		return fmt.Errorf("could not fetch modules: %v", err)
+	}
+
+	var errs []error
+	for _, module := range modules {
+		if module.Reopened {
+			continue
+		}
+		if err := sh.FreezeGrade(ctx, module, sh.moduleEnd(moduleNumber), attemptDAO, finalGradeDAO); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	logger.Info.Printf("final grades of module %02d frozen (%s attempt)", moduleNumber, sh.Config.FinalGradeRule)
+	return errors.Join(errs...)
+}
+
+// Writes the final grade of `module`, unless it already has one: final grades never change.
+// Attempts graded after `deadline` do not count. It is the end of the module, the grace period
+// before closing it is not part of it, or when a reopened repo was closed again.
+func (sh *Short) FreezeGrade(ctx context.Context, module dao.Module, deadline time.Time, attemptDAO *dao.DAO[dao.Attempt], finalGradeDAO *dao.DAO[dao.FinalGrade]) (err error) {
+	if _, err := finalGradeDAO.Get(ctx, module.Id, module.IntraLogin); err == nil {
+		return nil
+	}
+
+	attempts, err := attemptDAO.GetFiltered(ctx, map[string]any{"module_id": module.Id, "intra_login": module.IntraLogin})
+	if err != nil {
+		return fmt.Errorf("could not fetch attempts of %s-%02d: %v", module.IntraLogin, module.Id, err)
+	}
+
+	finalGrade := dao.FinalGrade{
+		ModuleId:   module.Id,
+		IntraLogin: module.IntraLogin,
+		Rule:       sh.Config.FinalGradeRule,
+		FrozenAt:   time.Now(),
+	}
+	if attempt := selectFinalAttempt(attempts, sh.Config.FinalGradeRule, deadline); attempt != nil {
+		finalGrade.Score = attempt.Score
+		finalGrade.Passed = attempt.Passed
+		finalGrade.AttemptId = attempt.Id
+		finalGrade.CommitSHA = attempt.CommitSHA
+		finalGrade.TraceRef = attempt.TraceRef
+	}
+
+	if err := finalGradeDAO.Insert(ctx, finalGrade); err != nil {
+		return fmt.Errorf("could not freeze grade of %s-%02d: %v", module.IntraLogin, module.Id, err)
+	}
+	return nil
+}
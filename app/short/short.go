@@ -2,7 +2,9 @@ package short
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"sync"
 	"time"
 
@@ -19,115 +21,423 @@ var (
 )
 
 type Short struct {
-	Participants []dao.Participant
 	Config       config.Config
 	GitHubClient git.GithubService
+	DB           *db.DB // Owned by the caller, who closes it
 
 	stopChan chan struct{}
 }
 
-func NewShort(participants []dao.Participant, config config.Config) (sh Short) {
+func NewShort(config config.Config, db *db.DB) (sh Short, err error) {
+	gitHubClient, err := git.NewGithubServiceFromConfig(config, config.BasePath)
+	if err != nil {
+		return Short{}, fmt.Errorf("could not set up GitHub client: %v", err)
+	}
+
 	return Short{
-		Participants: participants,
 		Config:       config,
-		GitHubClient: *git.NewGithubService(config.TokenGithub, config.OrgaGithub, config.BasePath),
+		GitHubClient: *gitHubClient,
+		DB:           db,
 		stopChan:     make(chan struct{}),
-	}
+	}, nil
 }
 
+// Provisions module `moduleNumber` for every active participant. The roster is read when the
+// module starts, so that participants added or withdrawn in the meantime are taken into account.
+// A participant whose repo could not be provisioned does not keep the others from getting theirs.
 func (sh *Short) launchModule(moduleNumber int) (err error) {
 	templateName, err := sh.GitHubClient.CreateModuleTemplate(moduleNumber)
 	if err != nil {
 		return fmt.Errorf("could not create template for module %02d: %v", moduleNumber, err)
 	}
 
-	db, err := db.NewDB(context.Background(), "./data/shortinette.db")
+	moduleDAO := dao.NewDAO[dao.Module](sh.DB)
+	participants, err := dao.NewDAO[dao.Participant](sh.DB).GetAll(context.Background())
 	if err != nil {
-		return fmt.Errorf("could not open database at ./data/shortinette.db: %v", err)
+		return fmt.Errorf("could not fetch participants: %v", err)
 	}
 
-	moduleDAO := dao.NewDAO[dao.Module](db)
-
-	for _, participant := range sh.Participants {
-		module := dao.Module{
-			Id:         moduleNumber,
-			IntraLogin: participant.IntraLogin,
-			Attempts:   0,
-			Score:      0,
-			LastGraded: time.Now(),
-			WaitTime:   0,
+	provisioned := make([]dao.Participant, 0, len(participants))
+	var errs []error
+	for _, participant := range participants {
+		if !participant.IsActive() {
+			logger.Info.Printf("skipping %s (%s) for module %02d", participant.IntraLogin, participant.Status, moduleNumber)
+			continue
 		}
 
-		repoName := fmt.Sprintf("%s-%02d", participant.IntraLogin, moduleNumber)
-		description := fmt.Sprintf("Commit on the main branch with 'grademe' as a commit message to get graded. Minimum passing grade: %d", sh.Config.Modules[moduleNumber].MinimumScore)
+		if err := sh.provisionRepo(templateName, moduleNumber, participant); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		provisioned = append(provisioned, participant)
+	}
 
-		if err := sh.GitHubClient.NewRepo(templateName, repoName, true, description); err != nil {
-			return fmt.Errorf("could not create new repo %s: %v", repoName, err)
+	// The repos are provisioned above, outside of the transaction, so that slow GitHub calls do
+	// not hold it open. The missing modules are then inserted in one InsertMany batch, so that a
+	// crash does not leave only some participants with the module. Participants who already
+	// have the module, e.g. on relaunch, keep their row.
+	ctx := context.Background()
+	err = dao.WithTx(ctx, sh.DB, func(tx *dao.Tx) error {
+		// Deleted modules keep their row until they are purged
+		existing, err := moduleDAO.In(tx).Query().WithDeleted().Where("id", dao.Eq, moduleNumber).All(ctx)
+		if err != nil {
+			return err
+		}
+		launched := make(map[string]bool, len(existing))
+		for _, module := range existing {
+			launched[module.IntraLogin] = true
 		}
 
-		if err := sh.GitHubClient.AddCollaborator(repoName, participant.GitHubLogin, "write"); err != nil {
-			return fmt.Errorf("could not give %s write access to %s: %v", participant.GitHubLogin, repoName, err)
+		modules := make([]dao.Module, 0, len(provisioned))
+		for _, participant := range provisioned {
+			if !launched[participant.IntraLogin] {
+				modules = append(modules, newModule(moduleNumber, participant))
+			}
 		}
+		return moduleDAO.In(tx).InsertMany(ctx, modules)
+	})
+	if err != nil {
+		errs = append(errs, fmt.Errorf("could not add module %02d: %w", moduleNumber, err))
+	}
+	return errors.Join(errs...)
+}
+
+// Creates the repo of `participant` for module `moduleNumber` from `templateName`, and gives
+// them write access to it. Safe to call again for participants who already have the module.
+func (sh *Short) provision(templateName string, moduleNumber int, participant dao.Participant, moduleDAO *dao.DAO[dao.Module]) (err error) {
+	if err := sh.provisionRepo(templateName, moduleNumber, participant); err != nil {
+		return err
+	}
+	return addModule(moduleDAO, moduleNumber, participant)
+}
 
-		if err := moduleDAO.Insert(context.Background(), module); err != nil {
-			return fmt.Errorf("could not insert data into module table: %v", err)
+func (sh *Short) provisionRepo(templateName string, moduleNumber int, participant dao.Participant) (err error) {
+	repoName := fmt.Sprintf("%s-%02d", participant.IntraLogin, moduleNumber)
+	description := fmt.Sprintf("Commit on the main branch with 'grademe' as a commit message to get graded. Minimum passing grade: %d", sh.Config.Modules[moduleNumber].MinimumScore)
+
+	if err := sh.GitHubClient.NewRepo(templateName, repoName, true, description); err != nil {
+		return fmt.Errorf("could not create new repo %s: %v", repoName, err)
+	}
+
+	if sh.Config.WebhookScope == config.WebhookScopeRepository {
+		if err := sh.GitHubClient.EnsureRepoWebhook(repoName, sh.Config.WebhookURL, sh.Config.WebhookSecret.Current()); err != nil {
+			return fmt.Errorf("could not set up webhook on %s: %v", repoName, err)
 		}
 	}
 
+	if err := sh.GitHubClient.AddCollaborator(repoName, participant.GitHubLogin, "write"); err != nil {
+		return fmt.Errorf("could not give %s write access to %s: %v", participant.GitHubLogin, repoName, err)
+	}
+	return nil
+}
+
+// Adds the row of module `moduleNumber` for `participant`, unless it already exists, even
+// if it is deleted.
+func addModule(moduleDAO *dao.DAO[dao.Module], moduleNumber int, participant dao.Participant) (err error) {
+	ctx := context.Background()
+	err = dao.WithTx(ctx, moduleDAO.DB, func(tx *dao.Tx) error {
+		// Deleted modules keep their row until they are purged
+		exists, err := moduleDAO.In(tx).Query().WithDeleted().
+			Where("id", dao.Eq, moduleNumber).
+			Where("intra_login", dao.Eq, participant.IntraLogin).
+			Exists(ctx)
+		if err != nil || exists {
+			return err
+		}
+		return moduleDAO.In(tx).Insert(ctx, newModule(moduleNumber, participant))
+	})
+	if err != nil {
+		return fmt.Errorf("could not insert data into module table: %v", err)
+	}
 	return nil
 }
 
-func (sh *Short) getCurrentModuleIdx() (moduleIdx int) {
-	for idx := range sh.Config.Modules {
-		if time.Now().After(sh.Config.StartTime.Add(sh.Config.ModuleDuration*time.Duration(idx))) && time.Now().Before(sh.Config.StartTime.Add(sh.Config.ModuleDuration*time.Duration(idx+1))) {
-			return idx
+// Returns the row of module `moduleNumber` for `participant`, before any grading.
+func newModule(moduleNumber int, participant dao.Participant) dao.Module {
+	return dao.Module{
+		Id:         moduleNumber,
+		IntraLogin: participant.IntraLogin,
+		Attempts:   0,
+		Score:      0,
+		LastGraded: time.Now(),
+		WaitTime:   0,
+	}
+}
+
+// Provisions every currently open module for `participant`, who joined after the Short was
+// launched. Returns the modules which were provisioned.
+func (sh *Short) ProvisionLateJoiner(participant dao.Participant, moduleDAO *dao.DAO[dao.Module]) (modules []int, err error) {
+	if !participant.IsActive() {
+		return nil, fmt.Errorf("%s is %s", participant.IntraLogin, participant.Status)
+	}
+
+	for _, moduleIdx := range sh.openModules(time.Now()) {
+		templateName, err := sh.GitHubClient.CreateModuleTemplate(moduleIdx)
+		if err != nil {
+			return modules, fmt.Errorf("could not create template for module %02d: %v", moduleIdx, err)
+		}
+
+		if err := sh.provision(templateName, moduleIdx, participant, moduleDAO); err != nil {
+			return modules, err
+		}
+		modules = append(modules, moduleIdx)
+	}
+
+	logger.Info.Printf("late joiner %s provisioned with modules %v", participant.IntraLogin, modules)
+	return modules, nil
+}
+
+// Removes `participant` from the repos of all modules which have started.
+func (sh *Short) RevokeAccess(participant dao.Participant) (err error) {
+	var errs []error
+	for moduleIdx := range sh.Config.Modules {
+		if time.Now().Before(sh.moduleStart(moduleIdx)) {
+			break
+		}
+
+		repoName := fmt.Sprintf("%s-%02d", participant.IntraLogin, moduleIdx)
+		if err := sh.GitHubClient.RemoveCollaborator(repoName, participant.GitHubLogin); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Returns the modules which have started and are not closed yet at `now`.
+func (sh *Short) openModules(now time.Time) (modules []int) {
+	for moduleIdx := range sh.Config.Modules {
+		if !now.Before(sh.moduleStart(moduleIdx)) && now.Before(sh.ModuleClose(moduleIdx)) {
+			modules = append(modules, moduleIdx)
+		}
+	}
+	return modules
+}
+
+func (sh *Short) moduleStart(moduleIdx int) time.Time {
+	return sh.Config.StartTime.Add(sh.Config.ModuleDuration * time.Duration(moduleIdx))
+}
+
+// Returns when module `moduleIdx` is over, i.e. when the next one starts.
+func (sh *Short) moduleEnd(moduleIdx int) time.Time {
+	return sh.moduleStart(moduleIdx + 1)
+}
+
+// Returns when module `moduleIdx` closes, i.e. at its end plus the grace period.
+func (sh *Short) ModuleClose(moduleIdx int) time.Time {
+	return sh.moduleEnd(moduleIdx).Add(sh.Config.ModuleCloseGrace)
+}
+
+// Closes the repos of module `moduleNumber`, except the ones which were reopened by an admin,
+// and freezes the final grades.
+func (sh *Short) closeModule(moduleNumber int) (err error) {
+	moduleDAO := dao.NewDAO[dao.Module](sh.DB)
+	modules, err := moduleDAO.GetFiltered(context.Background(), map[string]any{"id": moduleNumber})
+	if err != nil {
+		return fmt.Errorf("could not fetch modules: %v", err)
+	}
+	participantDAO := dao.NewDAO[dao.Participant](sh.DB)
+
+	var errs []error
+	for _, module := range modules {
+		if module.ClosedAt != nil || module.Reopened {
+			continue
+		}
+
+		participant, err := participantDAO.Get(context.Background(), module.IntraLogin)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not fetch participant %s: %v", module.IntraLogin, err))
+			continue
+		}
+		// Their access was revoked when they were withdrawn or banned
+		if !participant.IsActive() {
+			continue
+		}
+
+		if err := sh.CloseRepo(*participant, moduleNumber); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		now := time.Now()
+		module.ClosedAt = &now
+		if err := moduleDAO.Update(context.Background(), module); err != nil {
+			errs = append(errs, fmt.Errorf("could not record closing of %s-%02d: %v", module.IntraLogin, moduleNumber, err))
+		}
+	}
+
+	if err := sh.freezeGrades(moduleNumber); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Takes write access to the repo of `participant` for module `moduleNumber` away, according
+// to the configured close action.
+func (sh *Short) CloseRepo(participant dao.Participant, moduleNumber int) (err error) {
+	repoName := fmt.Sprintf("%s-%02d", participant.IntraLogin, moduleNumber)
+	if sh.Config.ModuleCloseAction == config.ModuleCloseArchive {
+		return sh.GitHubClient.SetArchived(repoName, true)
+	}
+	return sh.GitHubClient.SetCollaboratorPermission(repoName, participant.GitHubLogin, "pull")
+}
+
+// Gives `participant` write access to their repo for module `moduleNumber` back.
+func (sh *Short) ReopenRepo(participant dao.Participant, moduleNumber int) (err error) {
+	repoName := fmt.Sprintf("%s-%02d", participant.IntraLogin, moduleNumber)
+	if sh.Config.ModuleCloseAction == config.ModuleCloseArchive {
+		if err := sh.GitHubClient.SetArchived(repoName, false); err != nil {
+			return err
 		}
 	}
+	return sh.GitHubClient.SetCollaboratorPermission(repoName, participant.GitHubLogin, "push")
+}
+
+// Sleeps until `t`. Returns false if the scheduler was stopped in the meantime.
+func (sh *Short) sleepUntil(t time.Time) (ok bool) {
+	select {
+	case <-sh.stopChan:
+		logger.Info.Println("short scheduling stopped")
+		return false
+	case <-time.After(time.Until(t)):
+		return true
+	}
+}
+
+// Step of the Short's schedule.
+type event struct {
+	at   time.Time
+	name string
+	run  func() error
+
+	// Launches of modules which are already over (e.g. if the Short was relaunched) are skipped,
+	// closures are not, so that deadlines are enforced even if shortinette was down at the time.
+	skipIfOver time.Time
+}
+
+// Returns the schedule of the Short: every module is launched at its start, and closed at its
+// end plus the grace period.
+func (sh *Short) events() []event {
+	events := make([]event, 0, 2*len(sh.Config.Modules))
+	for moduleIdx := range sh.Config.Modules {
+		events = append(events,
+			event{
+				at:         sh.moduleStart(moduleIdx),
+				name:       fmt.Sprintf("launching module %02d", moduleIdx),
+				run:        func() error { return sh.launchModule(moduleIdx) },
+				skipIfOver: sh.moduleStart(moduleIdx + 1),
+			},
+			event{
+				at:   sh.ModuleClose(moduleIdx),
+				name: fmt.Sprintf("closing module %02d", moduleIdx),
+				run:  func() error { return sh.closeModule(moduleIdx) },
+			},
+		)
+	}
 
-	return -1
+	// With a grace period, modules close after the next one started
+	slices.SortStableFunc(events, func(a, b event) int { return a.at.Compare(b.at) })
+	return events
 }
 
 func (sh *Short) schedule() {
-	for time.Now().Before(sh.Config.StartTime) {
+	if time.Now().Before(sh.Config.StartTime) {
 		logger.Info.Printf("short starting in %f seconds, sleeping", time.Until(sh.Config.StartTime).Seconds())
-		select {
-		case <-sh.stopChan:
-			logger.Info.Println("short scheduling stopped")
+	}
+
+	for _, event := range sh.events() {
+		if !event.skipIfOver.IsZero() && time.Now().After(event.skipIfOver) {
+			continue
+		}
+
+		if !sh.sleepUntil(event.at) {
 			return
-		case <-time.After(time.Until(sh.Config.StartTime)):
-			break
 		}
-		time.Sleep(time.Until(sh.Config.StartTime))
+
+		logger.Info.Println(event.name)
+		// A failed launch must not keep earlier modules from being closed and graded
+		if err := event.run(); err != nil {
+			logger.Error.Printf("error %s: %v", event.name, err)
+		}
 	}
-	logger.Info.Println("launching Short now!")
 
-	currentModuleIdx := sh.getCurrentModuleIdx()
-	if currentModuleIdx == -1 {
+	logger.Info.Println("last module is closed, tearing down the Short")
+	sh.teardown()
+
+	// Deletions made during the Short can be undone for DeletedRetention after it
+	end := sh.ModuleClose(len(sh.Config.Modules) - 1)
+	if !sh.sleepUntil(end.Add(sh.Config.DeletedRetention)) {
 		return
 	}
+	if err := PurgeDeleted(context.Background(), sh.DB, end); err != nil {
+		logger.Error.Printf("error purging deleted participants and modules: %v", err)
+	}
+}
+
+// Permanently removes the participants and modules deleted before `before`, along with
+// everything belonging to the participants.
+func PurgeDeleted(ctx context.Context, database *db.DB, before time.Time) error {
+	return dao.WithTx(ctx, database, func(tx *dao.Tx) error {
+		modules, err := dao.NewDAO[dao.Module](database).In(tx).Purge(ctx, before)
+		if err != nil {
+			return err
+		}
+		participants, err := dao.NewDAO[dao.Participant](database).In(tx).Purge(ctx, before)
+		if err != nil {
+			return err
+		}
+		logger.Info.Printf("purged %d participants and %d modules deleted before %s", participants, modules, before.Format(time.RFC3339))
+		return nil
+	})
+}
+
+// Registers the organisation webhook. Repository webhooks are registered along with the repos,
+// the ones which already exist are updated in case WEBHOOK_SECRET was rotated.
+func (sh *Short) setupWebhooks() (err error) {
+	if sh.Config.WebhookScope == config.WebhookScopeOrganisation {
+		return sh.GitHubClient.EnsureOrgWebhook(sh.Config.WebhookURL, sh.Config.WebhookSecret.Current())
+	}
 
-	for time.Now().Before(sh.Config.StartTime.Add(sh.Config.ModuleDuration * time.Duration(currentModuleIdx))) {
-		time.Sleep(time.Until(sh.Config.StartTime.Add(sh.Config.ModuleDuration * time.Duration(currentModuleIdx))))
+	// Deleted participants may be restored, their repos need the current secret too
+	modules, err := dao.NewDAO[dao.Module](sh.DB).Query().WithDeleted().All(context.Background())
+	if err != nil {
+		return fmt.Errorf("could not fetch modules: %v", err)
+	}
+
+	var errs []error
+	for _, module := range modules {
+		repoName := fmt.Sprintf("%s-%02d", module.IntraLogin, module.Id)
+		if err := sh.GitHubClient.EnsureRepoWebhook(repoName, sh.Config.WebhookURL, sh.Config.WebhookSecret.Current()); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", repoName, err))
+		}
 	}
+	if len(errs) > 0 {
+		return fmt.Errorf("could not update the webhook on %d repos: %w", len(errs), errors.Join(errs...))
+	}
+	return nil
+}
 
-	logger.Info.Printf("current module index: %d, starting in %f seconds\n", currentModuleIdx, time.Until(sh.Config.StartTime.Add(sh.Config.ModuleDuration*time.Duration(currentModuleIdx))).Seconds()*-1)
+// Cleans up everything which should not outlive the Short.
+func (sh *Short) teardown() {
+	if sh.Config.WebhookScope == config.WebhookScopeOrganisation {
+		if err := sh.GitHubClient.DeleteOrgWebhook(sh.Config.WebhookURL); err != nil {
+			logger.Error.Printf("could not delete organisation webhook: %v", err)
+		}
+		return
+	}
 
-	if err := sh.launchModule(currentModuleIdx); err != nil {
-		logger.Error.Printf("error launching module %02d: %v", currentModuleIdx, err)
+	participants, err := dao.NewDAO[dao.Participant](sh.DB).Query().WithDeleted().All(context.Background())
+	if err != nil {
+		logger.Error.Printf("could not delete repository webhooks: could not fetch participants: %v", err)
 		return
 	}
 
-	for {
-		if time.Now().Before(sh.Config.StartTime.Add(sh.Config.ModuleDuration * time.Duration(currentModuleIdx+1))) {
-			time.Sleep(time.Until(sh.Config.StartTime.Add(sh.Config.ModuleDuration * time.Duration(currentModuleIdx+1))))
-		} else {
-			currentModuleIdx += 1
-			if err := sh.launchModule(currentModuleIdx); err != nil {
-				logger.Error.Printf("error launching module %02d: %v\n", currentModuleIdx, err)
-				return
-			} else if currentModuleIdx == len(sh.Config.Modules) {
-				logger.Info.Printf("last (%dth) module launch, returning from scheduler\n", len(sh.Config.Modules))
-				return
+	for moduleIdx := range sh.Config.Modules {
+		for _, participant := range participants {
+			repoName := fmt.Sprintf("%s-%02d", participant.IntraLogin, moduleIdx)
+			if err := sh.GitHubClient.DeleteRepoWebhook(repoName, sh.Config.WebhookURL); err != nil {
+				logger.Error.Printf("could not delete webhook on %s: %v", repoName, err)
 			}
 		}
 	}
@@ -142,6 +452,13 @@ func (sh *Short) Launch() (err error) {
 	globalIsRunning = true
 	globalMu.Unlock()
 
+	if err := sh.setupWebhooks(); err != nil {
+		globalMu.Lock()
+		globalIsRunning = false
+		globalMu.Unlock()
+		return fmt.Errorf("could not set up webhooks: %v", err)
+	}
+
 	go func() {
 		defer func() {
 			globalMu.Lock()
@@ -0,0 +1,302 @@
+package short
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42-Short/shortinette/config"
+	"github.com/42-Short/shortinette/dao"
+	"github.com/42-Short/shortinette/db"
+	"github.com/42-Short/shortinette/git"
+)
+
+func newTestShort(startTime time.Time) *Short {
+	return &Short{
+		Config: config.Config{
+			Modules:        make([]config.Module, 3),
+			ModuleDuration: 24 * time.Hour,
+			StartTime:      startTime,
+		},
+		stopChan: make(chan struct{}),
+	}
+}
+
+func TestOpenModules(t *testing.T) {
+	start := time.Date(2024, 11, 4, 9, 0, 0, 0, time.UTC)
+	sh := newTestShort(start)
+
+	tests := []struct {
+		now      time.Time
+		expected []int
+	}{
+		{start.Add(-time.Hour), nil},
+		{start, []int{0}},
+		{start.Add(30 * time.Hour), []int{1}},
+		{start.Add(72 * time.Hour), nil},
+	}
+
+	for _, test := range tests {
+		if modules := sh.openModules(test.now); !slices.Equal(modules, test.expected) {
+			t.Fatalf("open modules at %s: expected %v, got %v", test.now, test.expected, modules)
+		}
+	}
+}
+
+func TestOpenModulesDuringGracePeriod(t *testing.T) {
+	start := time.Date(2024, 11, 4, 9, 0, 0, 0, time.UTC)
+	sh := newTestShort(start)
+	sh.Config.ModuleCloseGrace = time.Hour
+
+	if modules := sh.openModules(start.Add(24*time.Hour + 30*time.Minute)); !slices.Equal(modules, []int{0, 1}) {
+		t.Fatalf("module 00 should still be open during its grace period, got %v", modules)
+	}
+}
+
+func TestEventsOrder(t *testing.T) {
+	start := time.Date(2024, 11, 4, 9, 0, 0, 0, time.UTC)
+	sh := newTestShort(start)
+	sh.Config.ModuleCloseGrace = time.Hour
+
+	events := sh.events()
+	names := make([]string, 0, len(events))
+	for idx, event := range events {
+		if idx > 0 && event.at.Before(events[idx-1].at) {
+			t.Fatalf("events are not sorted: %s before %s", events[idx-1].name, event.name)
+		}
+		names = append(names, event.name)
+	}
+
+	expected := []string{
+		"launching module 00",
+		"launching module 01",
+		"closing module 00",
+		"launching module 02",
+		"closing module 01",
+		"closing module 02",
+	}
+	if !slices.Equal(names, expected) {
+		t.Fatalf("expected events %v, got %v", expected, names)
+	}
+}
+
+func TestEventsCloseBeforeNextLaunchWithoutGrace(t *testing.T) {
+	sh := newTestShort(time.Date(2024, 11, 4, 9, 0, 0, 0, time.UTC))
+
+	events := sh.events()
+	if events[1].name != "closing module 00" || events[2].name != "launching module 01" {
+		t.Fatalf("a module should be closed before the next one is launched, got %s, %s", events[1].name, events[2].name)
+	}
+}
+
+func TestSelectFinalAttempt(t *testing.T) {
+	deadline := time.Date(2024, 11, 5, 9, 0, 0, 0, time.UTC)
+	attempts := []dao.Attempt{
+		{Id: "first", Score: 60, GradedAt: deadline.Add(-5 * time.Hour)},
+		{Id: "best", Score: 80, GradedAt: deadline.Add(-4 * time.Hour)},
+		{Id: "tie", Score: 80, GradedAt: deadline.Add(-3 * time.Hour)},
+		{Id: "last", Score: 20, GradedAt: deadline.Add(-time.Hour)},
+		{Id: "late", Score: 100, GradedAt: deadline.Add(time.Minute)},
+	}
+
+	if attempt := selectFinalAttempt(attempts, config.FinalGradeBest, deadline); attempt == nil || attempt.Id != "best" {
+		t.Fatalf("best rule: expected the earliest attempt with the best score before the deadline, got %v", attempt)
+	}
+	if attempt := selectFinalAttempt(attempts, config.FinalGradeLast, deadline); attempt == nil || attempt.Id != "last" {
+		t.Fatalf("last rule: expected the last attempt before the deadline, got %v", attempt)
+	}
+	if attempt := selectFinalAttempt(attempts[4:], config.FinalGradeBest, deadline); attempt != nil {
+		t.Fatalf("attempts after the deadline should not count, got %v", attempt)
+	}
+}
+
+func TestFreezeGrades(t *testing.T) {
+	database, err := db.NewDB(context.Background(), filepath.Join(t.TempDir(), "short.db"))
+	if err != nil {
+		t.Fatalf("failed to open DB: %v", err)
+	}
+	defer database.Close()
+	if err := database.Migrate(context.Background()); err != nil {
+		t.Fatalf("failed to migrate DB: %v", err)
+	}
+	if _, err := dao.SeedDB(database); err != nil {
+		t.Fatalf("failed to seed DB: %v", err)
+	}
+
+	start := time.Now().Add(-72 * time.Hour)
+	sh := newTestShort(start)
+	sh.DB = database
+	sh.Config.FinalGradeRule = config.FinalGradeBest
+	sh.Config.ModuleCloseGrace = time.Hour
+
+	attemptDAO := dao.NewDAO[dao.Attempt](database)
+	for i, score := range []int{30, 80, 50} {
+		attempt := dao.Attempt{Id: fmt.Sprint(i), ModuleId: 0, IntraLogin: "dummy_participant0", Score: score, GradedAt: start.Add(time.Duration(i+1) * time.Hour)}
+		if err := attemptDAO.Insert(context.Background(), attempt); err != nil {
+			t.Fatalf("failed to insert attempt: %v", err)
+		}
+	}
+	// Graded after the module's end, during the grace period before it closes
+	late := dao.Attempt{Id: "late", ModuleId: 0, IntraLogin: "dummy_participant0", Score: 100, GradedAt: sh.moduleEnd(0).Add(30 * time.Minute)}
+	if err := attemptDAO.Insert(context.Background(), late); err != nil {
+		t.Fatalf("failed to insert attempt: %v", err)
+	}
+
+	if err := sh.freezeGrades(0); err != nil {
+		t.Fatalf("failed to freeze grades: %v", err)
+	}
+
+	grades, err := dao.NewDAO[dao.FinalGrade](database).GetAll(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get final grades: %v", err)
+	}
+	if len(grades) != 20 {
+		t.Fatalf("expected a final grade for each of the 20 participants, got %d", len(grades))
+	}
+	grade, err := dao.NewDAO[dao.FinalGrade](database).Get(context.Background(), 0, "dummy_participant0")
+	if err != nil {
+		t.Fatalf("failed to get final grade: %v", err)
+	}
+	if grade.Score != 80 || grade.AttemptId != "1" {
+		t.Fatalf("expected the best attempt to be frozen, got %+v", grade)
+	}
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	ctx := context.Background()
+	database, err := db.NewDB(ctx, filepath.Join(t.TempDir(), "short.db"))
+	if err != nil {
+		t.Fatalf("failed to open DB: %v", err)
+	}
+	defer database.Close()
+	if err := database.Migrate(ctx); err != nil {
+		t.Fatalf("failed to migrate DB: %v", err)
+	}
+	if _, err := dao.SeedDB(database); err != nil {
+		t.Fatalf("failed to seed DB: %v", err)
+	}
+
+	participantDAO := dao.NewDAO[dao.Participant](database)
+	moduleDAO := dao.NewDAO[dao.Module](database)
+	end := time.Now()
+	if err := participantDAO.DeleteAt(ctx, end.Add(-time.Hour), "dummy_participant0"); err != nil {
+		t.Fatalf("failed to delete participant: %v", err)
+	}
+	if err := moduleDAO.DeleteAt(ctx, end.Add(time.Hour), 0, "dummy_participant1"); err != nil {
+		t.Fatalf("failed to delete module: %v", err)
+	}
+
+	if err := PurgeDeleted(ctx, database, end); err != nil {
+		t.Fatalf("failed to purge deleted records: %v", err)
+	}
+
+	participants, err := participantDAO.Query().WithDeleted().Count(ctx)
+	if err != nil || participants != 19 {
+		t.Fatalf("expected the deleted participant to be purged, got %d participants (%v)", participants, err)
+	}
+	modules, err := moduleDAO.Query().WithDeleted().Where("intra_login", dao.Eq, "dummy_participant0").Count(ctx)
+	if err != nil || modules != 0 {
+		t.Fatalf("expected the modules of the purged participant to be removed, got %d (%v)", modules, err)
+	}
+	if _, err := moduleDAO.Query().OnlyDeleted().Where("intra_login", dao.Eq, "dummy_participant1").First(ctx); err != nil {
+		t.Fatalf("expected the module deleted after the cutoff to be kept: %v", err)
+	}
+}
+
+func TestSetupRepoWebhooksReportsFailingRepos(t *testing.T) {
+	database, err := db.NewDB(context.Background(), filepath.Join(t.TempDir(), "short.db"))
+	if err != nil {
+		t.Fatalf("failed to open DB: %v", err)
+	}
+	defer database.Close()
+	if err := database.Migrate(context.Background()); err != nil {
+		t.Fatalf("failed to migrate DB: %v", err)
+	}
+	if _, err := dao.SeedDB(database); err != nil {
+		t.Fatalf("failed to seed DB: %v", err)
+	}
+
+	// Every repo has no webhook yet, creating the one of dummy_participant3-02 fails
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/repos/orga/dummy_participant3-02/"):
+			http.Error(w, "boom", http.StatusInternalServerError)
+		case r.Method == http.MethodGet:
+			_, _ = w.Write([]byte("[]"))
+		default:
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"id": 1}`))
+		}
+	}))
+	defer server.Close()
+
+	gh := git.NewGithubService("token", "orga", t.TempDir())
+	baseURL, err := url.Parse(server.URL + "/")
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	gh.Client.BaseURL = baseURL
+
+	sh := newTestShort(time.Now())
+	sh.DB = database
+	sh.GitHubClient = *gh
+	sh.Config.WebhookScope = config.WebhookScopeRepository
+	sh.Config.WebhookURL = "https://shortinette.example.com/shortinette/webhook/grademe"
+	sh.Config.WebhookSecret = config.NewSecret("secret", "", time.Time{})
+
+	err = sh.setupWebhooks()
+	if err == nil || !strings.Contains(err.Error(), "dummy_participant3-02") {
+		t.Fatalf("expected the failing repo to be named, got %v", err)
+	}
+	if strings.Contains(err.Error(), "dummy_participant3-01") {
+		t.Fatalf("only the failing repo should be named, got %v", err)
+	}
+}
+
+func TestAddModuleKeepsDeletedModule(t *testing.T) {
+	ctx := context.Background()
+	database, err := db.NewDB(ctx, filepath.Join(t.TempDir(), "short.db"))
+	if err != nil {
+		t.Fatalf("failed to open DB: %v", err)
+	}
+	defer database.Close()
+	if err := database.Migrate(ctx); err != nil {
+		t.Fatalf("failed to migrate DB: %v", err)
+	}
+	if _, err := dao.SeedDB(database); err != nil {
+		t.Fatalf("failed to seed DB: %v", err)
+	}
+
+	moduleDAO := dao.NewDAO[dao.Module](database)
+	participant, err := dao.NewDAO[dao.Participant](database).Get(ctx, "dummy_participant0")
+	if err != nil {
+		t.Fatalf("failed to get participant: %v", err)
+	}
+	if err := moduleDAO.Delete(ctx, 0, participant.IntraLogin); err != nil {
+		t.Fatalf("failed to delete module: %v", err)
+	}
+
+	if err := addModule(moduleDAO, 0, *participant); err != nil {
+		t.Fatalf("adding a module which was deleted should not fail: %v", err)
+	}
+	if _, err := moduleDAO.Query().OnlyDeleted().Where("id", dao.Eq, 0).Where("intra_login", dao.Eq, participant.IntraLogin).First(ctx); err != nil {
+		t.Fatalf("expected the deleted module to stay deleted: %v", err)
+	}
+
+	if _, err := moduleDAO.Purge(ctx, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("failed to purge module: %v", err)
+	}
+	if err := addModule(moduleDAO, 0, *participant); err != nil {
+		t.Fatalf("failed to add module: %v", err)
+	}
+	if _, err := moduleDAO.Get(ctx, 0, participant.IntraLogin); err != nil {
+		t.Fatalf("expected the module to be added: %v", err)
+	}
+}